@@ -1,106 +1,639 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/treyburn/lockbox/internal/pkg/config"
 	"github.com/treyburn/lockbox/internal/pkg/logger"
+	"github.com/treyburn/lockbox/internal/pkg/metrics"
 	api "github.com/treyburn/lockbox/internal/pkg/service/http"
+	"github.com/treyburn/lockbox/internal/pkg/snapshot"
 	"github.com/treyburn/lockbox/internal/pkg/store"
+	"github.com/treyburn/lockbox/internal/pkg/tracing"
 )
 
-//nolint:cyclop // TODO refactor logger setup
-func initializeLogger(cache store.Store) (logger.TransactionLog, error) {
-	// TODO - I believe this needs to be 0755 in order for the file to be shared between copies?
-	file, err := os.OpenFile("/var/log/transaction.log", os.O_RDWR|os.O_APPEND, 0o755) //nolint:gosec // TODO - investigate
-	if err != nil {
-		return nil, fmt.Errorf("error opening transaction log file: %w", err)
-	}
-	log := logger.NewFileTransactionLogger(file)
-
-	// db backed logger setup
-	// logger, err := store.NewPostgresTransactionLogger(store.PostgresDBParams{
-	// 	Host:     os.Getenv("POSTGRES_HOST"),
-	// 	Port:     5432,
-	// 	User:     os.Getenv("POSTGRES_USER"),
-	// 	Password: os.Getenv("POSTGRES_PASSWORD"),
-	// 	Database: os.Getenv("POSTGRES_DATABASE"),
-	// })
-	// if err != nil {
-	// 	return nil, fmt.Errorf("error opening postgres transaction log: %w", err)
-	// }
-
-	events, errs := log.ReadEvents()
-
-	e, ok := logger.Event{}, true
-	for ok && err == nil {
+const (
+	// lockRetryInterval is how often a following replica checks whether it can take over the log
+	lockRetryInterval = time.Second
+	// repairInterval is how often a transaction log which has failed is retried while the service is degraded
+	repairInterval = 5 * time.Second
+)
+
+// awaitLog blocks until this replica holds the lock on the shared transaction log, which makes it the writer. Only one
+// replica writes to the log at a time, the others follow along and take over should the writer die. It gives up if
+// the follower stops with an error.
+func awaitLog(dir string, followerErrs <-chan error) (*logger.DirLock, error) {
+	for {
+		lock, err := logger.LockDir(dir)
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, logger.ErrLogLocked) {
+			return nil, err
+		}
+
 		select {
-		case e, ok = <-events:
-			if !ok {
-				// channel was closed
-				break
-			}
-			slog.Debug(fmt.Sprintf("event: %+v", e))
-			switch e.Kind {
-			case logger.EventPut:
-				err = cache.Put(e.Key, e.Value)
-			case logger.EventDelete:
-				err = cache.Delete(e.Key)
-			default:
-				err = fmt.Errorf("unknown event kind: %d", e.Kind)
-			}
-		case err, ok = <-errs:
-			if !ok {
-				// channel was closed
-				break
-			}
-			slog.Error(fmt.Sprintf("error reading events: %v", err))
+		case err = <-followerErrs:
+			return nil, fmt.Errorf("error following transaction log: %w", err)
+		case <-time.After(lockRetryInterval):
 		}
 	}
+}
 
+// restoreSnapshot loads the newest snapshot in dir, if there is one, or else an empty snapshot covering nothing.
+func restoreSnapshot(dir string) (snapshot.Snapshot, error) {
+	snap, err := snapshot.LoadLatest(dir)
+	if errors.Is(err, snapshot.ErrNoSnapshot) {
+		return snapshot.Snapshot{Data: make(map[string]string)}, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error processing events at logger startup: %w", err)
+		return snapshot.Snapshot{}, fmt.Errorf("error loading snapshot: %w", err)
 	}
 
-	log.Run()
+	slog.Info("restored snapshot", slog.Uint64("sequence", snap.Sequence), slog.Int("keys", len(snap.Data)))
+	return snap, nil
+}
 
-	return log, err
+// contents returns what the store holds once snap is restored into it. Keys from snapshots written before versions were
+// kept take the snapshot's sequence as their version, which is no earlier than the write that gave them their value.
+func contents(snap snapshot.Snapshot) store.Contents {
+	return store.Contents{
+		Data:         snap.Data,
+		Expiry:       snap.Expiry,
+		Versions:     snap.Versions,
+		Version:      snap.Sequence,
+		ContentTypes: snap.ContentTypes,
+		Metadata:     snap.Metadata,
+	}
 }
 
-func main() {
-	slog.SetLogLoggerLevel(slog.LevelDebug)
-	slog.Info("Starting API server")
-	cache := store.NewInMemoryStore()
-	log, err := initializeLogger(cache)
+// newStore returns the store the replica serves, which is only split into shards if it's configured to be.
+func newStore(cfg config.StoreConfig) store.Memory {
+	if cfg.Shards == 1 {
+		return store.NewInMemoryStore()
+	}
+	return store.NewShardedStore(store.WithShards(cfg.Shards))
+}
+
+// applyEvent applies an event read from the transaction log to the store. Once read an event is applied whatever
+// happens to the read, so that the store never stops part way through a sequence number.
+func applyEvent(cache store.Store, e logger.Event) error {
+	slog.Debug(fmt.Sprintf("event: %+v", e))
+	ctx := context.Background()
+	switch e.Kind {
+	case logger.EventPut:
+		// a key logged with an expiry which has since passed is put and immediately expires, rather than coming back
+		return cache.Put(ctx, e.Key, e.Value, store.ExpiresAt(e.Expires), store.WithVersion(e.Sequence),
+			store.WithContentType(e.ContentType), store.WithMetadata(e.Metadata))
+	case logger.EventDelete:
+		return cache.Delete(ctx, e.Key, store.WithVersion(e.Sequence))
+	case logger.EventBatch:
+		ops, err := batchOps(e.Batch)
+		if err != nil {
+			return err
+		}
+		return cache.Batch(ctx, ops, store.WithVersion(e.Sequence))
+	default:
+		return fmt.Errorf("unknown event kind: %d", e.Kind)
+	}
+}
+
+// batchOps returns the store ops which apply the ops of a batch read from the transaction log. Their preconditions
+// were checked before the batch was logged, so replaying it applies them regardless.
+func batchOps(events []logger.Event) ([]store.Op, error) {
+	ops := make([]store.Op, 0, len(events))
+	for _, e := range events {
+		switch e.Kind {
+		case logger.EventPut:
+			ops = append(ops, store.PutOp(e.Key, e.Value, store.ExpiresAt(e.Expires),
+				store.WithContentType(e.ContentType), store.WithMetadata(e.Metadata)))
+		case logger.EventDelete:
+			ops = append(ops, store.DeleteOp(e.Key))
+		case logger.EventBatch:
+			return nil, errors.New("batches can't be nested")
+		default:
+			return nil, fmt.Errorf("unknown batch op kind: %d", e.Kind)
+		}
+	}
+	return ops, nil
+}
+
+// applyTo returns a function which applies events to the store, recording in health how far it has got.
+func applyTo(cache store.Store, health *api.Health) func(logger.Event) error {
+	return func(e logger.Event) error {
+		if err := applyEvent(cache, e); err != nil {
+			return err
+		}
+		health.Progress(e.Sequence)
+		return nil
+	}
+}
+
+// lagCheck fails while the follower is lagging behind the transaction log, so that the load balancer doesn't send
+// reads to a replica serving stale data.
+func lagCheck(follower *logger.Follower) api.Check {
+	return func(context.Context) error {
+		if follower.Lagging() {
+			return fmt.Errorf("lagging %s behind the transaction log", follower.Lag().Round(time.Millisecond))
+		}
+		return nil
+	}
+}
+
+// syncPolicy returns the logger's SyncPolicy for the configured one.
+func syncPolicy(cfg config.FileConfig) logger.SyncPolicy {
+	switch cfg.Sync {
+	case config.SyncAlways:
+		return logger.SyncAlways()
+	case config.SyncInterval:
+		return logger.SyncInterval(cfg.SyncInterval)
+	case config.SyncEvery:
+		return logger.SyncEveryN(cfg.SyncEvery)
+	default:
+		return logger.SyncNever()
+	}
+}
+
+// replay applies the events in the log after the given sequence, returning the last sequence applied. It gives up if
+// ctx is done first.
+func replay(ctx context.Context, log logger.EventReader, after uint64, apply func(logger.Event) error) (uint64, error) {
+	last, err := logger.Recover(ctx, log, after, logger.RecoveryPoint{}, apply)
 	if err != nil {
-		slog.Error(fmt.Sprintf("error initializing logger: %v", err))
+		return 0, fmt.Errorf("error processing events at logger startup: %w", err)
+	}
+	return last, nil
+}
+
+// replica is what the parts of this replica which start and supervise the store and its transaction log share.
+type replica struct {
+	cfg config.Config
+	lc  *lifecycle
+	svc *api.Service
+	// cache is the store behind svc, which the transaction log is replayed into
+	cache  store.Memory
+	health *api.Health
+	// logMetrics instruments each transaction log in turn, as the replica may replace its log
+	logMetrics *metrics.LogMetrics
+}
+
+// apply returns a function which applies events to the store, recording how far it has got.
+func (r *replica) apply() func(logger.Event) error {
+	return applyTo(r.cache, r.health)
+}
+
+// openFileLog opens the file transaction log, which must then be replayed from seq before it can be written to.
+func openFileLog(cfg config.LoggerConfig, lock *logger.DirLock, seq uint64) (*logger.FileTransactionLogger, error) {
+	if err := logger.MigrateToSegments(cfg.File.LegacyPath, cfg.File.Dir); err != nil {
+		return nil, fmt.Errorf("error migrating transaction log: %w", err)
+	}
+
+	opts := []logger.FileOption{
+		// a crash mid-write leaves a partial record at the end of the log, trim it rather than refusing to boot
+		logger.WithRecoveryMode(logger.RecoveryRepair),
+		logger.WithSyncPolicy(syncPolicy(cfg.File)),
+		logger.WithStartSequence(seq),
+		logger.WithDirLock(lock),
+		logger.WithSegmentSize(cfg.File.SegmentSize),
+		logger.WithSegmentAge(cfg.File.SegmentAge),
+	}
+	if cfg.Durable {
+		// don't acknowledge a write to the client until it has been written, concurrent writes share an fsync
+		opts = append(opts, logger.WithDurableWrites())
+	}
+	if cfg.File.ArchiveDir != "" {
+		// keep compacted history around for point in time recovery
+		opts = append(opts, logger.WithSegmentArchive(cfg.File.ArchiveDir))
+	}
+
+	log, err := logger.NewSegmentedFileTransactionLogger(cfg.File.Dir, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error opening transaction log: %w", err)
+	}
+	return log, nil
+}
+
+// startWriter replays the transaction log into the store and makes this replica the writer, periodically snapshotting
+// the store so that the log can be compacted and startup doesn't replay all of history.
+func (r *replica) startWriter(ctx context.Context, lock *logger.DirLock, seq uint64) error {
+	file, err := openFileLog(r.cfg.Logger, lock, seq)
+	if err != nil {
+		return err
+	}
+	log := r.logMetrics.Instrument(file)
+
+	// the logger has to read to the end of the log to know where to carry on writing from
+	if _, err = replay(ctx, log, seq, r.apply()); err != nil {
+		return errors.Join(err, log.Close())
+	}
+
+	if !r.lc.start("transaction log", log, log.Run) {
+		// shutting down, give the log up without having written to it
+		return log.Close()
+	}
+	r.svc.SetTransactionLog(log)
+	r.health.RemoveWriteCheck("writer")
+	r.health.AddWriteCheck("transaction log", degradedCheck(r.svc))
+	// the writer goroutine stops at the first error, after which the log has to be reopened before anything more can
+	// be written to it
+	go r.svc.Monitor(log.Err(), func() error {
+		// repairs carry on after startup, until the log is closed on shutdown
+		return r.restartWriter(context.WithoutCancel(ctx), log)
+	}, repairInterval)
+
+	snapshotter := snapshot.NewSnapshotter(r.cfg.Snapshot.Dir, snapshot.SourceFunc(func() (snapshot.Snapshot, error) {
+		var snap snapshot.Snapshot
+		err := r.svc.Quiesce(func() error {
+			var err error
+			snap, err = captureSnapshot(log, r.cache)
+			return err
+		})
+		return snap, err
+	}), log, snapshot.WithInterval(r.cfg.Snapshot.Interval), snapshot.WithRetain(r.cfg.Snapshot.Retain))
+	r.lc.start("snapshotter", snapshotter, snapshotter.Run)
+
+	return nil
+}
+
+// captureSnapshot snapshots cache along with the last sequence written to log, which nothing may be written to while
+// it runs.
+func captureSnapshot(log logger.TransactionManager, cache store.Memory) (snapshot.Snapshot, error) {
+	seq, err := log.LastSequence()
+	if err != nil {
+		return snapshot.Snapshot{}, err
+	}
+	contents := cache.Snapshot()
+	return snapshot.Snapshot{
+		Sequence:     seq,
+		Data:         contents.Data,
+		Expiry:       contents.Expiry,
+		Versions:     contents.Versions,
+		ContentTypes: contents.ContentTypes,
+		Metadata:     contents.Metadata,
+	}, nil
+}
+
+// restartWriter replaces a transaction log which has failed with a freshly opened one, which carries on from the last
+// event the failed log wrote. Closing the failed log gives up the lock on it, and should another replica take over in
+// the meantime we exit, to start over as a follower of it.
+func (r *replica) restartWriter(ctx context.Context, failed logger.TransactionManager) error {
+	seq, err := failed.LastSequence()
+	if err != nil {
+		return fmt.Errorf("error reading last sequence: %w", err)
+	}
+
+	// the snapshotter compacts through the failed log, so it has to go first
+	running, err := r.lc.stop("snapshotter")
+	if !running || err != nil {
+		// either shutdown has begun, which closes the log instead, or we'll try again
+		return err
+	}
+	// no write can be on its way to the failed log once it's closed
+	err = r.svc.Quiesce(func() error {
+		running, err = r.lc.stop("transaction log")
+		return err
+	})
+	if !running || err != nil {
+		return err
+	}
+
+	lock, err := logger.LockDir(r.cfg.Logger.File.Dir)
+	if errors.Is(err, logger.ErrLogLocked) {
+		slog.Error("another replica took over the transaction log while it was being repaired")
 		os.Exit(1)
 	}
+	if err != nil {
+		return fmt.Errorf("error locking transaction log: %w", err)
+	}
 
-	svc := api.NewService(cache, log)
-	r := mux.NewRouter()
+	slog.Info("reopening transaction log", slog.Uint64("sequence", seq))
+	return r.startWriter(ctx, lock, seq)
+}
+
+// readOnlyCheck fails for as long as this replica isn't a writer of the transaction log, so that the load balancer
+// routes writes to one which is.
+func readOnlyCheck(context.Context) error {
+	return logger.ErrReadOnly
+}
 
-	// TODO - the svc must have a way to close that lets it drain its requests then close the logger
-	r.HandleFunc("/v1/{key}", svc.PutForKey).Methods(http.MethodPut)
-	r.HandleFunc("/v1/{key}", svc.GetByKey).Methods(http.MethodGet)
-	r.HandleFunc("/v1/{key}", svc.DeleteKey).Methods(http.MethodDelete)
+// degradedCheck fails while the service is in degraded mode, rejecting writes. It is a write check, as reads are still
+// served.
+func degradedCheck(svc *api.Service) api.Check {
+	return func(context.Context) error {
+		return svc.Degraded()
+	}
+}
 
-	// example for handling https directly
-	// const cert = "/etc/ssl/certs/app/cert.pem"
-	// const key = "/etc/ssl/certs/app/key.pem"
-	// err = http.ListenAndServeTLS(":8080", cert, key, r)
+// follow tails the transaction log into the store while another replica is the writer, so that this one can serve
+// reads, and takes over as the writer once the other replica goes away.
+func (r *replica) follow(ctx context.Context, seq uint64) error {
+	dir := r.cfg.Logger.File.Dir
+	follower := logger.NewFollower(logger.NewSegmentTailer(dir), seq, r.apply())
+	// catch up with the writer before serving anything
+	if err := follower.Poll(ctx); err != nil {
+		return fmt.Errorf("error reading transaction log: %w", err)
+	}
+	if !r.lc.start("follower", follower, follower.Run) {
+		return nil
+	}
+	r.health.AddCheck("follower", lagCheck(follower))
 
-	// TODO - need to enable a sane default timeout
-	err = http.ListenAndServe(":8080", r) //nolint:gosec // TODO - investigate
+	go func() {
+		lock, err := awaitLog(dir, follower.Err())
+		if err != nil {
+			// most likely we fell behind compaction, starting over will pick up a newer snapshot
+			slog.Error(fmt.Sprintf("error waiting to take over transaction log: %v", err))
+			os.Exit(1)
+		}
+
+		slog.Info("taking over transaction log", slog.Uint64("sequence", follower.Sequence()))
+		if err = follower.Close(); err != nil {
+			slog.Error(fmt.Sprintf("error stopping follower: %v", err))
+			os.Exit(1)
+		}
+		r.health.RemoveCheck("follower")
+
+		// the writer's gone, so whatever the follower hadn't applied yet is replayed along with the log's tail
+		// taking over happens long after startup, which ctx belongs to
+		if err = r.startWriter(context.WithoutCancel(ctx), lock, follower.Sequence()); err != nil {
+			slog.Error(fmt.Sprintf("error taking over transaction log: %v", err))
+			os.Exit(1)
+		}
+	}()
+
+	return nil
+}
+
+// startFile restores the store from the newest snapshot and the file transaction log, and either becomes the log's
+// writer or follows the replica which is.
+func (r *replica) startFile(ctx context.Context) error {
+	// the snapshot must be read under the lock when we're the writer, otherwise another replica could compact away the
+	// events it doesn't cover. A follower that loses that race fails with ErrCompacted and starts over.
+	lock, err := logger.LockDir(r.cfg.Logger.File.Dir)
+	if err != nil && !errors.Is(err, logger.ErrLogLocked) {
+		return fmt.Errorf("error locking transaction log: %w", err)
+	}
+
+	snap, err := restoreSnapshot(r.cfg.Snapshot.Dir)
 	if err != nil {
-		slog.Error(fmt.Sprintf("serrver error: %v", err))
+		return fmt.Errorf("error restoring snapshot: %w", err)
+	}
+	r.cache.Restore(contents(snap))
+	r.health.Progress(snap.Sequence)
+
+	if lock == nil {
+		slog.Info("transaction log is held by another replica, following it")
+		return r.follow(ctx, snap.Sequence)
+	}
+
+	if err = r.startWriter(ctx, lock, snap.Sequence); err != nil {
+		return fmt.Errorf("error initializing logger: %w", err)
+	}
+	return nil
+}
+
+// applyOthers returns a function which applies the events other replicas wrote to pg to the store. The events this
+// replica wrote are applied by the service as it writes them, which may be after the follower has applied a later
+// event for the same key, as the follower doesn't take the service's key locks. The store ignores a write older than
+// the version its key is already at, so whichever order the two arrive in the key ends up with the newer value.
+func (r *replica) applyOthers(pg *logger.PostgresTransactionLogger) func(logger.Event) error {
+	apply := r.apply()
+	return func(e logger.Event) error {
+		if pg.Wrote(e.Sequence) {
+			r.health.Progress(e.Sequence)
+			return nil
+		}
+		return apply(e)
+	}
+}
+
+// postgresParams returns the connection parameters for the configured database.
+func postgresParams(cfg config.PostgresConfig) logger.PostgresDBParams {
+	return logger.PostgresDBParams{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		User:     cfg.User,
+		Password: cfg.Password,
+		Database: cfg.Database,
+	}
+}
+
+// startPostgres replays the postgres transaction log into the store. Every replica writes to the same table, so each
+// also tails it to pick up the writes made through the others. The table isn't snapshotted or compacted, as no single
+// replica's store is known to hold every write up to a given sequence.
+func (r *replica) startPostgres(ctx context.Context) error {
+	cfg := r.cfg.Logger
+	var opts []logger.PostgresOption
+	if cfg.Durable {
+		opts = append(opts, logger.WithDurablePostgresWrites())
+	}
+
+	pg, err := logger.NewPostgresTransactionLogger(ctx, postgresParams(cfg.Postgres), opts...)
+	if err != nil {
+		return fmt.Errorf("error opening postgres transaction log: %w", err)
+	}
+	log := r.logMetrics.Instrument(pg)
+
+	apply := r.apply()
+	seq, err := replay(ctx, log, 0, apply)
+	if err != nil {
+		return errors.Join(err, log.Close())
+	}
+
+	if !r.lc.start("transaction log", log, log.Run) {
+		return log.Close()
+	}
+	r.svc.SetTransactionLog(log)
+	r.health.RemoveWriteCheck("writer")
+	r.health.AddWriteCheck("postgres", pg.Ping)
+	r.health.AddWriteCheck("transaction log", degradedCheck(r.svc))
+	// the writer goroutine carries on after an error, so the log has recovered once the database can be reached again
+	go r.svc.Monitor(log.Err(), func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), repairInterval)
+		defer cancel()
+		return pg.Ping(ctx)
+	}, repairInterval)
+
+	follower := logger.NewFollower(pg.Tail(), seq, r.applyOthers(pg))
+	if !r.lc.start("follower", follower, follower.Run) {
+		return nil
+	}
+	r.health.AddCheck("follower", lagCheck(follower))
+
+	go func() {
+		if err := <-follower.Err(); err != nil {
+			slog.Error(fmt.Sprintf("error following postgres transaction log: %v", err))
+			os.Exit(1)
+		}
+	}()
+
+	return nil
+}
+
+// newServer returns the API server, which serves the health endpoints and metrics from the start but the API only once
+// the replica has started.
+func newServer(cfg config.HTTPConfig, svc *api.Service, health *api.Health, reg *prometheus.Registry) *http.Server {
+	r := mux.NewRouter()
+	r.Use(tracing.Middleware, metrics.NewHTTPMetrics(reg).Middleware)
+
+	r.Handle("/metrics", metrics.Handler(reg)).Methods(http.MethodGet)
+	r.HandleFunc("/healthz", health.Live).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", health.Ready).Methods(http.MethodGet)
+	r.HandleFunc("/writablez", health.Writable).Methods(http.MethodGet)
+
+	v1 := r.PathPrefix("/v1").Subrouter()
+	v1.Use(health.RequireStarted)
+	v1.HandleFunc("", svc.ListKeys).Methods(http.MethodGet)
+	v1.HandleFunc("/", svc.ListKeys).Methods(http.MethodGet)
+	v1.HandleFunc("/_batch", svc.WriteBatch).Methods(http.MethodPost)
+	v1.HandleFunc("/_multiget", svc.GetMany).Methods(http.MethodPost)
+	v1.HandleFunc("/{key}", svc.PutForKey).Methods(http.MethodPut)
+	v1.HandleFunc("/{key}", svc.GetByKey).Methods(http.MethodGet, http.MethodHead)
+	v1.HandleFunc("/{key}", svc.DeleteKey).Methods(http.MethodDelete)
+
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// serve serves the API while start rebuilds the store, so that the health endpoints can report on startup, until ctx is
+// cancelled. It then shuts down, draining requests for up to the configured timeout.
+func serve(ctx context.Context, cfg config.Config, server *http.Server, health *api.Health, lc *lifecycle, start func() error) error {
+	served := make(chan error, 1)
+	go func() {
+		slog.Info("Starting API server", slog.String("addr", cfg.HTTP.Addr), slog.Bool("tls", cfg.HTTP.TLS()))
+		if cfg.HTTP.TLS() {
+			served <- server.ListenAndServeTLS(cfg.HTTP.TLSCert, cfg.HTTP.TLSKey)
+		} else {
+			served <- server.ListenAndServe()
+		}
+	}()
+
+	started := make(chan error, 1)
+	go func() {
+		started <- start()
+	}()
+
+	// whatever stopped us, anything which has started still needs stopping and the log flushing
+	err := await(ctx, served, started, health)
+
+	slog.Info("shutting down", slog.Duration("timeout", cfg.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	return errors.Join(err, shutdown(shutdownCtx, server, lc))
+}
+
+// await marks the replica as started once start has finished, and then waits for ctx to be cancelled. It returns early
+// if the server or startup fails.
+func await(ctx context.Context, served, started <-chan error, health *api.Health) error {
+	for {
+		select {
+		case err := <-served:
+			return fmt.Errorf("server error: %w", err)
+		case err := <-started:
+			if err != nil {
+				return err
+			}
+			slog.Info("started, ready to serve")
+			health.Started()
+			// a nil channel is never ready, so we carry on waiting on the others
+			started = nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error(err.Error())
 		os.Exit(1)
 	}
+}
+
+func run() error {
+	recovery := registerRecoveryFlags(flag.CommandLine)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	slog.SetLogLoggerLevel(cfg.LogLevel)
+
+	point, recovering, err := recovery.point()
+	if err != nil {
+		return err
+	}
+
+	// stop on ctrl-c locally, or when docker stops the container
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if recovery.export != "" {
+		return exportRecovery(ctx, cfg, point, recovery.export)
+	}
+
+	flushTraces, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	lc := &lifecycle{}
+	// started first so that it's stopped last, once the spans from flushing the transaction log have been made
+	lc.start("tracing", closerFunc(func() error {
+		return flushTraces(context.Background())
+	}), func() {})
+
+	reg := metrics.NewRegistry()
+	cache := newStore(cfg.Store)
+	sweeper := store.NewSweeper(cache, cfg.Store.SweepInterval)
+	lc.start("sweeper", sweeper, sweeper.Run)
+	var svcOpts []api.ServiceOption
+	if !cfg.Logger.Durable {
+		svcOpts = append(svcOpts, api.WithAsyncWrites())
+	}
+	r := &replica{
+		cfg:   cfg,
+		lc:    lc,
+		cache: cache,
+		// writes are turned away until this replica becomes a writer
+		svc:        api.NewService(metrics.NewStoreMetrics(reg).Instrument(cache), logger.ReadOnlyLog{}, svcOpts...),
+		health:     api.NewHealth(),
+		logMetrics: metrics.NewLogMetrics(reg),
+	}
+	r.health.AddWriteCheck("writer", readOnlyCheck)
+
+	start := func() error {
+		switch {
+		case recovering:
+			return r.startRecovery(ctx, point)
+		case cfg.Logger.Kind == config.LoggerPostgres:
+			return r.startPostgres(ctx)
+		default:
+			return r.startFile(ctx)
+		}
+	}
+
+	server := newServer(cfg.HTTP, r.svc, r.health, reg)
+	if err = serve(ctx, cfg, server, r.health, r.lc, start); err != nil {
+		return err
+	}
 
-	slog.Info("shutting down")
-	os.Exit(0)
+	slog.Info("shut down cleanly")
+	return nil
 }
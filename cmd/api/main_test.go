@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/treyburn/lockbox/internal/pkg/config"
+	"github.com/treyburn/lockbox/internal/pkg/logger"
+	api "github.com/treyburn/lockbox/internal/pkg/service/http"
+	"github.com/treyburn/lockbox/internal/pkg/snapshot"
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+// TestApplyEvent tests applying each kind of event read from the transaction log to the store, with every key it writes
+// taking the event's sequence number as its version
+func TestApplyEvent(t *testing.T) {
+	cache := store.NewInMemoryStore()
+	metadata := map[string]string{"owner": "payments"}
+
+	put := logger.PutOp("a", []byte("1"), logger.WithContentType("text/plain"), logger.WithMetadata(metadata))
+	put.Sequence = 3
+	require.NoError(t, applyEvent(cache, put))
+
+	entry, err := cache.Get(t.Context(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{Value: []byte("1"), Version: 3, ContentType: "text/plain", Metadata: metadata}, entry)
+
+	expired := logger.PutOp("b", []byte("2"))
+	expired.Sequence, expired.Expires = 4, time.Now().Add(-time.Minute)
+	require.NoError(t, applyEvent(cache, expired))
+	_, err = cache.Get(t.Context(), "b")
+	require.ErrorIs(t, err, store.ErrNotFound, "a key logged with an expiry which has passed doesn't come back")
+
+	batch := logger.Event{Sequence: 7, Kind: logger.EventBatch, Batch: []logger.Event{
+		logger.PutOp("c", []byte("3")),
+		logger.DeleteOp("a"),
+	}}
+	require.NoError(t, applyEvent(cache, batch))
+
+	entry, err = cache.Get(t.Context(), "c")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{Value: []byte("3"), Version: 7}, entry)
+	_, err = cache.Get(t.Context(), "a")
+	require.ErrorIs(t, err, store.ErrNotFound)
+
+	c := logger.DeleteOp("c")
+	c.Sequence = 8
+	require.NoError(t, applyEvent(cache, c))
+	_, err = cache.Get(t.Context(), "c")
+	require.ErrorIs(t, err, store.ErrNotFound)
+
+	assert.ErrorContains(t, applyEvent(cache, logger.Event{Sequence: 9, Kind: 42}), "unknown event kind")
+}
+
+// TestBatchOps tests turning the ops of a batch read from the transaction log into store ops
+func TestBatchOps(t *testing.T) {
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	metadata := map[string]string{"owner": "payments"}
+
+	put := logger.PutOp("a", []byte("1"), logger.WithContentType("text/plain"), logger.WithMetadata(metadata))
+	put.Expires = expires
+
+	tests := []struct {
+		name    string
+		events  []logger.Event
+		want    []store.Op
+		wantErr string
+	}{
+		{
+			name:   "puts and deletes",
+			events: []logger.Event{put, logger.DeleteOp("b")},
+			want: []store.Op{
+				store.PutOp("a", []byte("1"), store.ExpiresAt(expires), store.WithContentType("text/plain"),
+					store.WithMetadata(metadata)),
+				store.DeleteOp("b"),
+			},
+		},
+		{name: "empty", events: nil, want: []store.Op{}},
+		{
+			name:    "nested batch",
+			events:  []logger.Event{{Kind: logger.EventBatch, Batch: []logger.Event{logger.DeleteOp("b")}}},
+			wantErr: "can't be nested",
+		},
+		{name: "unknown kind", events: []logger.Event{{Kind: 42, Key: "a"}}, wantErr: "unknown batch op kind"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ops, err := batchOps(tc.events)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, ops)
+		})
+	}
+}
+
+// TestNewStore tests that the store is only sharded when it's configured to be
+func TestNewStore(t *testing.T) {
+	assert.IsType(t, &store.InMemoryStore{}, newStore(config.StoreConfig{Shards: 1}))
+	assert.IsType(t, &store.ShardedStore{}, newStore(config.StoreConfig{Shards: 8}))
+}
+
+// TestSnapshotFallback tests that a writer which has to fall back past a damaged snapshot to the one before it still
+// finds every event written since in the transaction log, so that no keys are lost and no sequence is reused
+func TestSnapshotFallback(t *testing.T) {
+	cfg := config.LoggerConfig{
+		Durable: true,
+		// every write gets a segment of its own, so compaction removes each one as soon as it can
+		File: config.FileConfig{Dir: filepath.Join(t.TempDir(), "log"), SegmentSize: 1, Sync: config.SyncAlways},
+	}
+	snapshots := filepath.Join(t.TempDir(), "snapshots")
+
+	// start brings a writer up the way startWriter does, from the newest readable snapshot and the log since
+	start := func() (*logger.FileTransactionLogger, store.Memory) {
+		snap, err := restoreSnapshot(snapshots)
+		require.NoError(t, err)
+		cache := newStore(config.StoreConfig{Shards: 1})
+		cache.Restore(contents(snap))
+
+		log, err := openFileLog(cfg, nil, snap.Sequence)
+		require.NoError(t, err)
+		_, err = replay(t.Context(), log, snap.Sequence, applyTo(cache, api.NewHealth()))
+		require.NoError(t, err)
+		log.Run()
+		return log, cache
+	}
+	put := func(log logger.TransactionManager, cache store.Memory, key string) uint64 {
+		seq, err := log.WritePut(t.Context(), key, []byte(key))
+		require.NoError(t, err)
+		require.NoError(t, cache.Put(t.Context(), key, []byte(key), store.WithVersion(seq)))
+		return seq
+	}
+
+	log, cache := start()
+	snapshotter := snapshot.NewSnapshotter(snapshots, snapshot.SourceFunc(func() (snapshot.Snapshot, error) {
+		return captureSnapshot(log, cache)
+	}), log, snapshot.WithRetain(2))
+	put(log, cache, "a")
+	require.NoError(t, snapshotter.Snapshot())
+	// b's segment is sealed by the time the second snapshot is taken, so compacting through that would remove it
+	put(log, cache, "b")
+	put(log, cache, "c")
+	require.NoError(t, snapshotter.Snapshot())
+	put(log, cache, "d")
+	require.NoError(t, log.Close())
+
+	// damage the newest snapshot, so that the writer has to fall back to the one before it
+	require.NoError(t, os.WriteFile(filepath.Join(snapshots, "snapshot-00000000000000000003.snap"), []byte("corrupt"), 0o600))
+
+	log, cache = start()
+	defer func() { _ = log.Close() }()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		entry, err := cache.Get(t.Context(), key)
+		require.NoError(t, err, key)
+		assert.Equal(t, []byte(key), entry.Value)
+	}
+	assert.Equal(t, uint64(5), put(log, cache, "e"), "the writer carries on from the last event logged")
+}
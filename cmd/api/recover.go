@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/treyburn/lockbox/internal/pkg/config"
+	"github.com/treyburn/lockbox/internal/pkg/logger"
+	api "github.com/treyburn/lockbox/internal/pkg/service/http"
+	"github.com/treyburn/lockbox/internal/pkg/snapshot"
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+// recoveryFlags are the command line flags which start the service in recovery mode, where instead of serving the live
+// store it rebuilds the store as it was at some point in the past.
+type recoveryFlags struct {
+	sequence uint64
+	time     string
+	export   string
+}
+
+func registerRecoveryFlags(fs *flag.FlagSet) *recoveryFlags {
+	f := &recoveryFlags{}
+	fs.Uint64Var(&f.sequence, "recover-sequence", 0,
+		"recover the store as it was at this transaction log sequence number, and serve it read only")
+	fs.StringVar(&f.time, "recover-time", "",
+		"recover the store as it was at this RFC 3339 time, and serve it read only")
+	fs.StringVar(&f.export, "recover-export", "",
+		"write the recovered store to this file as JSON, or - for stdout, and exit rather than serving it")
+	return f
+}
+
+// point returns the recovery point given by the flags, and whether recovery was asked for at all.
+func (f *recoveryFlags) point() (logger.RecoveryPoint, bool, error) {
+	point := logger.RecoveryPoint{Sequence: f.sequence}
+	if f.time != "" {
+		t, err := time.Parse(time.RFC3339Nano, f.time)
+		if err != nil {
+			return logger.RecoveryPoint{}, false, fmt.Errorf("invalid recovery time: %w", err)
+		}
+		point.Time = t
+	}
+
+	recovering := point != logger.RecoveryPoint{}
+	if f.export != "" && !recovering {
+		return logger.RecoveryPoint{}, false, errors.New("-recover-export needs -recover-sequence or -recover-time")
+	}
+	return point, recovering, nil
+}
+
+// startRecovery rebuilds the store as it was at the recovery point, to be served read only, since writing to it would
+// diverge from the log.
+func (r *replica) startRecovery(ctx context.Context, point logger.RecoveryPoint) error {
+	slog.Info("recovering store", slog.Uint64("sequence", point.Sequence), slog.Time("time", point.Time))
+	if err := recoverStore(ctx, r.cfg, point, r.cache, r.health); err != nil {
+		return fmt.Errorf("error recovering store: %w", err)
+	}
+	return nil
+}
+
+// exportRecovery rebuilds the store as it was at the recovery point and exports it to path.
+func exportRecovery(ctx context.Context, cfg config.Config, point logger.RecoveryPoint, path string) error {
+	slog.Info("recovering store", slog.Uint64("sequence", point.Sequence), slog.Time("time", point.Time))
+	cache := newStore(cfg.Store)
+	if err := recoverStore(ctx, cfg, point, cache, api.NewHealth()); err != nil {
+		return fmt.Errorf("error recovering store: %w", err)
+	}
+	return exportStore(cache, path)
+}
+
+// recoverStore rebuilds the store as it was at the recovery point from the transaction log, without touching the live
+// log or snapshots. A snapshot can only be used as the starting point when recovering to a sequence number, as we don't
+// know when the events it covers were written, so recovering to a time replays all of the history we have kept.
+func recoverStore(ctx context.Context, cfg config.Config, point logger.RecoveryPoint, cache store.Memory, health *api.Health) error {
+	snap := snapshot.Snapshot{Data: make(map[string]string)}
+	if point.Sequence != 0 && point.Time.IsZero() && cfg.Logger.Kind == config.LoggerFile {
+		loaded, err := snapshot.LoadThrough(cfg.Snapshot.Dir, point.Sequence)
+		switch {
+		case errors.Is(err, snapshot.ErrNoSnapshot):
+		case err != nil:
+			return fmt.Errorf("error loading snapshot: %w", err)
+		default:
+			slog.Info("restored snapshot", slog.Uint64("sequence", loaded.Sequence), slog.Int("keys", len(loaded.Data)))
+			snap = loaded
+		}
+	}
+
+	cache.Restore(contents(snap))
+	health.Progress(snap.Sequence)
+
+	log, closeLog, err := openHistory(ctx, cfg.Logger)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	last, err := logger.Recover(ctx, log, snap.Sequence, point, applyTo(cache, health))
+	if errors.Is(err, logger.ErrCompacted) {
+		return fmt.Errorf("history before the recovery point is no longer kept: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("error replaying transaction log: %w", err)
+	}
+	if point.Sequence != 0 && last < point.Sequence {
+		slog.Warn("transaction log ends before the recovery point", slog.Uint64("sequence", last))
+	}
+
+	slog.Info("recovered store", slog.Uint64("sequence", last), slog.Int("keys", cache.Len()))
+	return nil
+}
+
+// openHistory opens the configured transaction log for reading all of the history which has been kept.
+func openHistory(ctx context.Context, cfg config.LoggerConfig) (logger.EventReader, func(), error) {
+	if cfg.Kind == config.LoggerPostgres {
+		// the table belongs to the running service, which is left to create or migrate it
+		log, err := logger.NewPostgresReader(ctx, postgresParams(cfg.Postgres))
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening postgres transaction log: %w", err)
+		}
+		return log, func() { _ = log.Close() }, nil
+	}
+
+	// the writer may still be running, so read the log without locking it
+	dirs := []string{cfg.File.Dir}
+	if cfg.File.ArchiveDir != "" {
+		dirs = []string{cfg.File.ArchiveDir, cfg.File.Dir}
+	}
+	return logger.NewSegmentReader(dirs...), func() {}, nil
+}
+
+// exportStore writes the contents of the store to path as a JSON object of keys to values, with - meaning stdout. Each
+// value is carried as it is in a listing, so that binary values survive the export.
+func exportStore(cache store.Memory, path string) error {
+	contents := cache.Snapshot().Data
+	values := make(map[string]api.JSONValue, len(contents))
+	for key, value := range contents {
+		values[key] = api.NewJSONValue([]byte(value))
+	}
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding store: %w", err)
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(path, data, 0o600)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing export: %w", err)
+	}
+
+	return nil
+}
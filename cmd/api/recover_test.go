@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/treyburn/lockbox/internal/pkg/logger"
+)
+
+// TestRecoveryFlags tests turning the recovery flags into the point to recover to
+func TestRecoveryFlags(t *testing.T) {
+	at := time.Date(2024, 5, 6, 12, 0, 0, 500, time.UTC)
+
+	tests := []struct {
+		name       string
+		args       []string
+		want       logger.RecoveryPoint
+		recovering bool
+		wantErr    string
+	}{
+		{name: "none", args: nil},
+		{name: "sequence", args: []string{"-recover-sequence", "42"}, want: logger.RecoveryPoint{Sequence: 42}, recovering: true},
+		{
+			name:       "time",
+			args:       []string{"-recover-time", "2024-05-06T12:00:00.0000005Z"},
+			want:       logger.RecoveryPoint{Time: at},
+			recovering: true,
+		},
+		{
+			name:       "sequence and time",
+			args:       []string{"-recover-sequence", "42", "-recover-time", "2024-05-06T12:00:00.0000005Z"},
+			want:       logger.RecoveryPoint{Sequence: 42, Time: at},
+			recovering: true,
+		},
+		{
+			name:       "export",
+			args:       []string{"-recover-sequence", "42", "-recover-export", "-"},
+			want:       logger.RecoveryPoint{Sequence: 42},
+			recovering: true,
+		},
+		{name: "invalid time", args: []string{"-recover-time", "yesterday"}, wantErr: "invalid recovery time"},
+		{name: "export without a point", args: []string{"-recover-export", "-"}, wantErr: "needs -recover-sequence"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("api", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			flags := registerRecoveryFlags(fs)
+			require.NoError(t, fs.Parse(tc.args))
+
+			point, recovering, err := flags.point()
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, point)
+			assert.Equal(t, tc.recovering, recovering)
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// lifecycle keeps track of everything running in the background which has to be stopped on shutdown. Things are
+// stopped in the reverse of the order they were started, so that whatever feeds the store and the transaction log,
+// like the snapshotter and followers, stops before the log itself is closed.
+type lifecycle struct {
+	mu       sync.Mutex
+	stopping bool
+	closers  []namedCloser
+}
+
+type namedCloser struct {
+	name string
+	io.Closer
+}
+
+// closerFunc adapts a function to io.Closer, for things which stop some other way.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// start calls run, which starts c in the background, and registers c to be stopped on shutdown. If shutdown has
+// already begun it does neither and reports false, leaving the caller to clean up c.
+func (l *lifecycle) start(name string, c io.Closer, run func()) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopping {
+		return false
+	}
+	run()
+	l.closers = append(l.closers, namedCloser{name: name, Closer: c})
+	return true
+}
+
+// stop stops the component started under name and forgets it, so that it can be started again. It reports false
+// without stopping anything if shutdown has already begun, leaving shutdown to stop it.
+func (l *lifecycle) stop(name string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopping {
+		return false, nil
+	}
+
+	i := slices.IndexFunc(l.closers, func(c namedCloser) bool { return c.name == name })
+	if i < 0 {
+		return true, nil
+	}
+	c := l.closers[i]
+	l.closers = slices.Delete(l.closers, i, i+1)
+
+	slog.Info("stopping", slog.String("component", name))
+	if err := c.Close(); err != nil {
+		return true, fmt.Errorf("error stopping %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// close stops everything registered, newest first, carrying on past failures so that the transaction log is always
+// closed.
+func (l *lifecycle) close() error {
+	l.mu.Lock()
+	l.stopping = true
+	closers := slices.Clone(l.closers)
+	l.mu.Unlock()
+
+	var errs []error
+	for _, c := range slices.Backward(closers) {
+		slog.Info("stopping", slog.String("component", c.name))
+		if err := c.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error stopping %s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// shutdown stops the server from accepting new connections, waits for the requests in flight to finish and then stops
+// everything in the lifecycle, which flushes the transaction log. Requests still in flight once ctx is done are cut off,
+// but the log is flushed whatever happens, as it holds writes which have already been acknowledged.
+func shutdown(ctx context.Context, server *http.Server, lc *lifecycle) error {
+	var err error
+	if shutdownErr := server.Shutdown(ctx); shutdownErr != nil {
+		// their writes which haven't been logged yet fail once the log is closed
+		err = errors.Join(fmt.Errorf("error draining requests: %w", shutdownErr), server.Close())
+	}
+
+	return errors.Join(err, lc.close())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder records the order the components of a lifecycle are started and stopped in
+type recorder struct {
+	events []string
+}
+
+// start starts the component called name in lc, which fails to stop with err
+func (r *recorder) start(lc *lifecycle, name string, err error) bool {
+	return lc.start(name, closerFunc(func() error {
+		r.events = append(r.events, "stop "+name)
+		return err
+	}), func() {
+		r.events = append(r.events, "start "+name)
+	})
+}
+
+// TestLifecycle tests that components are stopped in the reverse of the order they were started in, that closing
+// carries on past failures, and that nothing more is started or stopped once shutdown has begun
+func TestLifecycle(t *testing.T) {
+	var r recorder
+	lc := &lifecycle{}
+
+	require.True(t, r.start(lc, "tracing", nil))
+	require.True(t, r.start(lc, "transaction log", errors.New("disk full")))
+	require.True(t, r.start(lc, "snapshotter", nil))
+	require.True(t, r.start(lc, "follower", nil))
+
+	running, err := lc.stop("snapshotter")
+	require.NoError(t, err)
+	assert.True(t, running)
+	running, err = lc.stop("snapshotter")
+	require.NoError(t, err)
+	assert.True(t, running, "stopping a component which isn't running does nothing")
+
+	err = lc.close()
+	assert.ErrorContains(t, err, "error stopping transaction log: disk full")
+
+	assert.False(t, r.start(lc, "late", nil), "nothing starts once shutdown has begun")
+	running, err = lc.stop("tracing")
+	require.NoError(t, err)
+	assert.False(t, running, "shutdown stops everything itself")
+
+	assert.Equal(t, []string{
+		"start tracing",
+		"start transaction log",
+		"start snapshotter",
+		"start follower",
+		"stop snapshotter",
+		"stop follower",
+		"stop transaction log",
+		"stop tracing",
+	}, r.events)
+}
+
+// TestShutdown tests that the lifecycle is stopped once requests have drained, and still stopped when they don't drain
+// in time
+func TestShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	received := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{
+		ReadHeaderTimeout: time.Second,
+		Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			close(received)
+			<-release
+		}),
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer close(release)
+
+	request, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "http://"+listener.Addr().String(), nil)
+	require.NoError(t, err)
+	go func() {
+		// the server cuts the request off
+		response, err := http.DefaultClient.Do(request)
+		if err == nil {
+			_ = response.Body.Close()
+		}
+	}()
+	<-received
+
+	var r recorder
+	lc := &lifecycle{}
+	require.True(t, r.start(lc, "transaction log", nil))
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	err = shutdown(ctx, server, lc)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "error draining requests")
+	assert.Equal(t, []string{"start transaction log", "stop transaction log"}, r.events,
+		"the log is flushed even though a request was still in flight")
+}
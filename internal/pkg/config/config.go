@@ -0,0 +1,292 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+// Logger backends
+const (
+	LoggerFile     = "file"
+	LoggerPostgres = "postgres"
+)
+
+// Sync policies for the file logger, see logger.SyncPolicy
+const (
+	SyncNever    = "never"
+	SyncAlways   = "always"
+	SyncInterval = "interval"
+	SyncEvery    = "every"
+)
+
+// Config is everything the API server can be configured with. Each setting can be given in a YAML file, an environment
+// variable or a command line flag, in increasing order of precedence, and falls back to the value in Default.
+type Config struct {
+	LogLevel slog.Level `yaml:"log_level"`
+	// ShutdownTimeout bounds how long a graceful shutdown waits for the requests in flight to finish before cutting
+	// them off. The transaction log is flushed afterwards either way, so it should leave time for that before the
+	// process is killed.
+	ShutdownTimeout time.Duration  `yaml:"shutdown_timeout"`
+	HTTP            HTTPConfig     `yaml:"http"`
+	Store           StoreConfig    `yaml:"store"`
+	Logger          LoggerConfig   `yaml:"logger"`
+	Snapshot        SnapshotConfig `yaml:"snapshot"`
+	Tracing         TracingConfig  `yaml:"tracing"`
+}
+
+type HTTPConfig struct {
+	Addr string `yaml:"addr"`
+	// TLSCert and TLSKey are the paths of the certificate and key to serve HTTPS with. TLS is usually terminated by
+	// the load balancer, so both are empty by default and the server speaks plain HTTP.
+	TLSCert           string        `yaml:"tls_cert"`
+	TLSKey            string        `yaml:"tls_key"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+}
+
+// TLS reports whether the server should serve HTTPS.
+func (c HTTPConfig) TLS() bool {
+	return c.TLSCert != "" || c.TLSKey != ""
+}
+
+type StoreConfig struct {
+	// SweepInterval is how often keys which have expired are removed from the store. They are never served once
+	// expired, so it only bounds how long they hold on to memory if they aren't read again.
+	SweepInterval time.Duration `yaml:"sweep_interval"`
+	// Shards is how many parts the store's keys are split between, each with its own lock, so that writes to keys in
+	// different shards don't wait on each other. It is 1 by default, which keeps every key under a single lock, as
+	// sharding costs more than it saves unless there are enough cores for writes to contend.
+	Shards int `yaml:"shards"`
+}
+
+type LoggerConfig struct {
+	// Kind is the transaction log backend, LoggerFile or LoggerPostgres.
+	Kind string `yaml:"kind"`
+	// Durable makes a write wait until it has been logged before it is acknowledged to the client. Without it a key's
+	// version isn't its write's sequence number, so conditional writes are turned away.
+	Durable  bool           `yaml:"durable"`
+	File     FileConfig     `yaml:"file"`
+	Postgres PostgresConfig `yaml:"postgres"`
+}
+
+type FileConfig struct {
+	Dir string `yaml:"dir"`
+	// ArchiveDir keeps compacted segments for point in time recovery, it must be on the same volume as Dir. Nothing
+	// prunes it, so it is empty by default, which means compacted segments are deleted.
+	ArchiveDir string `yaml:"archive_dir"`
+	// LegacyPath is where the log lived before it was split into segments, it is migrated into Dir on startup.
+	LegacyPath  string        `yaml:"legacy_path"`
+	SegmentSize int64         `yaml:"segment_size"`
+	SegmentAge  time.Duration `yaml:"segment_age"`
+	// Sync is one of the Sync policies, SyncInterval and SyncEvery taking their parameter from SyncInterval and
+	// SyncEvery respectively.
+	Sync         string        `yaml:"sync"`
+	SyncInterval time.Duration `yaml:"sync_interval"`
+	SyncEvery    int           `yaml:"sync_every"`
+}
+
+type PostgresConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+}
+
+type SnapshotConfig struct {
+	Dir      string        `yaml:"dir"`
+	Interval time.Duration `yaml:"interval"`
+	Retain   int           `yaml:"retain"`
+}
+
+type TracingConfig struct {
+	// Endpoint is the URL of the OTLP/HTTP collector to export spans to, such as http://localhost:4318. Tracing is
+	// disabled while it is empty, which it is by default.
+	Endpoint    string `yaml:"endpoint"`
+	ServiceName string `yaml:"service_name"`
+	// SampleRatio is the fraction of traces started by this replica which are recorded. Requests carrying a trace
+	// context follow the sampling decision of their caller.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// Default returns the configuration used for anything which isn't set explicitly.
+func Default() Config {
+	return Config{
+		LogLevel: slog.LevelInfo,
+		// docker kills the container 10s after asking it to stop, which leaves time to flush the log
+		ShutdownTimeout: 8 * time.Second,
+		HTTP: HTTPConfig{
+			Addr:              ":8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+		},
+		Store: StoreConfig{
+			SweepInterval: time.Minute,
+			Shards:        1,
+		},
+		Logger: LoggerConfig{
+			Kind:    LoggerFile,
+			Durable: true,
+			File: FileConfig{
+				Dir:         "/var/log/transactions",
+				LegacyPath:  "/var/log/transaction.log",
+				SegmentSize: 64 << 20,
+				// roll daily even when quiet, so that compaction can reclaim the space
+				SegmentAge: 24 * time.Hour,
+				Sync:       SyncAlways,
+			},
+			Postgres: PostgresConfig{
+				Port: 5432,
+			},
+		},
+		Snapshot: SnapshotConfig{
+			Dir:      "/var/log/snapshots",
+			Interval: 5 * time.Minute,
+			Retain:   2,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "lockbox",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Validate checks that the configuration is complete and consistent, reporting every problem it finds.
+func (c Config) Validate() error {
+	errs := c.HTTP.validate()
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	if c.Store.SweepInterval <= 0 {
+		errs = append(errs, errors.New("store.sweep_interval must be positive"))
+	}
+	if c.Store.Shards < 1 {
+		errs = append(errs, errors.New("store.shards must be at least 1"))
+	}
+
+	switch c.Logger.Kind {
+	case LoggerFile:
+		errs = append(errs, c.Logger.File.validate()...)
+	case LoggerPostgres:
+		errs = append(errs, c.Logger.Postgres.validate()...)
+	default:
+		errs = append(errs, fmt.Errorf("logger.kind must be %q or %q, not %q", LoggerFile, LoggerPostgres, c.Logger.Kind))
+	}
+
+	errs = append(errs, c.Snapshot.validate()...)
+	errs = append(errs, c.Tracing.validate()...)
+
+	return errors.Join(errs...)
+}
+
+func (c HTTPConfig) validate() []error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, errors.New("http.addr is required"))
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("http.tls_cert and http.tls_key must be given together"))
+	}
+
+	timeouts := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"http.read_header_timeout", c.ReadHeaderTimeout},
+		{"http.read_timeout", c.ReadTimeout},
+		{"http.write_timeout", c.WriteTimeout},
+		{"http.idle_timeout", c.IdleTimeout},
+	}
+	for _, t := range timeouts {
+		if t.timeout < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", t.name))
+		}
+	}
+
+	return errs
+}
+
+func (c FileConfig) validate() []error {
+	var errs []error
+	if c.Dir == "" {
+		errs = append(errs, errors.New("logger.file.dir is required"))
+	}
+	if c.SegmentSize <= 0 {
+		errs = append(errs, errors.New("logger.file.segment_size must be positive"))
+	}
+	if c.SegmentAge < 0 {
+		errs = append(errs, errors.New("logger.file.segment_age must not be negative"))
+	}
+
+	switch c.Sync {
+	case SyncNever, SyncAlways:
+	case SyncInterval:
+		if c.SyncInterval <= 0 {
+			errs = append(errs, errors.New("logger.file.sync_interval must be positive to sync by interval"))
+		}
+	case SyncEvery:
+		if c.SyncEvery <= 0 {
+			errs = append(errs, errors.New("logger.file.sync_every must be positive to sync every n events"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("logger.file.sync must be one of %q, %q, %q or %q, not %q",
+			SyncNever, SyncAlways, SyncInterval, SyncEvery, c.Sync))
+	}
+
+	return errs
+}
+
+func (c PostgresConfig) validate() []error {
+	var errs []error
+	if c.Host == "" {
+		errs = append(errs, errors.New("logger.postgres.host is required"))
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("logger.postgres.port %d is out of range", c.Port))
+	}
+	if c.User == "" {
+		errs = append(errs, errors.New("logger.postgres.user is required"))
+	}
+	if c.Database == "" {
+		errs = append(errs, errors.New("logger.postgres.database is required"))
+	}
+	return errs
+}
+
+func (c SnapshotConfig) validate() []error {
+	var errs []error
+	if c.Dir == "" {
+		errs = append(errs, errors.New("snapshot.dir is required"))
+	}
+	if c.Interval <= 0 {
+		errs = append(errs, errors.New("snapshot.interval must be positive"))
+	}
+	if c.Retain < 1 {
+		errs = append(errs, errors.New("snapshot.retain must be at least 1"))
+	}
+	return errs
+}
+
+func (c TracingConfig) validate() []error {
+	var errs []error
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("tracing.endpoint must be an http or https URL, not %q", c.Endpoint))
+		}
+	}
+	if c.ServiceName == "" {
+		errs = append(errs, errors.New("tracing.service_name is required"))
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+	return errs
+}
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// env is a lookupEnv over a fixed set of environment variables
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// load runs Load with a fresh flag set which doesn't write usage to the test output
+func load(args []string, vars map[string]string) (Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args, env(vars))
+}
+
+// writeConfig writes a configuration file and returns its path
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+// TestLoad_Defaults tests that the defaults are used when nothing is configured, and that they are valid
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+// TestLoad_Precedence tests that flags win over the environment, which wins over the file, which wins over defaults
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfig(t, `
+log_level: warn
+http:
+  addr: ":1000"
+  read_timeout: 1s
+  write_timeout: 1s
+logger:
+  file:
+    sync: interval
+    sync_interval: 10ms
+`)
+
+	cfg, err := load(
+		[]string{"-config", path, "-http-addr", ":3000"},
+		map[string]string{"LOCKBOX_HTTP_ADDR": ":2000", "LOCKBOX_HTTP_READ_TIMEOUT": "2s"},
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, slog.LevelWarn, cfg.LogLevel)
+	assert.Equal(t, ":3000", cfg.HTTP.Addr)
+	assert.Equal(t, 2*time.Second, cfg.HTTP.ReadTimeout)
+	assert.Equal(t, time.Second, cfg.HTTP.WriteTimeout)
+	assert.Equal(t, Default().HTTP.IdleTimeout, cfg.HTTP.IdleTimeout)
+	assert.Equal(t, SyncInterval, cfg.Logger.File.Sync)
+	assert.Equal(t, 10*time.Millisecond, cfg.Logger.File.SyncInterval)
+}
+
+// TestLoad_ConfigFromEnvironment tests that the configuration file can be given through the environment
+func TestLoad_ConfigFromEnvironment(t *testing.T) {
+	path := writeConfig(t, "logger:\n  kind: postgres\n  postgres:\n    host: db\n    user: lockbox\n    database: lockbox\n")
+
+	cfg, err := load(nil, map[string]string{"LOCKBOX_CONFIG": path, "LOCKBOX_LOGGER_POSTGRES_PASSWORD": "secret"})
+	require.NoError(t, err)
+
+	expected := PostgresConfig{Host: "db", Port: 5432, User: "lockbox", Password: "secret", Database: "lockbox"}
+	assert.Equal(t, LoggerPostgres, cfg.Logger.Kind)
+	assert.Equal(t, expected, cfg.Logger.Postgres)
+}
+
+// TestLoad_CallerFlags tests that flags registered by the caller are parsed alongside the configuration
+func TestLoad_CallerFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	seq := fs.Uint64("recover-sequence", 0, "")
+
+	cfg, err := Load(fs, []string{"-recover-sequence", "42", "-log-level", "debug"}, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), *seq)
+	assert.Equal(t, slog.LevelDebug, cfg.LogLevel)
+}
+
+// TestLoad_Errors tests that bad configuration is reported rather than ignored
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		args     []string
+		vars     map[string]string
+		contains string
+	}{
+		{
+			name:     "unknown flag",
+			args:     []string{"-nope"},
+			contains: "flag provided but not defined",
+		},
+		{
+			name:     "malformed environment variable",
+			vars:     map[string]string{"LOCKBOX_HTTP_READ_TIMEOUT": "soon"},
+			contains: "invalid LOCKBOX_HTTP_READ_TIMEOUT",
+		},
+		{
+			name:     "unknown setting in file",
+			file:     "http:\n  adr: \":8080\"\n",
+			contains: "field adr not found",
+		},
+		{
+			name:     "missing file",
+			args:     []string{"-config", "/does/not/exist.yaml"},
+			contains: "failed to read config file",
+		},
+		{
+			name:     "invalid value",
+			args:     []string{"-logger-kind", "sqlite"},
+			contains: `logger.kind must be "file" or "postgres", not "sqlite"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			args := tc.args
+			if tc.file != "" {
+				args = append(args, "-config", writeConfig(t, tc.file))
+			}
+
+			_, err := load(args, tc.vars)
+			assert.ErrorContains(t, err, tc.contains)
+		})
+	}
+}
+
+// TestConfig_Validate tests that every problem with a configuration is reported
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		mutate   func(*Config)
+		expected []string
+	}{
+		{
+			name: "tls needs both halves",
+			mutate: func(c *Config) {
+				c.HTTP.TLSCert = "cert.pem"
+			},
+			expected: []string{"http.tls_cert and http.tls_key must be given together"},
+		},
+		{
+			name: "negative timeouts",
+			mutate: func(c *Config) {
+				c.HTTP.ReadTimeout = -1
+				c.HTTP.IdleTimeout = -1
+			},
+			expected: []string{"http.read_timeout must not be negative", "http.idle_timeout must not be negative"},
+		},
+		{
+			name: "sync interval",
+			mutate: func(c *Config) {
+				c.Logger.File.Sync = SyncInterval
+			},
+			expected: []string{"logger.file.sync_interval must be positive"},
+		},
+		{
+			name: "unknown sync policy",
+			mutate: func(c *Config) {
+				c.Logger.File.Sync = "sometimes"
+			},
+			expected: []string{`logger.file.sync must be one of`},
+		},
+		{
+			name: "postgres",
+			mutate: func(c *Config) {
+				c.Logger.Kind = LoggerPostgres
+				c.Logger.Postgres.Port = 0
+			},
+			expected: []string{
+				"logger.postgres.host is required",
+				"logger.postgres.port 0 is out of range",
+				"logger.postgres.user is required",
+				"logger.postgres.database is required",
+			},
+		},
+		{
+			name: "file settings are ignored for postgres",
+			mutate: func(c *Config) {
+				c.Logger.Kind = LoggerPostgres
+				c.Logger.Postgres = PostgresConfig{Host: "db", Port: 5432, User: "lockbox", Database: "lockbox"}
+				c.Logger.File.Dir = ""
+			},
+		},
+		{
+			name: "shutdown timeout",
+			mutate: func(c *Config) {
+				c.ShutdownTimeout = 0
+			},
+			expected: []string{"shutdown_timeout must be positive"},
+		},
+		{
+			name: "sweep interval",
+			mutate: func(c *Config) {
+				c.Store.SweepInterval = 0
+			},
+			expected: []string{"store.sweep_interval must be positive"},
+		},
+		{
+			name: "shards",
+			mutate: func(c *Config) {
+				c.Store.Shards = 0
+			},
+			expected: []string{"store.shards must be at least 1"},
+		},
+		{
+			name: "snapshots",
+			mutate: func(c *Config) {
+				c.Snapshot.Interval = 0
+				c.Snapshot.Retain = 0
+			},
+			expected: []string{"snapshot.interval must be positive", "snapshot.retain must be at least 1"},
+		},
+		{
+			name: "tracing",
+			mutate: func(c *Config) {
+				c.Tracing.Endpoint = "localhost:4318"
+				c.Tracing.SampleRatio = 2
+			},
+			expected: []string{
+				`tracing.endpoint must be an http or https URL, not "localhost:4318"`,
+				"tracing.sample_ratio must be between 0 and 1",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Default()
+			tc.mutate(&cfg)
+
+			err := cfg.Validate()
+			if len(tc.expected) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			for _, msg := range tc.expected {
+				assert.ErrorContains(t, err, msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvPrefix is prepended to the environment variable for each setting, which is otherwise its flag name in upper
+	// case with dashes replaced by underscores, e.g. LOCKBOX_HTTP_ADDR for -http-addr.
+	EnvPrefix = "LOCKBOX_"
+	// configFlag names the flag, and so the environment variable, giving the path of the configuration file
+	configFlag = "config"
+)
+
+// Load builds the configuration from the defaults, then the configuration file, then the environment and finally the
+// command line in args. The configuration flags are added to fs, which the caller may have registered flags of its
+// own on, and args are parsed with it. lookupEnv is normally os.LookupEnv.
+func Load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+	var path string
+	fs.StringVar(&path, configFlag, "", "path of a YAML configuration file")
+	names := register(fs, &cfg)
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	// the flags take precedence over everything else, so hold on to them while the rest is loaded underneath
+	given := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	if path == "" {
+		path, _ = lookupEnv(envName(configFlag))
+	}
+
+	cfg = Default()
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, name := range names {
+		value, ok := lookupEnv(envName(name))
+		if !ok {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", envName(name), err)
+		}
+	}
+
+	for _, name := range names {
+		if value, ok := given[name]; ok {
+			if err := fs.Set(name, value); err != nil {
+				return Config{}, fmt.Errorf("invalid -%s: %w", name, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// loadFile decodes the YAML file at path over cfg. Settings the file doesn't mention keep their current value, while
+// ones which don't exist are an error so that typos don't go unnoticed.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path) //nolint:gosec // the operator chooses which file to load
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// envName returns the environment variable for the flag called name.
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// register adds a flag to fs for every setting in cfg, returning their names.
+func register(fs *flag.FlagSet, cfg *Config) []string {
+	before := make(map[string]bool)
+	fs.VisitAll(func(f *flag.Flag) {
+		before[f.Name] = true
+	})
+
+	fs.TextVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "minimum level to log at: debug, info, warn or error")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout,
+		"how long to wait for requests to drain when shutting down, before flushing the transaction log")
+	registerHTTP(fs, &cfg.HTTP)
+	fs.DurationVar(&cfg.Store.SweepInterval, "store-sweep-interval", cfg.Store.SweepInterval,
+		"how often to remove expired keys from the store")
+	fs.IntVar(&cfg.Store.Shards, "store-shards", cfg.Store.Shards,
+		"how many shards to split the store's keys between, each with its own lock, 1 for none")
+	registerLogger(fs, &cfg.Logger)
+	registerSnapshot(fs, &cfg.Snapshot)
+	registerTracing(fs, &cfg.Tracing)
+
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !before[f.Name] {
+			names = append(names, f.Name)
+		}
+	})
+	return names
+}
+
+func registerHTTP(fs *flag.FlagSet, cfg *HTTPConfig) {
+	fs.StringVar(&cfg.Addr, "http-addr", cfg.Addr, "address to listen on")
+	fs.StringVar(&cfg.TLSCert, "http-tls-cert", cfg.TLSCert, "certificate to serve HTTPS with")
+	fs.StringVar(&cfg.TLSKey, "http-tls-key", cfg.TLSKey, "private key to serve HTTPS with")
+	fs.DurationVar(&cfg.ReadHeaderTimeout, "http-read-header-timeout", cfg.ReadHeaderTimeout,
+		"how long a client has to send the request headers")
+	fs.DurationVar(&cfg.ReadTimeout, "http-read-timeout", cfg.ReadTimeout,
+		"how long a client has to send the whole request")
+	fs.DurationVar(&cfg.WriteTimeout, "http-write-timeout", cfg.WriteTimeout,
+		"how long the server has to write the response")
+	fs.DurationVar(&cfg.IdleTimeout, "http-idle-timeout", cfg.IdleTimeout,
+		"how long an idle keep-alive connection is kept open")
+}
+
+func registerLogger(fs *flag.FlagSet, cfg *LoggerConfig) {
+	fs.StringVar(&cfg.Kind, "logger-kind", cfg.Kind, "transaction log backend: file or postgres")
+	fs.BoolVar(&cfg.Durable, "logger-durable", cfg.Durable,
+		"acknowledge writes only once they have been logged")
+
+	fs.StringVar(&cfg.File.Dir, "logger-file-dir", cfg.File.Dir, "directory of the segmented log")
+	fs.StringVar(&cfg.File.ArchiveDir, "logger-file-archive-dir", cfg.File.ArchiveDir,
+		"directory to archive compacted segments into, empty to delete them")
+	fs.StringVar(&cfg.File.LegacyPath, "logger-file-legacy-path", cfg.File.LegacyPath,
+		"single file log to migrate into the segmented log")
+	fs.Int64Var(&cfg.File.SegmentSize, "logger-file-segment-size", cfg.File.SegmentSize,
+		"size in bytes at which a segment is rolled")
+	fs.DurationVar(&cfg.File.SegmentAge, "logger-file-segment-age", cfg.File.SegmentAge,
+		"age at which a segment is rolled, 0 to roll by size only")
+	fs.StringVar(&cfg.File.Sync, "logger-file-sync", cfg.File.Sync,
+		"when to fsync the log: never, always, interval or every")
+	fs.DurationVar(&cfg.File.SyncInterval, "logger-file-sync-interval", cfg.File.SyncInterval,
+		"how often to fsync when syncing by interval")
+	fs.IntVar(&cfg.File.SyncEvery, "logger-file-sync-every", cfg.File.SyncEvery,
+		"how many events to fsync after when syncing every n events")
+
+	fs.StringVar(&cfg.Postgres.Host, "logger-postgres-host", cfg.Postgres.Host, "postgres host")
+	fs.IntVar(&cfg.Postgres.Port, "logger-postgres-port", cfg.Postgres.Port, "postgres port")
+	fs.StringVar(&cfg.Postgres.User, "logger-postgres-user", cfg.Postgres.User, "postgres user")
+	fs.StringVar(&cfg.Postgres.Password, "logger-postgres-password", cfg.Postgres.Password,
+		"postgres password, prefer setting it through the environment")
+	fs.StringVar(&cfg.Postgres.Database, "logger-postgres-database", cfg.Postgres.Database,
+		"postgres database")
+}
+
+func registerSnapshot(fs *flag.FlagSet, cfg *SnapshotConfig) {
+	fs.StringVar(&cfg.Dir, "snapshot-dir", cfg.Dir, "directory to keep snapshots in")
+	fs.DurationVar(&cfg.Interval, "snapshot-interval", cfg.Interval, "how often to snapshot")
+	fs.IntVar(&cfg.Retain, "snapshot-retain", cfg.Retain, "how many snapshots to keep")
+}
+
+func registerTracing(fs *flag.FlagSet, cfg *TracingConfig) {
+	fs.StringVar(&cfg.Endpoint, "tracing-endpoint", cfg.Endpoint,
+		"OTLP/HTTP collector to export spans to, empty to disable tracing")
+	fs.StringVar(&cfg.ServiceName, "tracing-service-name", cfg.ServiceName, "service name to report spans under")
+	fs.Float64Var(&cfg.SampleRatio, "tracing-sample-ratio", cfg.SampleRatio,
+		"fraction of new traces to record, between 0 and 1")
+}
@@ -0,0 +1,41 @@
+// Package disk holds the helpers shared by the parts of the service which keep data on disk, the transaction log and
+// snapshots.
+package disk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// SyncDir fsyncs a directory so that renames and removals within it are durable.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir) //nolint:gosec // dir is one of the service's own directories
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+
+	return nil
+}
+
+// ReadBytes reads a uvarint length prefixed byte string from the front of b and returns it along with the remainder.
+func ReadBytes(b []byte) ([]byte, []byte, error) {
+	size, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, nil, errors.New("invalid length prefix")
+	}
+	b = b[n:]
+	if size > uint64(len(b)) {
+		return nil, nil, fmt.Errorf("length %d exceeds remaining %d bytes", size, len(b))
+	}
+	return b[:size], b[size:], nil
+}
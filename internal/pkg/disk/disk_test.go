@@ -0,0 +1,49 @@
+package disk
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReadBytes tests reading length prefixed byte strings, and that a length which runs past the end is rejected
+func TestReadBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		b       []byte
+		want    []byte
+		rest    []byte
+		wantErr string
+	}{
+		{name: "string", b: append(binary.AppendUvarint(nil, 3), "abcde"...), want: []byte("abc"), rest: []byte("de")},
+		{name: "empty", b: binary.AppendUvarint(nil, 0), want: []byte{}, rest: []byte{}},
+		{name: "no prefix", b: nil, wantErr: "invalid length prefix"},
+		{name: "too long", b: append(binary.AppendUvarint(nil, 4), "abc"...), wantErr: "length 4 exceeds remaining 3 bytes"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, rest, err := ReadBytes(tc.b)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.rest, rest)
+		})
+	}
+}
+
+// TestSyncDir tests syncing a directory, and that one which doesn't exist fails
+func TestSyncDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0o600))
+	require.NoError(t, SyncDir(dir))
+
+	assert.ErrorContains(t, SyncDir(filepath.Join(dir, "missing")), "failed to open directory")
+}
@@ -0,0 +1,138 @@
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/treyburn/lockbox/internal/pkg/disk"
+)
+
+// compactRequest asks the writer loop to compact the log.
+type compactRequest struct {
+	through uint64
+	done    chan<- error
+}
+
+// compactableFile is what a file handle must support for the log to be compacted: reading it back from the start
+// regardless of the write offset, and knowing where it lives so that it can be replaced.
+type compactableFile interface {
+	io.ReaderAt
+	Name() string
+}
+
+// Compact rewrites the log keeping only the events after through, or for a segmented log deletes the segments holding
+// nothing after through. It runs on the writer loop, so Run must have been called first, and a single file log
+// requires the file handle to be an *os.File or something else providing ReadAt and Name. It fails with ErrLogStopped
+// once the writer loop has stopped.
+func (l *FileTransactionLogger) Compact(through uint64) error {
+	done := make(chan error, 1)
+	select {
+	case l.compactions <- compactRequest{through: through, done: done}:
+		return <-done
+	case <-l.done:
+		return ErrLogStopped
+	}
+}
+
+// compact copies the events after through into a new file and atomically swaps it in for the current log. A segmented
+// log instead drops the segments which are entirely covered.
+func (c *committer) compact(through uint64) error {
+	if c.logger.segments.enabled() {
+		return c.compactSegments(through)
+	}
+
+	current, ok := c.logger.file.(compactableFile)
+	if !ok {
+		return errors.New("file handle does not support compaction")
+	}
+
+	path := current.Name()
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat log: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".compact-*")
+	if err != nil {
+		return fmt.Errorf("failed to create compacted log: %w", err)
+	}
+	defer func() {
+		// a no-op once the rename has succeeded
+		_ = os.Remove(tmp.Name())
+	}()
+
+	kept, err := copyTail(tmp, current, through)
+	if err == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write compacted log: %w", err)
+	}
+
+	// open the replacement before renaming it, so that there is no point after the swap at which we can fail and be
+	// left writing to the unlinked original
+	replacement, err := os.OpenFile(tmp.Name(), os.O_RDWR|os.O_APPEND, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to open compacted log: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		_ = replacement.Close()
+		return fmt.Errorf("failed to replace log: %w", err)
+	}
+
+	previous := c.logger.file
+	c.logger.file = replacement
+	c.unsynced = 0
+	if err = previous.Close(); err != nil {
+		slog.Warn("failed to close compacted log", slog.Any("error", err))
+	}
+
+	slog.Info("compacted transaction log", slog.Uint64("through", through), slog.Int("kept", kept))
+
+	return disk.SyncDir(filepath.Dir(path))
+}
+
+// copyTail writes every event in src after through to dst, returning how many were kept.
+func copyTail(dst io.Writer, src io.ReaderAt, through uint64) (int, error) {
+	reader := newRecordReader(io.NewSectionReader(src, 0, math.MaxInt64))
+	w := bufio.NewWriter(dst)
+
+	var (
+		buf  []byte
+		kept int
+	)
+	for {
+		e, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return kept, fmt.Errorf("failed to read log: %w", err)
+		}
+
+		if e.Sequence <= through {
+			continue
+		}
+
+		buf = appendRecord(buf[:0], e)
+		if _, err = w.Write(buf); err != nil {
+			return kept, err
+		}
+		kept++
+	}
+
+	return kept, w.Flush()
+}
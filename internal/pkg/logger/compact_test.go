@@ -0,0 +1,152 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readLogFile replays the log at path with a fresh logger
+func readLogFile(t *testing.T, path string, opts ...FileOption) []Event {
+	t.Helper()
+
+	file, err := os.Open(path) //nolint:gosec // test file
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	events, err := collectEvents(t, NewFileTransactionLogger(file, opts...))
+	require.NoError(t, err)
+	return events
+}
+
+// TestFileTransactionLogger_Compact tests that compaction keeps only the events after the given sequence and that the
+// logger carries on writing to the compacted log
+func TestFileTransactionLogger_Compact(t *testing.T) {
+	// start from a legacy log to check that it is converted along the way
+	file := openTempLog(t, []byte("1\t2\tkey1\tvalue1\n2\t2\tkey2\tvalue2\n"))
+	path := file.Name()
+	require.NoError(t, os.Chmod(path, 0o640))
+
+	logger := NewFileTransactionLogger(file, WithDurableWrites())
+	_, err := collectEvents(t, logger)
+	require.NoError(t, err)
+	logger.Run()
+
+	for i := 3; i <= 5; i++ {
+		require.NoError(t, writeErr(logger.WritePut(t.Context(), fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)))))
+	}
+
+	require.NoError(t, logger.Compact(3))
+
+	expected := []Event{
+		{Sequence: 4, Kind: EventPut, Key: "key4", Value: []byte("value4")},
+		{Sequence: 5, Kind: EventPut, Key: "key5", Value: []byte("value5")},
+	}
+	// the log now starts after the events compacted away, so it has to be read from there
+	assert.Equal(t, expected, withoutTimestamps(readLogFile(t, path, WithStartSequence(3))))
+
+	// writes after compaction land in the new file
+	require.NoError(t, writeErr(logger.WriteDelete(t.Context(), "key4")))
+	require.NoError(t, logger.Close())
+
+	expected = append(expected, Event{Sequence: 6, Kind: EventDelete, Key: "key4"})
+	assert.Equal(t, expected, withoutTimestamps(readLogFile(t, path, WithStartSequence(3))))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm(), "compaction should preserve the log's permissions")
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files should be left behind")
+}
+
+// TestFileTransactionLogger_CompactEverything tests that a fully compacted log keeps numbering from where it left off
+// once it is reopened with the snapshot's sequence
+func TestFileTransactionLogger_CompactEverything(t *testing.T) {
+	file := openTempLog(t, nil)
+	path := file.Name()
+
+	logger := NewFileTransactionLogger(file, WithDurableWrites())
+	logger.Run()
+	require.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+	require.NoError(t, writeErr(logger.WritePut(t.Context(), "key2", []byte("value2"))))
+	require.NoError(t, logger.Compact(2))
+	require.NoError(t, logger.Close())
+
+	assert.Empty(t, readLogFile(t, path))
+
+	reopened, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+
+	logger = NewFileTransactionLogger(reopened, WithDurableWrites(), WithStartSequence(2))
+	_, err = collectEvents(t, logger)
+	require.NoError(t, err)
+	logger.Run()
+	require.NoError(t, writeErr(logger.WritePut(t.Context(), "key3", []byte("value3"))))
+	require.NoError(t, logger.Close())
+
+	assert.Equal(t, []Event{{Sequence: 3, Kind: EventPut, Key: "key3", Value: []byte("value3")}},
+		withoutTimestamps(readLogFile(t, path, WithStartSequence(2))))
+}
+
+// TestFileTransactionLogger_ReplayCompacted tests that replaying from before where a compacted log starts fails with
+// ErrCompacted rather than skipping the events which were compacted away
+func TestFileTransactionLogger_ReplayCompacted(t *testing.T) {
+	file := openTempLog(t, nil)
+	path := file.Name()
+
+	logger := NewFileTransactionLogger(file, WithDurableWrites())
+	logger.Run()
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, writeErr(logger.WritePut(t.Context(), fmt.Sprintf("key%d", i), []byte("value"))))
+	}
+	require.NoError(t, logger.Compact(2))
+	require.NoError(t, logger.Close())
+
+	reopened, err := os.Open(path) //nolint:gosec // test file
+	require.NoError(t, err)
+	defer func() { _ = reopened.Close() }()
+
+	events, err := collectEvents(t, NewFileTransactionLogger(reopened, WithStartSequence(1)))
+	require.ErrorIs(t, err, ErrCompacted)
+	assert.ErrorContains(t, err, "log resumes at 3, after 1 was requested")
+	assert.Empty(t, events)
+}
+
+// TestFileTransactionLogger_CompactUnsupported tests that compaction fails cleanly for handles it can't replace
+func TestFileTransactionLogger_CompactUnsupported(t *testing.T) {
+	mock := newMockReadWriteCloser("")
+	logger := NewFileTransactionLogger(mock, WithDurableWrites())
+	logger.Run()
+
+	require.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+
+	err := logger.Compact(1)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "does not support compaction")
+
+	// the logger is unaffected
+	require.NoError(t, writeErr(logger.WritePut(t.Context(), "key2", []byte("value2"))))
+	require.NoError(t, logger.Close())
+	assert.Len(t, decodeAll(t, mock.String()), 2)
+}
+
+// TestFileTransactionLogger_StartSequence tests that events covered by a snapshot are skipped on replay, even when they
+// are out of order with respect to the snapshot
+func TestFileTransactionLogger_StartSequence(t *testing.T) {
+	data := "1\t2\tkey1\tvalue1\n2\t2\tkey2\tvalue2\n3\t2\tkey3\tvalue3\n"
+	logger := NewFileTransactionLogger(newMockReadWriteCloser(data), WithStartSequence(2))
+
+	events, err := collectEvents(t, logger)
+	require.NoError(t, err)
+	assert.Equal(t, []Event{{Sequence: 3, Kind: EventPut, Key: "key3", Value: []byte("value3")}}, events)
+
+	seq, err := logger.LastSequence()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), seq)
+}
@@ -1,58 +1,337 @@
 package logger
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 )
 
 // compile time assertion that FileTransactionLogger is a TransactionManager
 var _ TransactionManager = (*FileTransactionLogger)(nil)
 
-func NewFileTransactionLogger(fileHandle io.ReadWriteCloser) *FileTransactionLogger {
-	return &FileTransactionLogger{file: fileHandle}
+func NewFileTransactionLogger(fileHandle io.ReadWriteCloser, opts ...FileOption) *FileTransactionLogger {
+	l := &FileTransactionLogger{file: fileHandle}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
 }
 
 type FileTransactionLogger struct {
-	events       chan<- Event
+	events       chan<- pendingEvent
+	sending      sendGuard
 	errors       <-chan error
+	compactions  chan compactRequest
+	done         chan struct{}
 	lastSequence atomic.Uint64
 	file         io.ReadWriteCloser
+	recovery     RecoveryMode
+	durable      bool
+	syncPolicy   SyncPolicy
+	// startSequence is the sequence covered by the snapshot the store was restored from, if any.
+	startSequence uint64
+	segments      segmentSet
 }
 
-func (l *FileTransactionLogger) WritePut(key, value string) {
-	l.events <- Event{Kind: EventPut, Key: key, Value: value}
+// RecoveryMode controls how ReadEvents handles a torn write at the end of the log. Corruption anywhere else in the log
+// is always a hard failure.
+type RecoveryMode byte
+
+const (
+	// RecoveryStrict fails ReadEvents with ErrTornWrite.
+	RecoveryStrict RecoveryMode = iota
+	// RecoveryRepair truncates the log back to the end of the last good record so that new writes follow it.
+	RecoveryRepair
+	// RecoverySkip ignores the torn record but leaves the log untouched. It is only suitable for read-only use, as any
+	// later writes would be appended after the damaged bytes.
+	RecoverySkip
+)
+
+func (m RecoveryMode) String() string {
+	switch m {
+	case RecoveryStrict:
+		return "strict"
+	case RecoveryRepair:
+		return "repair"
+	case RecoverySkip:
+		return "skip"
+	default:
+		return fmt.Sprintf("RecoveryMode(%d)", m)
+	}
 }
 
-func (l *FileTransactionLogger) WriteDelete(key string) {
-	l.events <- Event{Kind: EventDelete, Key: key}
+type FileOption = func(*FileTransactionLogger)
+
+// WithRecoveryMode sets how a torn write at the end of the log is handled. The default is RecoveryStrict.
+func WithRecoveryMode(mode RecoveryMode) FileOption {
+	return func(l *FileTransactionLogger) {
+		l.recovery = mode
+	}
+}
+
+// WithDurableWrites makes WritePut and WriteDelete block until the event has been written to the file, returning any
+// error encountered while doing so.
+func WithDurableWrites() FileOption {
+	return func(l *FileTransactionLogger) {
+		l.durable = true
+	}
+}
+
+// WithSyncPolicy sets when the log is fsynced. The default is SyncNever.
+func WithSyncPolicy(policy SyncPolicy) FileOption {
+	return func(l *FileTransactionLogger) {
+		l.syncPolicy = policy
+	}
+}
+
+// WithStartSequence tells the logger that the store was restored from a snapshot covering every event up to and
+// including seq. ReadEvents skips those events, which may still be in the log if compaction didn't complete, and new
+// events are numbered after seq even if compaction left the log empty.
+func WithStartSequence(seq uint64) FileOption {
+	return func(l *FileTransactionLogger) {
+		l.startSequence = seq
+		l.lastSequence.Store(seq)
+	}
 }
 
+func (l *FileTransactionLogger) WritePut(ctx context.Context, key string, value []byte, opts ...WriteOption) (uint64, error) {
+	return submit(ctx, &l.sending, l.events, l.done, PutOp(key, value, opts...), l.durable)
+}
+
+func (l *FileTransactionLogger) WriteDelete(ctx context.Context, key string) (uint64, error) {
+	return submit(ctx, &l.sending, l.events, l.done, DeleteOp(key), l.durable)
+}
+
+func (l *FileTransactionLogger) WriteBatch(ctx context.Context, ops []Event) (uint64, error) {
+	e, err := batchEvent(ops)
+	if err != nil {
+		return 0, err
+	}
+	return submit(ctx, &l.sending, l.events, l.done, e, l.durable)
+}
+
+// Err reports the error which stopped the logger, after which writes fail with ErrLogStopped. It is closed once the
+// logger has stopped.
 func (l *FileTransactionLogger) Err() <-chan error {
 	return l.errors
 }
 
+// Pending returns how many events are queued for the writer loop. It must be called after Run.
+func (l *FileTransactionLogger) Pending() int {
+	return len(l.events)
+}
+
+// maxBatchSize bounds how many queued events are coalesced into a single write.
+const maxBatchSize = 256
+
 func (l *FileTransactionLogger) Run() {
-	events := make(chan Event, 16)
+	events := make(chan pendingEvent, 16)
 	l.events = events
 	errs := make(chan error, 1)
 	l.errors = errs
-	go func() {
-		for e := range events {
-			seq := l.lastSequence.Add(1)
+	l.compactions = make(chan compactRequest)
+	l.done = make(chan struct{})
+	go l.run(events, errs)
+}
 
-			_, err := fmt.Fprintf(l.file, "%d\t%d\t%s\t%s\n", seq, e.Kind, e.Key, e.Value)
-			if err != nil {
-				errs <- fmt.Errorf("failed to process event: [%d-%d-%s]: %w", seq, e.Kind, e.Key, err)
+// LastSequence returns the sequence number of the most recent event written to, or read from, the log.
+func (l *FileTransactionLogger) LastSequence() (uint64, error) {
+	return l.lastSequence.Load(), nil
+}
+
+// run is the writer loop. It implements group commit: every event that queued up while the previous batch was being
+// written goes out in a single write, is synced according to the SyncPolicy, and only then are the durable writers in
+// that batch released together.
+func (l *FileTransactionLogger) run(events <-chan pendingEvent, errs chan<- error) {
+	// done is closed first, so that writes are already being turned away by the time Err is closed
+	defer close(errs)
+	defer close(l.done)
+
+	var tick <-chan time.Time
+	if l.syncPolicy.mode == syncInterval {
+		ticker := time.NewTicker(l.syncPolicy.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	c := &committer{logger: l, lastSync: time.Now(), segmentOpened: time.Now()}
+	if l.segments.enabled() {
+		size, err := l.activeSize()
+		if err != nil {
+			errs <- err
+			return
+		}
+		c.segmentSize = size
+	}
+
+	for {
+		select {
+		case first, ok := <-events:
+			if !ok {
+				c.close(errs)
+				return
+			}
+
+			batch, open := c.collect(first, events)
+			if err := c.commit(batch); err != nil {
+				errs <- err
+				return
+			}
+
+			if !open {
+				c.close(errs)
 				return
 			}
+		case <-tick:
+			// make sure data written in a quiet period still reaches the disk within the interval
+			if err := c.sync(); err != nil {
+				errs <- err
+				return
+			}
+		case req := <-l.compactions:
+			req.done <- c.compact(req.through)
 		}
-	}()
+	}
+}
+
+// committer holds the writer loop's state between batches.
+type committer struct {
+	logger   *FileTransactionLogger
+	batch    []pendingEvent
+	buf      []byte
+	unsynced int
+	lastSync time.Time
+	// segmentSize and segmentOpened describe the active segment of a segmented log
+	segmentSize   int64
+	segmentOpened time.Time
+}
+
+// collect gathers first plus whatever else is already queued, without waiting for more. It reports false if events
+// was closed while draining it.
+func (c *committer) collect(first pendingEvent, events <-chan pendingEvent) ([]pendingEvent, bool) {
+	c.batch = append(c.batch[:0], first)
+	for len(c.batch) < maxBatchSize {
+		select {
+		case p, ok := <-events:
+			if !ok {
+				return c.batch, false
+			}
+			c.batch = append(c.batch, p)
+		default:
+			return c.batch, true
+		}
+	}
+	return c.batch, true
 }
 
+// commit sequences, writes, and acknowledges a batch of events.
+func (c *committer) commit(batch []pendingEvent) error {
+	if c.logger.segments.due(c.segmentSize, time.Since(c.segmentOpened)) {
+		if err := c.roll(); err != nil {
+			for _, p := range batch {
+				p.acknowledge(err)
+			}
+			return fmt.Errorf("failed to roll segment: %w", err)
+		}
+	}
+
+	c.buf = c.buf[:0]
+	// nanosecond precision without the monotonic reading, so the event is the same once it has been read back
+	now := time.Now().UTC().Round(0)
+	for i := range batch {
+		batch[i].Sequence = c.logger.lastSequence.Add(1)
+		batch[i].Timestamp = now
+		c.buf = appendRecord(c.buf, batch[i].Event)
+	}
+
+	size := c.segmentSize
+	err := c.write(len(batch))
+	if err != nil {
+		if rollbackErr := c.rollback(batch, size); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+	}
+	for _, p := range batch {
+		p.acknowledge(err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to process events: [%d-%d]: %w", batch[0].Sequence, batch[len(batch)-1].Sequence, err)
+	}
+
+	return nil
+}
+
+// rollback removes whatever part of a batch which failed to write reached the active segment, and gives back its
+// sequence numbers, so that a logger reopened on the log carries on from the last event written. Only a segmented log
+// knows where the batch started.
+func (c *committer) rollback(batch []pendingEvent, size int64) error {
+	if !c.logger.segments.enabled() {
+		return nil
+	}
+
+	if err := c.logger.truncate(size); err != nil {
+		return err
+	}
+	c.segmentSize = size
+	c.logger.lastSequence.Store(batch[0].Sequence - 1)
+	return nil
+}
+
+func (c *committer) write(count int) error {
+	n, err := c.logger.file.Write(c.buf)
+	c.segmentSize += int64(n)
+	if err != nil {
+		return err
+	}
+
+	c.unsynced += count
+	if c.logger.syncPolicy.due(c.unsynced, time.Since(c.lastSync)) {
+		return c.sync()
+	}
+
+	return nil
+}
+
+// sync fsyncs the file if there is anything unsynced and the handle supports it.
+func (c *committer) sync() error {
+	if c.unsynced == 0 {
+		return nil
+	}
+
+	if syncer, ok := c.logger.file.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			return fmt.Errorf("failed to sync: %w", err)
+		}
+	}
+
+	c.unsynced = 0
+	c.lastSync = time.Now()
+	return nil
+}
+
+// close flushes anything still unsynced on shutdown, unless the policy leaves syncing to the operating system.
+func (c *committer) close(errs chan<- error) {
+	if c.logger.syncPolicy.mode == syncNever {
+		return
+	}
+
+	if err := c.sync(); err != nil {
+		errs <- err
+	}
+}
+
+// parseEvent parses a line of the legacy tab separated log format. It is retained so that logs written before the
+// binary record format was introduced can still be replayed.
 func parseEvent(line string) (Event, error) {
 	fields := strings.Split(line, "\t")
 	if len(fields) < 3 {
@@ -75,15 +354,18 @@ func parseEvent(line string) (Event, error) {
 		Key:      fields[2],
 	}
 
-	if len(fields) >= 4 {
-		e.Value = fields[3]
+	if len(fields) >= 4 && fields[3] != "" {
+		e.Value = []byte(fields[3])
 	}
 
 	return e, nil
 }
 
-func (l *FileTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
-	scanner := bufio.NewScanner(l.file)
+func (l *FileTransactionLogger) ReadEvents(ctx context.Context, opts ...ReadOption) (<-chan Event, <-chan error) {
+	r := &fileRead{readRange: newReadRange(opts...)}
+	// events covered by the snapshot the store was restored from are never wanted
+	r.after = max(r.after, l.startSequence)
+
 	outEvent := make(chan Event)
 	outErr := make(chan error, 1)
 
@@ -91,47 +373,170 @@ func (l *FileTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
 		defer close(outEvent)
 		defer close(outErr)
 
-		for scanner.Scan() {
-			e, err := parseEvent(scanner.Text())
+		for _, path := range l.segments.sealed(r.after) {
+			done, err := l.readSegment(ctx, path, r, outEvent)
 			if err != nil {
 				outErr <- err
 				return
 			}
+			if done {
+				return
+			}
+		}
 
-			// atomically compare and swap the value for our latest sequence
-			for {
-				last := l.lastSequence.Load()
-				if last >= e.Sequence {
-					outErr <- fmt.Errorf("transaction sequence out of sequence: %d >= %d", last, e.Sequence)
-					return
-				}
+		reader := newRecordReader(l.file)
+		_, err := l.replay(ctx, reader, r, outEvent)
+		if errors.Is(err, ErrTornWrite) {
+			err = l.recoverTornWrite(reader.offset, err)
+		}
+		if err != nil {
+			outErr <- err
+		}
+	}()
+
+	return outEvent, outErr
+}
 
-				if l.lastSequence.CompareAndSwap(last, e.Sequence) {
-					outEvent <- e
-					break
+// readSegment replays a sealed segment of a segmented log, reporting whether it reached the end of the read range.
+// Segments are synced before they are sealed, so unlike the end of the active segment a torn write in one is
+// corruption rather than something to recover from.
+func (l *FileTransactionLogger) readSegment(ctx context.Context, path string, r *fileRead, out chan<- Event) (bool, error) {
+	file, err := os.Open(path) //nolint:gosec // path is a segment in our own log directory
+	if err != nil {
+		return false, fmt.Errorf("failed to open segment: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	done, err := l.replay(ctx, newRecordReader(file), r, out)
+	if err != nil {
+		return false, fmt.Errorf("failed to read segment %s: %w", filepath.Base(path), err)
+	}
+	return done, nil
+}
+
+// fileRead is a read of the file log, which may span several segments.
+type fileRead struct {
+	readRange
+
+	// started is set once the read has found the event after the one it starts after
+	started bool
+}
+
+// replay sends the events in r from reader to out, updating the last sequence as it goes. It reports whether it
+// stopped because it reached the end of the range rather than the end of the log. The first event it sends has to be
+// the one after r.after, anything later means the events in between have been compacted away and it fails with
+// ErrCompacted, rather than carrying on with a store which is missing them.
+func (l *FileTransactionLogger) replay(ctx context.Context, reader *recordReader, r *fileRead, out chan<- Event) (bool, error) {
+	for {
+		e, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if e.Sequence <= r.after {
+			continue
+		}
+		if r.beyond(e.Sequence) {
+			return true, nil
+		}
+		if !r.started && e.Sequence != r.after+1 {
+			return false, fmt.Errorf("%w: log resumes at %d, after %d was requested", ErrCompacted, e.Sequence, r.after)
+		}
+		r.started = true
+
+		// atomically compare and swap the value for our latest sequence
+		for {
+			last := l.lastSequence.Load()
+			if last >= e.Sequence {
+				return false, fmt.Errorf("transaction sequence out of sequence: %d >= %d", last, e.Sequence)
+			}
+
+			if l.lastSequence.CompareAndSwap(last, e.Sequence) {
+				if err = send(ctx, out, e); err != nil {
+					return false, err
 				}
+				break
 			}
 		}
+	}
+}
 
-		if err := scanner.Err(); err != nil {
-			outErr <- fmt.Errorf("error reading events: %w", err)
-			return
+// recoverTornWrite applies the configured RecoveryMode to a torn write found after the last good record at offset.
+func (l *FileTransactionLogger) recoverTornWrite(offset int64, tornErr error) error {
+	switch l.recovery {
+	case RecoveryRepair:
+		if err := l.truncate(offset); err != nil {
+			return fmt.Errorf("failed to repair torn write at offset %d: %w", offset, err)
 		}
-	}()
+		slog.Warn("repaired torn write in transaction log", slog.Int64("offset", offset), slog.Any("error", tornErr))
+		return nil
+	case RecoverySkip:
+		slog.Warn("skipping torn write in transaction log", slog.Int64("offset", offset), slog.Any("error", tornErr))
+		return nil
+	case RecoveryStrict:
+		return tornErr
+	default:
+		return tornErr
+	}
+}
 
-	return outEvent, outErr
+// truncate cuts the log back to size and, for handles that track their own offset, moves it to the new end so that
+// subsequent writes don't leave a hole.
+func (l *FileTransactionLogger) truncate(size int64) error {
+	t, ok := l.file.(interface{ Truncate(size int64) error })
+	if !ok {
+		return errors.New("file handle does not support truncation")
+	}
+
+	if err := t.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate log to %d bytes: %w", size, err)
+	}
+
+	if seeker, ok := l.file.(io.Seeker); ok {
+		if _, err := seeker.Seek(size, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek to end of log: %w", err)
+		}
+	}
+
+	return nil
 }
 
+// Close stops the logger, once every event already written has been flushed, and releases the log. It may be called on
+// a logger which was only used to read the log, without Run. Writes made once it has begun fail with ErrLogStopped.
 func (l *FileTransactionLogger) Close() error {
-	close(l.events)
-	return l.file.Close()
+	if l.events != nil {
+		l.sending.closeEvents(l.events)
+		<-l.done // wait for goroutine to drain remaining events
+	}
+
+	err := l.file.Close()
+	if l.segments.lock != nil {
+		// only give up the log once we've finished writing to it
+		err = errors.Join(err, l.segments.lock.Unlock())
+	}
+	return err
 }
 
 type Event struct {
 	Sequence uint64
 	Kind     EventKind
 	Key      string
-	Value    string
+	Value    []byte
+	// Timestamp is when the event was written to the log. It is zero for events written before timestamps were
+	// recorded.
+	Timestamp time.Time
+	// Expires is when the key put by the event expires, or zero if it never does.
+	Expires time.Time
+	// ContentType is the media type of the value put by the event, or empty if none was given.
+	ContentType string
+	// Metadata is the user metadata stored alongside the value put by the event, or nil if there is none.
+	Metadata map[string]string
+	// Batch holds the puts and deletes of an EventBatch, in order. Only their Kind, Key, Value, Expires, ContentType
+	// and Metadata are set.
+	Batch []Event
 }
 
 type EventKind byte
@@ -140,4 +545,6 @@ const (
 	_ EventKind = iota
 	EventDelete
 	EventPut
+	// EventBatch is a set of puts and deletes which are applied together or not at all.
+	EventBatch
 )
@@ -2,8 +2,14 @@ package logger
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/synctest"
 	"time"
@@ -53,6 +59,11 @@ func (m *mockReadWriteCloser) String() string {
 	return m.Buffer.String()
 }
 
+// writeErr drops the sequence number returned by a write, for tests which only care whether it succeeded
+func writeErr(_ uint64, err error) error {
+	return err
+}
+
 // TestNewFileTransactionLogger tests the constructor
 func TestNewFileTransactionLogger(t *testing.T) {
 	mock := newMockReadWriteCloser("")
@@ -98,21 +109,17 @@ func TestFileTransactionLogger_WritePut(t *testing.T) {
 		// Give goroutine time to start
 		time.Sleep(10 * time.Millisecond)
 
-		logger.WritePut("key1", "value1")
-		logger.WritePut("key2", "value2")
+		assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+		assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key2", []byte("value2"))))
 
 		// Give time for writes to complete
 		time.Sleep(50 * time.Millisecond)
 
-		output := mock.String()
-		expectedLines := []string{
-			"1\t2\tkey1\tvalue1",
-			"2\t2\tkey2\tvalue2",
-		}
-
-		for _, expected := range expectedLines {
-			assert.Contains(t, output, expected)
+		expected := []Event{
+			{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
+			{Sequence: 2, Kind: EventPut, Key: "key2", Value: []byte("value2")},
 		}
+		assert.Equal(t, expected, withoutTimestamps(decodeAll(t, mock.String())))
 
 		last := logger.lastSequence.Load()
 		assert.Equal(t, uint64(2), last)
@@ -133,21 +140,17 @@ func TestFileTransactionLogger_WriteDelete(t *testing.T) {
 		// Give goroutine time to start
 		time.Sleep(10 * time.Millisecond)
 
-		logger.WriteDelete("key1")
-		logger.WriteDelete("key2")
+		assert.NoError(t, writeErr(logger.WriteDelete(t.Context(), "key1")))
+		assert.NoError(t, writeErr(logger.WriteDelete(t.Context(), "key2")))
 
 		// Give time for writes to complete
 		time.Sleep(50 * time.Millisecond)
 
-		output := mock.String()
-		expectedLines := []string{
-			"1\t1\tkey1\t",
-			"2\t1\tkey2\t",
-		}
-
-		for _, expected := range expectedLines {
-			assert.Contains(t, output, expected)
+		expected := []Event{
+			{Sequence: 1, Kind: EventDelete, Key: "key1"},
+			{Sequence: 2, Kind: EventDelete, Key: "key2"},
 		}
+		assert.Equal(t, expected, withoutTimestamps(decodeAll(t, mock.String())))
 
 		assert.Equal(t, uint64(2), logger.lastSequence.Load())
 
@@ -167,23 +170,19 @@ func TestFileTransactionLogger_MixedOperations(t *testing.T) {
 		// Give goroutine time to start
 		time.Sleep(10 * time.Millisecond)
 
-		logger.WritePut("key1", "value1")
-		logger.WriteDelete("key2")
-		logger.WritePut("key3", "value3")
+		assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+		assert.NoError(t, writeErr(logger.WriteDelete(t.Context(), "key2")))
+		assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key3", []byte("value3"))))
 
 		// Give time for writes to complete
 		time.Sleep(50 * time.Millisecond)
 
-		output := mock.String()
-		expectedLines := []string{
-			"1\t2\tkey1\tvalue1",
-			"2\t1\tkey2\t",
-			"3\t2\tkey3\tvalue3",
-		}
-
-		for _, expected := range expectedLines {
-			assert.Contains(t, output, expected)
+		expected := []Event{
+			{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
+			{Sequence: 2, Kind: EventDelete, Key: "key2"},
+			{Sequence: 3, Kind: EventPut, Key: "key3", Value: []byte("value3")},
 		}
+		assert.Equal(t, expected, withoutTimestamps(decodeAll(t, mock.String())))
 
 		assert.Equal(t, uint64(3), logger.lastSequence.Load())
 
@@ -222,7 +221,7 @@ func TestFileTransactionLogger_ReadEvents_EmptyFile(t *testing.T) {
 	mock := newMockReadWriteCloser("")
 	logger := NewFileTransactionLogger(mock)
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	// Collect all events
 	var events []Event
@@ -262,7 +261,7 @@ func TestFileTransactionLogger_ReadEvents_ValidData(t *testing.T) {
 	mock := newMockReadWriteCloser(data)
 	logger := NewFileTransactionLogger(mock)
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	// Collect all events
 	var events []Event
@@ -293,9 +292,9 @@ func TestFileTransactionLogger_ReadEvents_ValidData(t *testing.T) {
 	}
 
 	expectedEvents := []Event{
-		{Sequence: 1, Kind: EventPut, Key: "key1", Value: "value1"},
-		{Sequence: 2, Kind: EventDelete, Key: "key2", Value: "deleted"},
-		{Sequence: 3, Kind: EventPut, Key: "key3", Value: "value3"},
+		{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
+		{Sequence: 2, Kind: EventDelete, Key: "key2", Value: []byte("deleted")},
+		{Sequence: 3, Kind: EventPut, Key: "key3", Value: []byte("value3")},
 	}
 
 	require.Len(t, events, len(expectedEvents))
@@ -316,7 +315,7 @@ func TestFileTransactionLogger_ReadEvents_InvalidFormat(t *testing.T) {
 	mock := newMockReadWriteCloser(data)
 	logger := NewFileTransactionLogger(mock)
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	// Collect all events
 	var events []Event
@@ -352,7 +351,7 @@ func TestFileTransactionLogger_ReadEvents_OutOfSequence(t *testing.T) {
 	mock := newMockReadWriteCloser(data)
 	logger := NewFileTransactionLogger(mock)
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	// Collect all events
 	var events []Event
@@ -393,7 +392,7 @@ func TestFileTransactionLogger_ReadEvents_UpdatesLastSequence(t *testing.T) {
 
 	assert.Equal(t, uint64(0), logger.lastSequence.Load(), "Expected initial lastSequence to be 0")
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	// Drain channels
 	done := make(chan bool)
@@ -435,7 +434,7 @@ func TestFileTransactionLogger_SequenceIncrement(t *testing.T) {
 
 		// Write multiple events
 		for i := 1; i <= 10; i++ {
-			logger.WritePut(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+			assert.NoError(t, writeErr(logger.WritePut(t.Context(), fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)))))
 		}
 
 		// Give time for writes to complete
@@ -444,10 +443,11 @@ func TestFileTransactionLogger_SequenceIncrement(t *testing.T) {
 		assert.Equal(t, uint64(10), logger.lastSequence.Load())
 
 		// Verify all sequences are in the output
-		output := mock.String()
-		for i := 1; i <= 10; i++ {
-			expected := fmt.Sprintf("%d\t2\tkey%d\tvalue%d", i, i, i)
-			assert.Contains(t, output, expected)
+		events := decodeAll(t, mock.String())
+		require.Len(t, events, 10)
+		for i, e := range withoutTimestamps(events) {
+			expected := Event{Sequence: uint64(i + 1), Kind: EventPut, Key: fmt.Sprintf("key%d", i+1), Value: []byte(fmt.Sprintf("value%d", i+1))}
+			assert.Equal(t, expected, e)
 		}
 
 		err := logger.Close()
@@ -478,7 +478,7 @@ func TestFileTransactionLogger_WriteError(t *testing.T) {
 	// Give goroutine time to start
 	time.Sleep(10 * time.Millisecond)
 
-	logger.WritePut("key1", "value1")
+	assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
 
 	// Wait for error to be sent
 	select {
@@ -502,21 +502,21 @@ func TestFileTransactionLogger_ReadEvents_WithValues(t *testing.T) {
 			name: "empty values for delete",
 			data: "1\t1\tkey1\t\n",
 			expected: []Event{
-				{Sequence: 1, Kind: EventDelete, Key: "key1", Value: ""},
+				{Sequence: 1, Kind: EventDelete, Key: "key1", Value: nil},
 			},
 		},
 		{
 			name: "simple values",
 			data: "1\t2\tkey1\tvalue1\n",
 			expected: []Event{
-				{Sequence: 1, Kind: EventPut, Key: "key1", Value: "value1"},
+				{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
 			},
 		},
 		{
 			name: "numeric values",
 			data: "1\t2\tkey1\t12345\n",
 			expected: []Event{
-				{Sequence: 1, Kind: EventPut, Key: "key1", Value: "12345"},
+				{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("12345")},
 			},
 		},
 	}
@@ -526,7 +526,7 @@ func TestFileTransactionLogger_ReadEvents_WithValues(t *testing.T) {
 			mock := newMockReadWriteCloser(tc.data)
 			logger := NewFileTransactionLogger(mock)
 
-			eventChan, errChan := logger.ReadEvents()
+			eventChan, errChan := logger.ReadEvents(t.Context())
 
 			var events []Event
 			done := make(chan bool)
@@ -584,27 +584,27 @@ func TestParseEvent(t *testing.T) {
 		{
 			name:     "put event",
 			line:     "1\t2\tkey1\tvalue1",
-			expected: Event{Sequence: 1, Kind: EventPut, Key: "key1", Value: "value1"},
+			expected: Event{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
 		},
 		{
 			name:     "delete event with empty value",
 			line:     "2\t1\tkey2\t",
-			expected: Event{Sequence: 2, Kind: EventDelete, Key: "key2", Value: ""},
+			expected: Event{Sequence: 2, Kind: EventDelete, Key: "key2", Value: nil},
 		},
 		{
 			name:     "delete event with no value field",
 			line:     "3\t1\tkey3",
-			expected: Event{Sequence: 3, Kind: EventDelete, Key: "key3", Value: ""},
+			expected: Event{Sequence: 3, Kind: EventDelete, Key: "key3", Value: nil},
 		},
 		{
 			name:     "large sequence number",
 			line:     "999999\t2\tkey\tval",
-			expected: Event{Sequence: 999999, Kind: EventPut, Key: "key", Value: "val"},
+			expected: Event{Sequence: 999999, Kind: EventPut, Key: "key", Value: []byte("val")},
 		},
 		{
 			name:     "numeric value",
 			line:     "1\t2\tkey\t12345",
-			expected: Event{Sequence: 1, Kind: EventPut, Key: "key", Value: "12345"},
+			expected: Event{Sequence: 1, Kind: EventPut, Key: "key", Value: []byte("12345")},
 		},
 		{
 			name:      "too few fields",
@@ -655,10 +655,6 @@ func TestParseEvent(t *testing.T) {
 // TestFileTransactionLogger_WriteReadRoundTrip tests that events written via Run can be read back
 // by ReadEvents. This is the highest-value test for the file logger since it validates the
 // contract between the write and read halves.
-//
-// BUG: This test is expected to fail. WriteDelete produces "seq\t1\tkey\t\n" (empty value),
-// but ReadEvents uses fmt.Sscanf with %s which cannot match an empty string. The serialization
-// format does not round-trip for DELETE events.
 func TestFileTransactionLogger_WriteReadRoundTrip(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
 		mock := newMockReadWriteCloser("")
@@ -667,9 +663,9 @@ func TestFileTransactionLogger_WriteReadRoundTrip(t *testing.T) {
 
 		time.Sleep(time.Millisecond)
 
-		logger.WritePut("key1", "value1")
-		logger.WriteDelete("key2")
-		logger.WritePut("key3", "value3")
+		assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+		assert.NoError(t, writeErr(logger.WriteDelete(t.Context(), "key2")))
+		assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key3", []byte("value3"))))
 
 		time.Sleep(time.Millisecond)
 
@@ -679,7 +675,7 @@ func TestFileTransactionLogger_WriteReadRoundTrip(t *testing.T) {
 
 		// Read back from the same buffer using a fresh logger (resets lastSequence)
 		readLogger := NewFileTransactionLogger(mock)
-		eventChan, errChan := readLogger.ReadEvents()
+		eventChan, errChan := readLogger.ReadEvents(t.Context())
 
 		var events []Event
 		for e := range eventChan {
@@ -699,15 +695,40 @@ func TestFileTransactionLogger_WriteReadRoundTrip(t *testing.T) {
 
 		assert.Equal(t, EventPut, events[0].Kind)
 		assert.Equal(t, "key1", events[0].Key)
-		assert.Equal(t, "value1", events[0].Value)
+		assert.Equal(t, []byte("value1"), events[0].Value)
 
 		assert.Equal(t, EventDelete, events[1].Kind)
 		assert.Equal(t, "key2", events[1].Key)
-		assert.Equal(t, "", events[1].Value, "DELETE event should have empty value")
+		assert.Empty(t, events[1].Value, "DELETE event should have empty value")
 
 		assert.Equal(t, EventPut, events[2].Kind)
 		assert.Equal(t, "key3", events[2].Key)
-		assert.Equal(t, "value3", events[2].Value)
+		assert.Equal(t, []byte("value3"), events[2].Value)
+	})
+}
+
+// TestFileTransactionLogger_WriteBatch tests that a batch is written as a single event holding every op, and that a
+// batch which isn't made of puts and deletes is rejected
+func TestFileTransactionLogger_WriteBatch(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		mock := newMockReadWriteCloser("")
+		logger := NewFileTransactionLogger(mock, WithDurableWrites())
+		logger.Run()
+
+		expires := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+		ops := []Event{PutOp("key1", []byte("value1"), ExpiresAt(expires)), DeleteOp("key2")}
+		require.NoError(t, writeErr(logger.WritePut(t.Context(), "key0", []byte("value0"))))
+		require.NoError(t, writeErr(logger.WriteBatch(t.Context(), ops)))
+		require.Error(t, writeErr(logger.WriteBatch(t.Context(), nil)))
+		require.Error(t, writeErr(logger.WriteBatch(t.Context(), []Event{{Kind: EventBatch}})))
+		require.NoError(t, logger.Close())
+
+		events := withoutTimestamps(decodeAll(t, mock.String()))
+		expected := []Event{
+			{Sequence: 1, Kind: EventPut, Key: "key0", Value: []byte("value0")},
+			{Sequence: 2, Kind: EventBatch, Batch: ops},
+		}
+		assert.Equal(t, expected, events)
 	})
 }
 
@@ -724,7 +745,7 @@ func TestFileTransactionLogger_ReadEvents_DeleteHasEmptyValue(t *testing.T) {
 	mock := newMockReadWriteCloser(data)
 	logger := NewFileTransactionLogger(mock)
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	var events []Event
 	for e := range eventChan {
@@ -741,5 +762,517 @@ func TestFileTransactionLogger_ReadEvents_DeleteHasEmptyValue(t *testing.T) {
 	}
 
 	require.Len(t, events, 2, "Expected both PUT and DELETE events to be read")
-	assert.Equal(t, "", events[1].Value, "DELETE event should have empty value, not a leaked value from the previous PUT")
+	assert.Empty(t, events[1].Value, "DELETE event should have empty value, not a leaked value from the previous PUT")
+}
+
+// collectEvents drains ReadEvents, returning the events read and the first error reported
+func collectEvents(t *testing.T, l EventReader, opts ...ReadOption) ([]Event, error) {
+	eventChan, errChan := l.ReadEvents(t.Context(), opts...)
+
+	var events []Event
+	for e := range eventChan {
+		events = append(events, e)
+	}
+
+	var err error
+	for e := range errChan {
+		if err == nil {
+			err = e
+		}
+	}
+
+	return events, err
+}
+
+// openTempLog writes data to a temporary log file and opens it the same way cmd/api does
+func openTempLog(t *testing.T, data []byte) *os.File {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "transaction.log")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = file.Close() })
+
+	return file
+}
+
+// TestFileTransactionLogger_ReadEvents_TornWrite tests each recovery mode against logs whose final record is incomplete
+func TestFileTransactionLogger_ReadEvents_TornWrite(t *testing.T) {
+	good := appendRecord(nil, Event{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")})
+	good = appendRecord(good, Event{Sequence: 2, Kind: EventPut, Key: "key2", Value: []byte("value2")})
+	next := appendRecord(nil, Event{Sequence: 3, Kind: EventPut, Key: "key3", Value: []byte("value3")})
+
+	badChecksum := bytes.Clone(next)
+	badChecksum[len(badChecksum)-1] ^= 0xff
+
+	tails := map[string][]byte{
+		"partial header":      next[:5],
+		"partial payload":     next[:len(next)-3],
+		"bad trailing record": badChecksum,
+		"unterminated legacy": []byte("3\t2\tkey3\tval"),
+		"only the magic byte": {recordMagic},
+		"zero filled tail":    make([]byte, 16),
+	}
+
+	expected := []Event{
+		{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
+		{Sequence: 2, Kind: EventPut, Key: "key2", Value: []byte("value2")},
+	}
+
+	for name, tail := range tails {
+		data := append(bytes.Clone(good), tail...)
+
+		t.Run(name+"/strict", func(t *testing.T) {
+			file := openTempLog(t, data)
+			events, err := collectEvents(t, NewFileTransactionLogger(file))
+
+			assert.Equal(t, expected, events)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, ErrTornWrite)
+
+			info, err := file.Stat()
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), info.Size(), "strict mode must not modify the log")
+		})
+
+		t.Run(name+"/skip", func(t *testing.T) {
+			file := openTempLog(t, data)
+			events, err := collectEvents(t, NewFileTransactionLogger(file, WithRecoveryMode(RecoverySkip)))
+
+			assert.Equal(t, expected, events)
+			assert.NoError(t, err)
+
+			info, err := file.Stat()
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), info.Size(), "skip mode must not modify the log")
+		})
+
+		t.Run(name+"/repair", func(t *testing.T) {
+			file := openTempLog(t, data)
+			events, err := collectEvents(t, NewFileTransactionLogger(file, WithRecoveryMode(RecoveryRepair)))
+
+			assert.Equal(t, expected, events)
+			assert.NoError(t, err)
+
+			info, err := file.Stat()
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(good)), info.Size(), "repair mode should truncate back to the last good record")
+		})
+	}
+}
+
+// TestFileTransactionLogger_ReadEvents_RepairThenWrite tests that a repaired log accepts new writes which replay cleanly
+func TestFileTransactionLogger_ReadEvents_RepairThenWrite(t *testing.T) {
+	data := appendRecord(nil, Event{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")})
+	torn := appendRecord(nil, Event{Sequence: 2, Kind: EventPut, Key: "key2", Value: []byte("value2")})
+	data = append(data, torn[:len(torn)/2]...)
+
+	file := openTempLog(t, data)
+	logger := NewFileTransactionLogger(file, WithRecoveryMode(RecoveryRepair))
+
+	_, err := collectEvents(t, logger)
+	require.NoError(t, err)
+
+	synctest.Test(t, func(t *testing.T) {
+		logger.Run()
+		assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key3", []byte("value3"))))
+		synctest.Wait()
+		require.NoError(t, logger.Close())
+	})
+
+	reopened, err := os.Open(file.Name())
+	require.NoError(t, err)
+	defer func() { _ = reopened.Close() }()
+
+	events, err := collectEvents(t, NewFileTransactionLogger(reopened))
+	require.NoError(t, err)
+
+	expected := []Event{
+		{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
+		{Sequence: 2, Kind: EventPut, Key: "key3", Value: []byte("value3")},
+	}
+	assert.Equal(t, expected, withoutTimestamps(events))
+}
+
+// TestFileTransactionLogger_ReadEvents_MidLogCorruption tests that corruption before the final record is never
+// treated as a torn write, whatever the recovery mode
+func TestFileTransactionLogger_ReadEvents_MidLogCorruption(t *testing.T) {
+	first := appendRecord(nil, Event{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")})
+	first[len(first)-1] ^= 0xff
+	data := appendRecord(first, Event{Sequence: 2, Kind: EventPut, Key: "key2", Value: []byte("value2")})
+
+	for _, mode := range []RecoveryMode{RecoveryStrict, RecoveryRepair, RecoverySkip} {
+		t.Run(mode.String(), func(t *testing.T) {
+			file := openTempLog(t, data)
+			events, err := collectEvents(t, NewFileTransactionLogger(file, WithRecoveryMode(mode)))
+
+			assert.Empty(t, events)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, ErrChecksumMismatch)
+			assert.NotErrorIs(t, err, ErrTornWrite)
+
+			info, err := file.Stat()
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), info.Size())
+		})
+	}
+}
+
+// TestFileTransactionLogger_ReadEvents_CorruptLength tests that a record whose length was corrupted to run past the end
+// of the log is reported as corruption, rather than repaired as a torn write by throwing away the records after it
+func TestFileTransactionLogger_ReadEvents_CorruptLength(t *testing.T) {
+	var data []byte
+	var second int
+	for i := range 5 {
+		if i == 1 {
+			second = len(data)
+		}
+		data = appendRecord(data, Event{Sequence: uint64(i + 1), Kind: EventPut, Key: fmt.Sprint("key", i), Value: []byte("value")})
+	}
+	data[second+3] ^= 0x01
+
+	for _, mode := range []RecoveryMode{RecoveryStrict, RecoveryRepair, RecoverySkip} {
+		t.Run(mode.String(), func(t *testing.T) {
+			file := openTempLog(t, data)
+			events, err := collectEvents(t, NewFileTransactionLogger(file, WithRecoveryMode(mode)))
+
+			assert.Len(t, events, 1)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, ErrCorruptRecord)
+			assert.NotErrorIs(t, err, ErrTornWrite)
+
+			info, err := file.Stat()
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), info.Size())
+		})
+	}
+}
+
+// TestFileTransactionLogger_ReadEvents_RepairUnsupported tests that repair fails loudly when the handle can't be truncated
+func TestFileTransactionLogger_ReadEvents_RepairUnsupported(t *testing.T) {
+	mock := newMockReadWriteCloser("1\t2\tkey1\tvalue1\n2\t2\tkey2")
+	logger := NewFileTransactionLogger(mock, WithRecoveryMode(RecoveryRepair))
+
+	events, err := collectEvents(t, logger)
+	assert.Len(t, events, 1)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "does not support truncation")
+}
+
+// syncRecorder is a mock file handle which records the order of writes and syncs. If gate is set, each write
+// blocks until gate yields.
+type syncRecorder struct {
+	*mockReadWriteCloser
+	mu    sync.Mutex
+	calls []string
+	gate  chan struct{}
+}
+
+func (s *syncRecorder) Write(p []byte) (int, error) {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	s.calls = append(s.calls, "write")
+	s.mu.Unlock()
+	return s.mockReadWriteCloser.Write(p)
+}
+
+func (s *syncRecorder) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, "sync")
+	return nil
+}
+
+func (s *syncRecorder) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.calls)
+}
+
+// TestFileTransactionLogger_DurableWrites tests that durable writes are on the file by the time they return
+func TestFileTransactionLogger_DurableWrites(t *testing.T) {
+	mock := newMockReadWriteCloser("")
+	logger := NewFileTransactionLogger(mock, WithDurableWrites())
+	logger.Run()
+
+	seq, err := logger.WritePut(t.Context(), "key1", []byte("value1"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+	assert.Equal(t, []Event{{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")}}, withoutTimestamps(decodeAll(t, mock.String())))
+
+	seq, err = logger.WriteDelete(t.Context(), "key1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), seq)
+	assert.Len(t, decodeAll(t, mock.String()), 2)
+
+	seq, err = logger.WriteBatch(t.Context(), []Event{DeleteOp("key2")})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), seq)
+
+	require.NoError(t, logger.Close())
+}
+
+// TestFileTransactionLogger_DurableWriteError tests that durable writes report failures to the caller
+func TestFileTransactionLogger_DurableWriteError(t *testing.T) {
+	logger := NewFileTransactionLogger(&failingWriter{}, WithDurableWrites())
+	logger.Run()
+
+	err := writeErr(logger.WritePut(t.Context(), "key1", []byte("value1")))
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "simulated write error")
+
+	// the same failure is still reported to whoever monitors Err
+	assert.Error(t, <-logger.Err())
+}
+
+// TestFileTransactionLogger_WriteAfterFailure tests that writes fail fast once a write error has stopped the logger,
+// rather than blocking once nothing reads them any more
+func TestFileTransactionLogger_WriteAfterFailure(t *testing.T) {
+	logger := NewFileTransactionLogger(&failingWriter{})
+	logger.Run()
+
+	require.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+	assert.ErrorContains(t, <-logger.Err(), "simulated write error")
+	_, open := <-logger.Err()
+	assert.False(t, open, "Err is closed once the logger has stopped")
+
+	for range 20 {
+		assert.ErrorIs(t, writeErr(logger.WritePut(t.Context(), "key2", []byte("value2"))), ErrLogStopped)
+	}
+	assert.ErrorIs(t, writeErr(logger.WriteDelete(t.Context(), "key1")), ErrLogStopped)
+	assert.ErrorIs(t, logger.Compact(1), ErrLogStopped)
+	require.NoError(t, logger.Close())
+}
+
+// TestFileTransactionLogger_DurableWriteAfterFailure tests that every durable writer is released when the logger stops,
+// including those queued behind the write which failed
+func TestFileTransactionLogger_DurableWriteAfterFailure(t *testing.T) {
+	logger := NewFileTransactionLogger(&failingWriter{}, WithDurableWrites())
+	logger.Run()
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Go(func() {
+			assert.Error(t, writeErr(logger.WritePut(t.Context(), fmt.Sprintf("key%d", i), []byte("value"))))
+		})
+	}
+	wg.Wait()
+
+	require.NoError(t, logger.Close())
+}
+
+// TestFileTransactionLogger_WriteDuringClose tests that writes racing with Close either make it into the log or fail
+// with ErrLogStopped, rather than panicking on the closed queue
+func TestFileTransactionLogger_WriteDuringClose(t *testing.T) {
+	mock := newMockReadWriteCloser("")
+	logger := NewFileTransactionLogger(mock, WithDurableWrites())
+	logger.Run()
+
+	var written atomic.Int64
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Go(func() {
+			for {
+				err := writeErr(logger.WritePut(t.Context(), fmt.Sprintf("key%d", i), []byte("value")))
+				if errors.Is(err, ErrLogStopped) {
+					return
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+				written.Add(1)
+			}
+		})
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, logger.Close())
+	wg.Wait()
+
+	assert.Len(t, decodeAll(t, mock.String()), int(written.Load()))
+}
+
+// TestFileTransactionLogger_WriteCancelled tests that a write whose context is already done is never logged
+func TestFileTransactionLogger_WriteCancelled(t *testing.T) {
+	mock := newMockReadWriteCloser("")
+	logger := NewFileTransactionLogger(mock, WithDurableWrites())
+	logger.Run()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	assert.ErrorIs(t, writeErr(logger.WritePut(ctx, "key1", []byte("value1"))), context.Canceled)
+	assert.ErrorIs(t, writeErr(logger.WriteDelete(ctx, "key1")), context.Canceled)
+
+	require.NoError(t, writeErr(logger.WritePut(t.Context(), "key2", []byte("value2"))))
+	require.NoError(t, logger.Close())
+	assert.Equal(t, []Event{{Sequence: 1, Kind: EventPut, Key: "key2", Value: []byte("value2")}}, withoutTimestamps(decodeAll(t, mock.String())))
+}
+
+// TestFileTransactionLogger_SyncPolicy tests when each policy fsyncs for a series of sequential durable writes
+func TestFileTransactionLogger_SyncPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   SyncPolicy
+		writes   int
+		expected []string
+	}{
+		{
+			name:     "always",
+			policy:   SyncAlways(),
+			writes:   2,
+			expected: []string{"write", "sync", "write", "sync"},
+		},
+		{
+			name:     "never",
+			policy:   SyncNever(),
+			writes:   2,
+			expected: []string{"write", "write"},
+		},
+		{
+			name:   "every 3 events, remainder synced on close",
+			policy: SyncEveryN(3),
+			writes: 5,
+			expected: []string{
+				"write", "write", "write", "sync",
+				"write", "write", "sync",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			file := &syncRecorder{mockReadWriteCloser: newMockReadWriteCloser("")}
+			logger := NewFileTransactionLogger(file, WithDurableWrites(), WithSyncPolicy(tc.policy))
+			logger.Run()
+
+			for i := range tc.writes {
+				require.NoError(t, writeErr(logger.WritePut(t.Context(), fmt.Sprintf("key%d", i), []byte("value"))))
+			}
+			require.NoError(t, logger.Close())
+
+			assert.Equal(t, tc.expected, file.Calls())
+		})
+	}
+}
+
+// TestFileTransactionLogger_SyncInterval tests that interval syncing happens on the first write after the interval
+// has passed, and in the background for writes made during a quiet period
+func TestFileTransactionLogger_SyncInterval(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		file := &syncRecorder{mockReadWriteCloser: newMockReadWriteCloser("")}
+		logger := NewFileTransactionLogger(file, WithDurableWrites(), WithSyncPolicy(SyncInterval(100*time.Millisecond)))
+		logger.Run()
+
+		require.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+		require.NoError(t, writeErr(logger.WritePut(t.Context(), "key2", []byte("value2"))))
+		assert.Equal(t, []string{"write", "write"}, file.Calls(), "writes within the interval should not sync")
+
+		time.Sleep(100 * time.Millisecond)
+		synctest.Wait()
+		assert.Equal(t, []string{"write", "write", "sync"}, file.Calls(), "ticker should sync the quiet period's writes")
+
+		time.Sleep(100 * time.Millisecond)
+		synctest.Wait()
+		assert.Len(t, file.Calls(), 3, "ticker should not sync when nothing was written")
+
+		time.Sleep(50 * time.Millisecond)
+		require.NoError(t, writeErr(logger.WritePut(t.Context(), "key3", []byte("value3"))))
+		time.Sleep(60 * time.Millisecond)
+		require.NoError(t, writeErr(logger.WritePut(t.Context(), "key4", []byte("value4"))))
+
+		require.NoError(t, logger.Close())
+		assert.Equal(t, []string{"write", "write", "sync", "write", "sync", "write", "sync"}, file.Calls())
+	})
+}
+
+// TestFileTransactionLogger_GroupCommit tests that durable writes queued behind an in-flight write are committed with
+// a single write and sync, and are all acknowledged once it completes
+func TestFileTransactionLogger_GroupCommit(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		gate := make(chan struct{})
+		file := &syncRecorder{mockReadWriteCloser: newMockReadWriteCloser(""), gate: gate}
+		logger := NewFileTransactionLogger(file, WithDurableWrites(), WithSyncPolicy(SyncAlways()))
+		logger.Run()
+
+		const writers = 10
+		var acked sync.WaitGroup
+		acked.Add(writers + 1)
+
+		// the first write holds the writer loop inside Write
+		go func() {
+			defer acked.Done()
+			assert.NoError(t, writeErr(logger.WritePut(t.Context(), "first", []byte("value"))))
+		}()
+		synctest.Wait()
+
+		// everything else queues up behind it
+		for i := range writers {
+			go func() {
+				defer acked.Done()
+				assert.NoError(t, writeErr(logger.WritePut(t.Context(), fmt.Sprintf("key%d", i), []byte("value"))))
+			}()
+		}
+		synctest.Wait()
+
+		close(gate)
+		acked.Wait()
+
+		assert.Equal(t, []string{"write", "sync", "write", "sync"}, file.Calls())
+		assert.Len(t, decodeAll(t, file.String()), writers+1)
+		assert.Equal(t, uint64(writers+1), logger.lastSequence.Load())
+
+		require.NoError(t, logger.Close())
+	})
+}
+
+// TestFileTransactionLogger_ReadEvents_Range tests ranged reads of a single file log
+func TestFileTransactionLogger_ReadEvents_Range(t *testing.T) {
+	data := "1\t2\tkey1\tvalue1\n2\t2\tkey2\tvalue2\n3\t2\tkey3\tvalue3\n4\t2\tkey4\tvalue4\n"
+
+	tests := []struct {
+		name     string
+		opts     []ReadOption
+		expected []Event
+	}{
+		{name: "everything", opts: nil, expected: putEvents(1, 4)},
+		{name: "after", opts: []ReadOption{ReadAfter(2)}, expected: putEvents(3, 4)},
+		{name: "through", opts: []ReadOption{ReadThrough(2)}, expected: putEvents(1, 2)},
+		{name: "between", opts: []ReadOption{ReadAfter(1), ReadThrough(3)}, expected: putEvents(2, 3)},
+		{name: "past the end", opts: []ReadOption{ReadAfter(4), ReadThrough(10)}, expected: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := NewFileTransactionLogger(newMockReadWriteCloser(data))
+
+			var events []Event
+			eventChan, errChan := logger.ReadEvents(t.Context(), tc.opts...)
+			for e := range eventChan {
+				events = append(events, e)
+			}
+			require.NoError(t, <-errChan)
+			assert.Equal(t, tc.expected, events)
+		})
+	}
+}
+
+// TestFileTransactionLogger_ReadEvents_Cancelled tests that a read stops once its context is done, rather than waiting
+// for the rest of the log to be taken
+func TestFileTransactionLogger_ReadEvents_Cancelled(t *testing.T) {
+	data := "1\t2\tkey1\tvalue1\n2\t2\tkey2\tvalue2\n3\t2\tkey3\tvalue3\n"
+	logger := NewFileTransactionLogger(newMockReadWriteCloser(data))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	eventChan, errChan := logger.ReadEvents(ctx)
+	assert.Equal(t, putEvents(1, 1), []Event{<-eventChan})
+	cancel()
+
+	for range eventChan {
+		// at most the event which was already on its way
+	}
+	assert.ErrorIs(t, <-errChan, context.Canceled)
 }
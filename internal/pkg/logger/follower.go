@@ -0,0 +1,193 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrReadOnly is returned for writes made through a replica which isn't the log's writer.
+	ErrReadOnly = errors.New("transaction log is read only on this replica")
+	// ErrCompacted is returned when the events a reader needs have already been compacted away. The reader has to
+	// start again from a newer snapshot.
+	ErrCompacted = errors.New("events have been compacted away")
+)
+
+// ReadOnlyLog is the TransactionLog of a replica which follows another's writes. Every write fails with ErrReadOnly.
+type ReadOnlyLog struct{}
+
+func (ReadOnlyLog) WritePut(_ context.Context, _ string, _ []byte, _ ...WriteOption) (uint64, error) {
+	return 0, ErrReadOnly
+}
+
+func (ReadOnlyLog) WriteDelete(_ context.Context, _ string) (uint64, error) {
+	return 0, ErrReadOnly
+}
+
+func (ReadOnlyLog) WriteBatch(_ context.Context, _ []Event) (uint64, error) {
+	return 0, ErrReadOnly
+}
+
+// EventSource is a log which can be tailed by a Follower.
+type EventSource interface {
+	// Poll calls fn, in order, with every event after the given sequence which is currently in the log, stopping at
+	// the first error fn returns, or with the context's error once it is done.
+	Poll(ctx context.Context, after uint64, fn func(Event) error) error
+}
+
+func NewFollower(source EventSource, after uint64, apply func(Event) error, opts ...FollowerOption) *Follower {
+	f := &Follower{
+		source:   source,
+		apply:    apply,
+		interval: 100 * time.Millisecond,
+		maxLag:   5 * time.Second,
+	}
+	f.sequence.Store(after)
+	f.caughtUp.Store(time.Now().UnixNano())
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+// Follower keeps a replica up to date with a log written by another, by polling the log for new events and applying
+// each of them in order. Replication lag is bounded by the poll interval for as long as the follower can keep up, and
+// Lag reports how far behind it currently is.
+type Follower struct {
+	source   EventSource
+	apply    func(Event) error
+	interval time.Duration
+	maxLag   time.Duration
+
+	// sequence is the last event applied
+	sequence atomic.Uint64
+	// caughtUp is when the follower last reached the end of the log, in unix nanoseconds
+	caughtUp atomic.Int64
+
+	errors <-chan error
+	// cancel abandons the poll in progress when the follower is closed
+	cancel    context.CancelFunc
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type FollowerOption = func(*Follower)
+
+// WithPollInterval sets how often the follower checks the log for new events. The default is every 100ms.
+func WithPollInterval(interval time.Duration) FollowerOption {
+	return func(f *Follower) {
+		f.interval = interval
+	}
+}
+
+// WithMaxLag sets how far the follower may fall behind before it warns about it. The default is 5s.
+func WithMaxLag(lag time.Duration) FollowerOption {
+	return func(f *Follower) {
+		f.maxLag = lag
+	}
+}
+
+// Sequence returns the sequence number of the last event applied.
+func (f *Follower) Sequence() uint64 {
+	return f.sequence.Load()
+}
+
+// Lag returns how long it has been since the follower last applied everything in the log.
+func (f *Follower) Lag() time.Duration {
+	return time.Since(time.Unix(0, f.caughtUp.Load()))
+}
+
+// Lagging reports whether the follower has fallen further behind than the maximum lag.
+func (f *Follower) Lagging() bool {
+	return f.Lag() > f.maxLag
+}
+
+// Err reports the error which stopped the follower, if any. ErrCompacted means it fell too far behind.
+func (f *Follower) Err() <-chan error {
+	return f.errors
+}
+
+// Run tails the log until Close is called or an error is reported on Err.
+func (f *Follower) Run() {
+	errs := make(chan error, 1)
+	f.errors = errs
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	f.stop = make(chan struct{})
+	f.done = make(chan struct{})
+	go f.run(ctx, errs)
+}
+
+func (f *Follower) run(ctx context.Context, errs chan<- error) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	lagging := false
+	for {
+		if err := f.Poll(ctx); err != nil {
+			// a poll abandoned by Close isn't a failure
+			if ctx.Err() == nil {
+				errs <- err
+			}
+			return
+		}
+
+		if f.Lagging() != lagging {
+			lagging = !lagging
+			if lagging {
+				slog.Warn("follower is lagging behind the transaction log", slog.Duration("lag", f.Lag()))
+			} else {
+				slog.Info("follower has caught up with the transaction log", slog.Uint64("sequence", f.Sequence()))
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Poll applies every event currently in the log which hasn't been applied yet, giving up between events once ctx is
+// done. It is called by Run, but can also be used directly to catch up once the writer has gone away.
+func (f *Follower) Poll(ctx context.Context) error {
+	err := f.source.Poll(ctx, f.sequence.Load(), func(e Event) error {
+		if err := f.apply(e); err != nil {
+			return fmt.Errorf("failed to apply event %d: %w", e.Sequence, err)
+		}
+		f.sequence.Store(e.Sequence)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f.caughtUp.Store(time.Now().UnixNano())
+	return nil
+}
+
+// Close stops the follower, abandoning any poll in progress between events and waiting for it to return. It is safe
+// to call more than once.
+func (f *Follower) Close() error {
+	if f.stop == nil {
+		return nil
+	}
+
+	f.closeOnce.Do(func() {
+		f.cancel()
+		close(f.stop)
+	})
+	<-f.done
+	return nil
+}
@@ -0,0 +1,152 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceSource is an EventSource over an in memory log which can be appended to
+type sliceSource struct {
+	mu     sync.Mutex
+	events []Event
+	err    error
+}
+
+func (s *sliceSource) Poll(ctx context.Context, after uint64, fn func(Event) error) error {
+	s.mu.Lock()
+	events, err := slices.Clone(s.events), s.err
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		if e.Sequence <= after {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sliceSource) Append(events ...Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+}
+
+// TestFollower tests that the follower applies new events as they are appended to the log
+func TestFollower(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		source := &sliceSource{events: putEvents(1, 3)}
+
+		var applied []Event
+		follower := NewFollower(source, 1, func(e Event) error {
+			applied = append(applied, e)
+			return nil
+		}, WithPollInterval(time.Second))
+		follower.Run()
+
+		synctest.Wait()
+		assert.Equal(t, putEvents(2, 3), applied, "events covered by the starting sequence should be skipped")
+		assert.Equal(t, uint64(3), follower.Sequence())
+
+		source.Append(putEvents(4, 5)...)
+		time.Sleep(time.Second)
+		synctest.Wait()
+		assert.Equal(t, putEvents(2, 5), applied)
+		assert.Equal(t, uint64(5), follower.Sequence())
+		assert.Zero(t, follower.Lag(), "a follower that has applied everything isn't lagging")
+
+		require.NoError(t, follower.Close())
+		require.NoError(t, follower.Close(), "closing twice should be a no-op")
+	})
+}
+
+// TestFollower_Lag tests that lag grows while the follower can't read the log and that errors stop it
+func TestFollower_Lag(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		source := &sliceSource{}
+		follower := NewFollower(source, 0, func(Event) error { return nil },
+			WithPollInterval(time.Second), WithMaxLag(time.Second))
+		follower.Run()
+		synctest.Wait()
+
+		time.Sleep(500 * time.Millisecond)
+		assert.Equal(t, 500*time.Millisecond, follower.Lag())
+		assert.False(t, follower.Lagging())
+
+		source.mu.Lock()
+		source.err = ErrCompacted
+		source.mu.Unlock()
+
+		time.Sleep(time.Second)
+		synctest.Wait()
+		assert.ErrorIs(t, <-follower.Err(), ErrCompacted)
+		assert.Equal(t, 1500*time.Millisecond, follower.Lag())
+		assert.True(t, follower.Lagging())
+
+		require.NoError(t, follower.Close())
+	})
+}
+
+// TestFollower_ApplyError tests that an event which can't be applied stops the follower without being counted
+func TestFollower_ApplyError(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		source := &sliceSource{events: putEvents(1, 3)}
+		follower := NewFollower(source, 0, func(e Event) error {
+			if e.Sequence == 2 {
+				return errors.New("store unavailable")
+			}
+			return nil
+		})
+		follower.Run()
+		synctest.Wait()
+
+		err := <-follower.Err()
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "failed to apply event 2")
+		assert.Equal(t, uint64(1), follower.Sequence())
+
+		require.NoError(t, follower.Close())
+	})
+}
+
+// TestFollower_CloseAbandonsPoll tests that closing the follower abandons the rest of a poll in progress, once the event
+// being applied is finished, without reporting it as a failure
+func TestFollower_CloseAbandonsPoll(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		source := &sliceSource{events: putEvents(1, 3)}
+		follower := NewFollower(source, 0, func(e Event) error {
+			if e.Sequence == 2 {
+				// the source checks for cancellation before the next event
+				time.Sleep(time.Hour)
+			}
+			return nil
+		})
+		follower.Run()
+		synctest.Wait()
+
+		require.NoError(t, follower.Close())
+		assert.Equal(t, uint64(2), follower.Sequence(), "the event being applied is finished")
+		select {
+		case err := <-follower.Err():
+			t.Fatalf("Expected no error from an abandoned poll, got %v", err)
+		default:
+		}
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package logger
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile is unsupported off unix, where we'd rather refuse to open a shared log than risk two writers.
+func lockFile(_ *os.File) error {
+	return errors.New("log locking is not supported on this platform")
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package logger
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes a non-blocking exclusive flock on f. The lock belongs to the open file, so it is held until f is
+// closed, including by the process exiting.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) //nolint:gosec // file descriptors fit in an int
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLogLocked
+	}
+	return err
+}
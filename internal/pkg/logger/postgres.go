@@ -1,21 +1,41 @@
 package logger
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"math"
+	"sync"
+	"time"
 
 	_ "github.com/lib/pq"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerName is the instrumentation scope of the logger's spans.
+const tracerName = "github.com/treyburn/lockbox/internal/pkg/logger"
+
 // compile time assertion that PostgresTransactionLogger is a TransactionManager
 var _ TransactionManager = (*PostgresTransactionLogger)(nil)
 
 type PostgresTransactionLogger struct {
-	events chan<- Event
-	errors <-chan error
-	done   chan struct{}
-	db     *sql.DB
+	events  chan<- pendingEvent
+	sending sendGuard
+	errors  <-chan error
+	done    chan struct{}
+	db      *sql.DB
+	durable bool
+
+	// written holds the sequence numbers of the rows this logger has inserted until Wrote reports them. It's locked
+	// for the whole of each INSERT, so that a row can't be read back before it has been recorded.
+	writtenMu sync.Mutex
+	written   map[uint64]struct{}
 }
 
 type PostgresDBParams struct {
@@ -26,65 +46,120 @@ type PostgresDBParams struct {
 	Database string
 }
 
-func NewPostgresTransactionLogger(conf PostgresDBParams) (*PostgresTransactionLogger, error) {
-	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", conf.Host, conf.Port, conf.User, conf.Password, conf.Database)
+type PostgresOption = func(*PostgresTransactionLogger)
 
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open db handle: %w", err)
+// WithDurablePostgresWrites makes WritePut and WriteDelete block until the event has been committed to the database,
+// returning any error encountered while doing so.
+func WithDurablePostgresWrites() PostgresOption {
+	return func(p *PostgresTransactionLogger) {
+		p.durable = true
 	}
+}
 
-	if err = db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to open db connection: %w", err)
+// NewPostgresTransactionLogger connects to the database and creates or migrates the transactions table, giving up if
+// ctx is done first.
+func NewPostgresTransactionLogger(ctx context.Context, conf PostgresDBParams, opts ...PostgresOption) (*PostgresTransactionLogger, error) {
+	db, err := openPostgres(ctx, conf)
+	if err != nil {
+		return nil, err
 	}
 
 	p := &PostgresTransactionLogger{db: db}
 
-	exists, err := p.verifyTableExists()
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	exists, err := p.verifyTableExists(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify table exists: %w", err)
 	}
 
 	if !exists {
-		if err = p.createTable(); err != nil {
+		if err = p.createTable(ctx); err != nil {
 			return nil, fmt.Errorf("failed to create table: %w", err)
 		}
+	} else if err = p.migrateTable(ctx); err != nil {
+		return nil, fmt.Errorf("failed to migrate table: %w", err)
 	}
 
 	return p, nil
 }
 
-func (p *PostgresTransactionLogger) WritePut(key, value string) {
-	p.events <- Event{Kind: EventPut, Key: key, Value: value}
+// openPostgres connects to the database, giving up if ctx is done first.
+func openPostgres(ctx context.Context, conf PostgresDBParams) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", conf.Host, conf.Port, conf.User, conf.Password, conf.Database)
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db handle: %w", err)
+	}
+
+	if err = db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to open db connection: %w", err)
+	}
+
+	return db, nil
 }
 
-func (p *PostgresTransactionLogger) WriteDelete(key string) {
-	p.events <- Event{Kind: EventDelete, Key: key}
+func (p *PostgresTransactionLogger) WritePut(ctx context.Context, key string, value []byte, opts ...WriteOption) (uint64, error) {
+	return submit(ctx, &p.sending, p.events, p.done, PutOp(key, value, opts...), p.durable)
+}
+
+func (p *PostgresTransactionLogger) WriteDelete(ctx context.Context, key string) (uint64, error) {
+	return submit(ctx, &p.sending, p.events, p.done, DeleteOp(key), p.durable)
+}
+
+func (p *PostgresTransactionLogger) WriteBatch(ctx context.Context, ops []Event) (uint64, error) {
+	e, err := batchEvent(ops)
+	if err != nil {
+		return 0, err
+	}
+	return submit(ctx, &p.sending, p.events, p.done, e, p.durable)
 }
 
 func (p *PostgresTransactionLogger) Err() <-chan error {
 	return p.errors
 }
 
+// Pending returns how many events are queued to be inserted. It must be called after Run.
+func (p *PostgresTransactionLogger) Pending() int {
+	return len(p.events)
+}
+
+// Ping checks that the database can still be reached.
+func (p *PostgresTransactionLogger) Ping(ctx context.Context) error {
+	if err := p.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to reach database: %w", err)
+	}
+	return nil
+}
+
 func (p *PostgresTransactionLogger) Run() {
-	events := make(chan Event, 16)
+	events := make(chan pendingEvent, 16)
 	p.events = events
 	errs := make(chan error, 1)
 	p.errors = errs
 	p.done = make(chan struct{})
+	p.written = make(map[uint64]struct{})
 
 	const insertQuery = `INSERT INTO transactions
-					(event_type, key, value)
-					VALUES ($1, $2, $3)`
+					(event_type, key, value, expires_at, content_type, metadata)
+					VALUES ($1, $2, $3, $4, $5, $6)
+					RETURNING sequence`
 
 	go func() {
 		defer close(p.done)
 		defer close(errs)
 		for e := range events {
-			_, err := p.db.Exec(insertQuery, e.Kind, e.Key, e.Value)
-			if err != nil {
+			seq, err := p.record(e.ctx, insertQuery, e.Event)
+			e.Sequence = seq
+			e.acknowledge(err)
+			// a write abandoned by its caller says nothing about the health of the log
+			if err != nil && e.ctx.Err() == nil {
 				select {
-				case errs <- fmt.Errorf("failed to write transaction: %w", err):
+				case errs <- err:
 				default:
 					slog.Warn("dropping transaction error, error channel full", slog.String("error", err.Error()))
 				}
@@ -93,7 +168,87 @@ func (p *PostgresTransactionLogger) Run() {
 	}()
 }
 
-func (p *PostgresTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
+// record inserts e, recording the sequence number of its row as one this logger wrote.
+func (p *PostgresTransactionLogger) record(ctx context.Context, query string, e Event) (uint64, error) {
+	p.writtenMu.Lock()
+	defer p.writtenMu.Unlock()
+
+	seq, err := p.insert(ctx, query, e)
+	if err == nil {
+		p.written[seq] = struct{}{}
+	}
+	return seq, err
+}
+
+// Wrote reports whether the row with the given sequence number was inserted by this logger, rather than by another
+// replica writing to the same table. Each row is only reported once, so that those a tailer has read don't pile up.
+func (p *PostgresTransactionLogger) Wrote(seq uint64) bool {
+	p.writtenMu.Lock()
+	defer p.writtenMu.Unlock()
+
+	_, ok := p.written[seq]
+	delete(p.written, seq)
+	return ok
+}
+
+// insert runs query to insert e, in a span belonging to the write which queued it, and returns the sequence number the
+// row was given. A durable write which is cancelled before its INSERT completes is abandoned, leaving the writer to
+// report the context's error.
+func (p *PostgresTransactionLogger) insert(ctx context.Context, query string, e Event) (uint64, error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "INSERT transactions",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName("INSERT"),
+			semconv.DBCollectionName("transactions"),
+			semconv.DBQueryText(query),
+		),
+	)
+	defer span.End()
+
+	// a batch takes a single row, and so a single sequence number, with its ops in the value column
+	value := e.Value
+	if e.Kind == EventBatch {
+		ops, err := json.Marshal(batchRows(e.Batch))
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode batch: %w", err)
+		}
+		value = ops
+	}
+
+	metadata, err := metadataColumn(e.Metadata)
+	if err != nil {
+		return 0, err
+	}
+
+	expires := sql.NullTime{Time: e.Expires, Valid: !e.Expires.IsZero()}
+	contentType := sql.NullString{String: e.ContentType, Valid: e.ContentType != ""}
+	var seq uint64
+	row := p.db.QueryRowContext(ctx, query, e.Kind, e.Key, value, expires, contentType, metadata)
+	if err = row.Scan(&seq); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// the driver reports a cancelled query in its own words, which callers can't check for
+			return 0, fmt.Errorf("failed to write transaction: %w: %w", ctxErr, err)
+		}
+		return 0, fmt.Errorf("failed to write transaction: %w", err)
+	}
+	return seq, nil
+}
+
+func (p *PostgresTransactionLogger) ReadEvents(ctx context.Context, opts ...ReadOption) (<-chan Event, <-chan error) {
+	return readTransactions(ctx, p.db, opts...)
+}
+
+// readTransactions reads the events in the transactions table of db as ReadEvents does.
+func readTransactions(ctx context.Context, db *sql.DB, opts ...ReadOption) (<-chan Event, <-chan error) {
+	r := newReadRange(opts...)
+	through := uint64(math.MaxInt64) // the sequence column is a BIGINT
+	if r.through != 0 {
+		through = min(r.through, through)
+	}
+
 	outEvent := make(chan Event)
 	outErr := make(chan error, 1)
 
@@ -101,10 +256,11 @@ func (p *PostgresTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
 		defer close(outEvent)
 		defer close(outErr)
 
-		const query = `SELECT sequence, event_type, key, value FROM transactions
+		const query = `SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions
+						WHERE sequence > $1 AND sequence <= $2
 						ORDER BY sequence`
 
-		rows, err := p.db.Query(query)
+		rows, err := db.QueryContext(ctx, query, r.after, through)
 		if err != nil {
 			outErr <- fmt.Errorf("failed to read transactions: %w", err)
 			return
@@ -115,14 +271,16 @@ func (p *PostgresTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
 				slog.Warn("failed to close db row", slog.String("error", closeErr.Error()))
 			}
 		}()
-		e := Event{}
 		for rows.Next() {
-			err = rows.Scan(&e.Sequence, &e.Kind, &e.Key, &e.Value)
+			e, err := scanEvent(rows)
 			if err != nil {
-				outErr <- fmt.Errorf("failed to read row: %w", err)
+				outErr <- err
+				return
+			}
+			if err = send(ctx, outEvent, e); err != nil {
+				outErr <- err
 				return
 			}
-			outEvent <- e
 		}
 
 		err = rows.Err()
@@ -134,12 +292,250 @@ func (p *PostgresTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
 	return outEvent, outErr
 }
 
-func (p *PostgresTransactionLogger) verifyTableExists() (bool, error) {
+// compile time assertion that PostgresReader is an EventReader
+var _ EventReader = (*PostgresReader)(nil)
+
+// NewPostgresReader connects to the database to read the transactions table, giving up if ctx is done first. Unlike
+// NewPostgresTransactionLogger it neither creates nor migrates the table, so it leaves the log of a running service
+// exactly as it was, but it can't read a table which a writer of this version hasn't migrated yet.
+func NewPostgresReader(ctx context.Context, conf PostgresDBParams) (*PostgresReader, error) {
+	db, err := openPostgres(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresReader{db: db}, nil
+}
+
+// PostgresReader reads the transactions table without writing to it.
+type PostgresReader struct {
+	db *sql.DB
+}
+
+func (r *PostgresReader) ReadEvents(ctx context.Context, opts ...ReadOption) (<-chan Event, <-chan error) {
+	return readTransactions(ctx, r.db, opts...)
+}
+
+// Close closes the database handle.
+func (r *PostgresReader) Close() error {
+	return r.db.Close()
+}
+
+// LastSequence returns the last sequence number allocated to the transactions table. Unlike MAX(sequence) it never goes
+// backwards once old events have been compacted away.
+//
+// Like Compact it takes no context, as TransactionManager is snapshotted and compacted in the background, where there
+// is no request to give up with. The service only snapshots a file log, so neither is called on a Postgres log while
+// serving.
+func (p *PostgresTransactionLogger) LastSequence() (uint64, error) {
+	const query = `SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM transactions_sequence_seq`
+
+	var seq uint64
+	if err := p.db.QueryRow(query).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to read last sequence: %w", err)
+	}
+
+	return seq, nil
+}
+
+// Compact deletes every event at or below through.
+func (p *PostgresTransactionLogger) Compact(through uint64) error {
+	const deleteQuery = `DELETE FROM transactions WHERE sequence <= $1`
+
+	result, err := p.db.Exec(deleteQuery, through)
+	if err != nil {
+		return fmt.Errorf("failed to compact transactions: %w", err)
+	}
+
+	if deleted, err := result.RowsAffected(); err == nil {
+		slog.Info("compacted transaction log", slog.Uint64("through", through), slog.Int64("deleted", deleted))
+	}
+
+	return nil
+}
+
+// Tail returns an EventSource which polls the transactions table for rows written by any replica.
+func (p *PostgresTransactionLogger) Tail() *PostgresTailer {
+	return &PostgresTailer{db: p.db, gapTimeout: defaultGapTimeout}
+}
+
+// compile time assertion that PostgresTailer is an EventSource
+var _ EventSource = (*PostgresTailer)(nil)
+
+// defaultGapTimeout is how long a PostgresTailer waits for a missing sequence number to be committed before giving up
+// on it.
+const defaultGapTimeout = 5 * time.Second
+
+// PostgresTailer reads the transactions table as it is written to.
+//
+// Sequence numbers are allocated when a row is inserted rather than when it is committed, so concurrent writers can
+// commit out of order and briefly leave a gap. The tailer stops at a gap until it is filled, but a transaction which
+// rolled back leaves a gap which never fills, so after a while the tailer skips it.
+type PostgresTailer struct {
+	db         *sql.DB
+	gapTimeout time.Duration
+	// gapAt is the first missing sequence number of the gap we're waiting on, which we started waiting on at gapSince
+	gapAt    uint64
+	gapSince time.Time
+}
+
+func (t *PostgresTailer) Poll(ctx context.Context, after uint64, fn func(Event) error) error {
+	const query = `SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions
+					WHERE sequence > $1
+					ORDER BY sequence`
+
+	rows, err := t.db.QueryContext(ctx, query, after)
+	if err != nil {
+		return fmt.Errorf("failed to read transactions: %w", err)
+	}
+	defer func() {
+		closeErr := rows.Close()
+		if closeErr != nil {
+			slog.Warn("failed to close db row", slog.String("error", closeErr.Error()))
+		}
+	}()
+
+	next := after + 1
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+
+		if e.Sequence != next && !t.skipGap(next, e.Sequence) {
+			return nil
+		}
+
+		if err = fn(e); err != nil {
+			return err
+		}
+		next = e.Sequence + 1
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to read rows: %w", err)
+	}
+
+	return nil
+}
+
+// skipGap reports whether the tailer has waited long enough for the sequence numbers [from, to) to be committed.
+func (t *PostgresTailer) skipGap(from, to uint64) bool {
+	if t.gapAt != from || t.gapSince.IsZero() {
+		t.gapAt, t.gapSince = from, time.Now()
+	}
+
+	if time.Since(t.gapSince) < t.gapTimeout {
+		return false
+	}
+
+	slog.Warn("skipping gap in transaction sequence", slog.Uint64("from", from), slog.Uint64("to", to-1))
+	t.gapSince = time.Time{}
+	return true
+}
+
+// scanEvent reads the event in the current row of a query for sequence, event_type, key, value, written_at,
+// expires_at, content_type and metadata.
+func scanEvent(rows *sql.Rows) (Event, error) {
+	var e Event
+	var writtenAt, expiresAt sql.NullTime
+	var contentType, metadata sql.NullString
+	err := rows.Scan(&e.Sequence, &e.Kind, &e.Key, &e.Value, &writtenAt, &expiresAt, &contentType, &metadata)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to read row: %w", err)
+	}
+	// rows written before the column was added have no timestamp
+	if writtenAt.Valid {
+		e.Timestamp = writtenAt.Time.UTC()
+	}
+	if expiresAt.Valid {
+		e.Expires = expiresAt.Time.UTC()
+	}
+	e.ContentType = contentType.String
+	if metadata.Valid {
+		if err := json.Unmarshal([]byte(metadata.String), &e.Metadata); err != nil {
+			return Event{}, fmt.Errorf("failed to decode metadata at sequence %d: %w", e.Sequence, err)
+		}
+	}
+	// deletes have no value, which older rows hold as an empty string rather than NULL
+	if len(e.Value) == 0 {
+		e.Value = nil
+	}
+
+	if e.Kind == EventBatch {
+		var ops []batchRow
+		if err := json.Unmarshal(e.Value, &ops); err != nil {
+			return Event{}, fmt.Errorf("failed to decode batch at sequence %d: %w", e.Sequence, err)
+		}
+		e.Value, e.Batch = nil, batchEvents(ops)
+	}
+	return e, nil
+}
+
+// metadataColumn returns metadata as the JSON object held in the metadata column, which is NULL when there is none.
+func metadataColumn(metadata map[string]string) (sql.NullString, error) {
+	if len(metadata) == 0 {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// batchRow is an op of a batch as it is stored in the value column of the batch's row, which is JSON rather than the
+// binary encoding of the file log as the column was TEXT when batches were introduced. Data holds the value in base64;
+// ops written while the column was TEXT hold it as a string in Text instead.
+type batchRow struct {
+	Kind        EventKind         `json:"kind"`
+	Key         string            `json:"key"`
+	Data        []byte            `json:"data,omitempty"`
+	Text        string            `json:"value,omitempty"`
+	Expires     time.Time         `json:"expires,omitzero"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func batchRows(ops []Event) []batchRow {
+	rows := make([]batchRow, 0, len(ops))
+	for _, op := range ops {
+		rows = append(rows, batchRow{
+			Kind:        op.Kind,
+			Key:         op.Key,
+			Data:        op.Value,
+			Expires:     op.Expires,
+			ContentType: op.ContentType,
+			Metadata:    op.Metadata,
+		})
+	}
+	return rows
+}
+
+func batchEvents(rows []batchRow) []Event {
+	ops := make([]Event, 0, len(rows))
+	for _, row := range rows {
+		value := row.Data
+		if row.Text != "" {
+			value = []byte(row.Text)
+		}
+		ops = append(ops, Event{
+			Kind:        row.Kind,
+			Key:         row.Key,
+			Value:       value,
+			Expires:     row.Expires.UTC(),
+			ContentType: row.ContentType,
+			Metadata:    row.Metadata,
+		})
+	}
+	return ops
+}
+
+func (p *PostgresTransactionLogger) verifyTableExists(ctx context.Context) (bool, error) {
 	const table = "transactions"
 
 	var result sql.NullString
 
-	rows, err := p.db.Query(fmt.Sprintf("SELECT to_regclass('public.%s');", table))
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf("SELECT to_regclass('public.%s');", table))
 	defer func() {
 		if rows != nil {
 			if closeErr := rows.Close(); closeErr != nil {
@@ -161,15 +557,19 @@ func (p *PostgresTransactionLogger) verifyTableExists() (bool, error) {
 	return result.String == table, rows.Err()
 }
 
-func (p *PostgresTransactionLogger) createTable() error {
+func (p *PostgresTransactionLogger) createTable(ctx context.Context) error {
 	const createQuery = `CREATE TABLE transactions (
 		sequence      BIGSERIAL PRIMARY KEY,
 		event_type    SMALLINT,
 		key 		  TEXT,
-		value         TEXT
+		value         BYTEA,
+		written_at    TIMESTAMPTZ DEFAULT now(),
+		expires_at    TIMESTAMPTZ,
+		content_type  TEXT,
+		metadata      JSONB
 	  );`
 
-	_, err := p.db.Exec(createQuery)
+	_, err := p.db.ExecContext(ctx, createQuery)
 	if err != nil {
 		return fmt.Errorf("failed to create table: %w", err)
 	}
@@ -177,8 +577,38 @@ func (p *PostgresTransactionLogger) createTable() error {
 	return nil
 }
 
+// migrateTable brings a transactions table created by an older version up to date. Existing rows are left without a
+// timestamp, content type or metadata, and never expire. A value column of TEXT, which can't hold arbitrary bytes,
+// becomes BYTEA holding the UTF-8 encoding of each value, which is what was put.
+func (p *PostgresTransactionLogger) migrateTable(ctx context.Context) error {
+	const migrateQuery = `ALTER TABLE transactions ADD COLUMN IF NOT EXISTS written_at TIMESTAMPTZ;
+		ALTER TABLE transactions ALTER COLUMN written_at SET DEFAULT now();
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS content_type TEXT;
+		ALTER TABLE transactions ADD COLUMN IF NOT EXISTS metadata JSONB;
+		DO $$
+		BEGIN
+			IF (SELECT data_type FROM information_schema.columns
+				WHERE table_name = 'transactions' AND column_name = 'value') = 'text' THEN
+				ALTER TABLE transactions ALTER COLUMN value TYPE BYTEA USING convert_to(value, 'UTF8');
+			END IF;
+		END $$;`
+
+	_, err := p.db.ExecContext(ctx, migrateQuery)
+	if err != nil {
+		return fmt.Errorf("failed to add missing columns: %w", err)
+	}
+
+	return nil
+}
+
+// Close stops the logger, once every event already written has been committed, and closes the database handle. It may
+// be called on a logger which was only used to read the log, without Run. Writes made once it has begun fail with
+// ErrLogStopped.
 func (p *PostgresTransactionLogger) Close() error {
-	close(p.events)
-	<-p.done // wait for goroutine to drain remaining events
+	if p.events != nil {
+		p.sending.closeEvents(p.events)
+		<-p.done // wait for goroutine to drain remaining events
+	}
 	return p.db.Close()
 }
@@ -1,8 +1,11 @@
 package logger
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
 	"testing"
 	"testing/synctest"
 	"time"
@@ -10,6 +13,12 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // TestNewPostgresTransactionLogger tests the constructor
@@ -28,7 +37,7 @@ func TestNewPostgresTransactionLogger(t *testing.T) {
 	logger := &PostgresTransactionLogger{db: db}
 
 	// Verify table exists returns true
-	exists, err := logger.verifyTableExists()
+	exists, err := logger.verifyTableExists(t.Context())
 	require.NoError(t, err)
 	assert.True(t, exists)
 
@@ -49,7 +58,7 @@ func TestNewPostgresTransactionLogger_TableNotExists(t *testing.T) {
 	logger := &PostgresTransactionLogger{db: db}
 
 	// Verify table doesn't exist (NULL result from to_regclass)
-	exists, err := logger.verifyTableExists()
+	exists, err := logger.verifyTableExists(t.Context())
 	require.NoError(t, err)
 	assert.False(t, exists)
 
@@ -57,7 +66,7 @@ func TestNewPostgresTransactionLogger_TableNotExists(t *testing.T) {
 	mock.ExpectExec(`CREATE TABLE transactions`).WillReturnResult(sqlmock.NewResult(0, 0))
 
 	// Create table
-	err = logger.createTable()
+	err = logger.createTable(t.Context())
 	require.NoError(t, err)
 
 	err = mock.ExpectationsWereMet()
@@ -98,13 +107,14 @@ func TestPostgresTransactionLogger_WritePut(t *testing.T) {
 	require.NoError(t, err)
 	defer dbCleanup(t, db, mock)
 
-	// Expect two INSERT queries
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventPut, "key1", "value1").
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventPut, "key2", "value2").
-		WillReturnResult(sqlmock.NewResult(2, 1))
+	// Expect two INSERT queries, the second with an expiry
+	expires := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", []byte("value1"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key2", []byte("value2"), sql.NullTime{Time: expires, Valid: true}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(2))
 	mock.ExpectClose()
 
 	logger := &PostgresTransactionLogger{db: db}
@@ -113,8 +123,8 @@ func TestPostgresTransactionLogger_WritePut(t *testing.T) {
 	// Give goroutine time to start
 	time.Sleep(10 * time.Millisecond)
 
-	logger.WritePut("key1", "value1")
-	logger.WritePut("key2", "value2")
+	assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+	assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key2", []byte("value2"), ExpiresAt(expires))))
 
 	// Give time for writes to complete
 	time.Sleep(50 * time.Millisecond)
@@ -129,6 +139,129 @@ func TestPostgresTransactionLogger_WritePut(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// TestPostgresTransactionLogger_WriteBatch tests that a batch is written as a single row with its ops in the value
+// column, and read back from it
+func TestPostgresTransactionLogger_WriteBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer dbCleanup(t, db, mock)
+
+	expires := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	ops := []Event{PutOp("key1", []byte("value1"), ExpiresAt(expires)), DeleteOp("key2")}
+	value := []byte(`[{"kind":2,"key":"key1","data":"dmFsdWUx","expires":"2024-05-06T07:08:09Z"},{"kind":1,"key":"key2"}]`)
+
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventBatch, "", value, sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(1))
+	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value", "written_at", "expires_at", "content_type", "metadata"}).
+		AddRow(1, EventBatch, "", value, nil, nil, nil, nil)
+	mock.ExpectQuery(`SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions`).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	logger := &PostgresTransactionLogger{db: db, durable: true}
+	logger.Run()
+	require.NoError(t, writeErr(logger.WriteBatch(t.Context(), ops)))
+
+	eventChan, errChan := logger.ReadEvents(t.Context())
+	var events []Event
+	for e := range eventChan {
+		events = append(events, e)
+	}
+	require.NoError(t, <-errChan)
+	assert.Equal(t, []Event{{Sequence: 1, Kind: EventBatch, Batch: ops}}, events)
+
+	require.NoError(t, logger.Close())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestPostgresTransactionLogger_BinaryValues tests that values which aren't valid text are written and read back as they
+// were put, and that batches written while the value column was TEXT are still read
+func TestPostgresTransactionLogger_BinaryValues(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer dbCleanup(t, db, mock)
+
+	value := []byte{0x00, 0xff, 0xfe, '\t', '\n'}
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", value, sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(1))
+	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value", "written_at", "expires_at", "content_type", "metadata"}).
+		AddRow(1, EventPut, "key1", value, nil, nil, nil, nil).
+		AddRow(2, EventBatch, "", []byte(`[{"kind":2,"key":"key2","value":"text"},{"kind":2,"key":"key3","data":"AP8="}]`), nil, nil, nil, nil)
+	mock.ExpectQuery(`SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions`).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	logger := &PostgresTransactionLogger{db: db, durable: true}
+	logger.Run()
+	require.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", value)))
+
+	eventChan, errChan := logger.ReadEvents(t.Context())
+	var events []Event
+	for e := range eventChan {
+		events = append(events, e)
+	}
+	require.NoError(t, <-errChan)
+	assert.Equal(t, []Event{
+		{Sequence: 1, Kind: EventPut, Key: "key1", Value: value},
+		{Sequence: 2, Kind: EventBatch, Batch: []Event{
+			{Kind: EventPut, Key: "key2", Value: []byte("text")},
+			{Kind: EventPut, Key: "key3", Value: []byte{0x00, 0xff}},
+		}},
+	}, events)
+
+	require.NoError(t, logger.Close())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestPostgresTransactionLogger_Metadata tests that the content type and metadata of a put, and of the ops of a batch,
+// are written and read back
+func TestPostgresTransactionLogger_Metadata(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer dbCleanup(t, db, mock)
+
+	batch := []byte(`[{"kind":2,"key":"key2","data":"eA==","content_type":"text/plain","metadata":{"Owner":"bob"}}]`)
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", []byte("{}"), sql.NullTime{},
+			sql.NullString{String: "application/json", Valid: true}, sql.NullString{String: `{"Owner":"alice"}`, Valid: true}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventBatch, "", batch, sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(2))
+	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value", "written_at", "expires_at", "content_type", "metadata"}).
+		AddRow(1, EventPut, "key1", []byte("{}"), nil, nil, "application/json", `{"Owner":"alice"}`).
+		AddRow(2, EventBatch, "", batch, nil, nil, nil, nil)
+	mock.ExpectQuery(`SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions`).WillReturnRows(rows)
+	mock.ExpectClose()
+
+	logger := &PostgresTransactionLogger{db: db, durable: true}
+	logger.Run()
+	require.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("{}"),
+		WithContentType("application/json"), WithMetadata(map[string]string{"Owner": "alice"}))))
+	require.NoError(t, writeErr(logger.WriteBatch(t.Context(), []Event{
+		PutOp("key2", []byte("x"), WithContentType("text/plain"), WithMetadata(map[string]string{"Owner": "bob"})),
+	})))
+
+	eventChan, errChan := logger.ReadEvents(t.Context())
+	var events []Event
+	for e := range eventChan {
+		events = append(events, e)
+	}
+	require.NoError(t, <-errChan)
+	assert.Equal(t, []Event{
+		{
+			Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("{}"),
+			ContentType: "application/json", Metadata: map[string]string{"Owner": "alice"},
+		},
+		{Sequence: 2, Kind: EventBatch, Batch: []Event{
+			{Kind: EventPut, Key: "key2", Value: []byte("x"), ContentType: "text/plain", Metadata: map[string]string{"Owner": "bob"}},
+		}},
+	}, events)
+
+	require.NoError(t, logger.Close())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 // TestPostgresTransactionLogger_WriteDelete tests writing DELETE events
 func TestPostgresTransactionLogger_WriteDelete(t *testing.T) {
 	db, mock, err := sqlmock.New()
@@ -136,12 +269,12 @@ func TestPostgresTransactionLogger_WriteDelete(t *testing.T) {
 	defer dbCleanup(t, db, mock)
 
 	// Expect two INSERT queries for delete events
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventDelete, "key1", "").
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventDelete, "key2", "").
-		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventDelete, "key1", []byte(nil), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventDelete, "key2", []byte(nil), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(2))
 	mock.ExpectClose()
 
 	logger := &PostgresTransactionLogger{db: db}
@@ -150,8 +283,8 @@ func TestPostgresTransactionLogger_WriteDelete(t *testing.T) {
 	// Give goroutine time to start
 	time.Sleep(10 * time.Millisecond)
 
-	logger.WriteDelete("key1")
-	logger.WriteDelete("key2")
+	assert.NoError(t, writeErr(logger.WriteDelete(t.Context(), "key1")))
+	assert.NoError(t, writeErr(logger.WriteDelete(t.Context(), "key2")))
 
 	// Give time for writes to complete
 	time.Sleep(50 * time.Millisecond)
@@ -173,15 +306,15 @@ func TestPostgresTransactionLogger_MixedOperations(t *testing.T) {
 	defer dbCleanup(t, db, mock)
 
 	// Expect mixed INSERT queries
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventPut, "key1", "value1").
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventDelete, "key2", "").
-		WillReturnResult(sqlmock.NewResult(2, 1))
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventPut, "key3", "value3").
-		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", []byte("value1"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventDelete, "key2", []byte(nil), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(2))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key3", []byte("value3"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(3))
 	mock.ExpectClose()
 
 	logger := &PostgresTransactionLogger{db: db}
@@ -190,9 +323,9 @@ func TestPostgresTransactionLogger_MixedOperations(t *testing.T) {
 	// Give goroutine time to start
 	time.Sleep(10 * time.Millisecond)
 
-	logger.WritePut("key1", "value1")
-	logger.WriteDelete("key2")
-	logger.WritePut("key3", "value3")
+	assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+	assert.NoError(t, writeErr(logger.WriteDelete(t.Context(), "key2")))
+	assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key3", []byte("value3"))))
 
 	// Give time for writes to complete
 	time.Sleep(50 * time.Millisecond)
@@ -235,12 +368,12 @@ func TestPostgresTransactionLogger_ReadEvents_EmptyTable(t *testing.T) {
 	defer dbCleanup(t, db, mock)
 
 	// Return empty rows
-	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value"})
-	mock.ExpectQuery(`SELECT sequence, event_type, key, value FROM transactions`).WillReturnRows(rows)
+	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value", "written_at", "expires_at", "content_type", "metadata"})
+	mock.ExpectQuery(`SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions`).WillReturnRows(rows)
 
 	logger := &PostgresTransactionLogger{db: db}
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	// Collect all events
 	var events []Event
@@ -282,16 +415,18 @@ func TestPostgresTransactionLogger_ReadEvents_ValidData(t *testing.T) {
 	require.NoError(t, err)
 	defer dbCleanup(t, db, mock)
 
-	// Return valid rows
-	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value"}).
-		AddRow(1, EventPut, "key1", "value1").
-		AddRow(2, EventDelete, "key2", "").
-		AddRow(3, EventPut, "key3", "value3")
-	mock.ExpectQuery(`SELECT sequence, event_type, key, value FROM transactions`).WillReturnRows(rows)
+	// Return valid rows, the first written before timestamps were recorded and the last with an expiry
+	writtenAt := time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("EST", -5*60*60))
+	expiresAt := writtenAt.Add(time.Hour)
+	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value", "written_at", "expires_at", "content_type", "metadata"}).
+		AddRow(1, EventPut, "key1", "value1", nil, nil, nil, nil).
+		AddRow(2, EventDelete, "key2", "", writtenAt, nil, nil, nil).
+		AddRow(3, EventPut, "key3", "value3", writtenAt, expiresAt, nil, nil)
+	mock.ExpectQuery(`SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions`).WillReturnRows(rows)
 
 	logger := &PostgresTransactionLogger{db: db}
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	// Collect all events
 	var events []Event
@@ -322,9 +457,9 @@ func TestPostgresTransactionLogger_ReadEvents_ValidData(t *testing.T) {
 	}
 
 	expectedEvents := []Event{
-		{Sequence: 1, Kind: EventPut, Key: "key1", Value: "value1"},
-		{Sequence: 2, Kind: EventDelete, Key: "key2", Value: ""},
-		{Sequence: 3, Kind: EventPut, Key: "key3", Value: "value3"},
+		{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
+		{Sequence: 2, Kind: EventDelete, Key: "key2", Value: nil, Timestamp: writtenAt.UTC()},
+		{Sequence: 3, Kind: EventPut, Key: "key3", Value: []byte("value3"), Timestamp: writtenAt.UTC(), Expires: expiresAt.UTC()},
 	}
 
 	require.Len(t, events, len(expectedEvents))
@@ -334,6 +469,7 @@ func TestPostgresTransactionLogger_ReadEvents_ValidData(t *testing.T) {
 		assert.Equal(t, expected.Kind, events[i].Kind, "Event %d: kind mismatch", i)
 		assert.Equal(t, expected.Key, events[i].Key, "Event %d: key mismatch", i)
 		assert.Equal(t, expected.Value, events[i].Value, "Event %d: value mismatch", i)
+		assert.Equal(t, expected.Timestamp, events[i].Timestamp, "Event %d: timestamp mismatch", i)
 	}
 
 	err = mock.ExpectationsWereMet()
@@ -347,12 +483,12 @@ func TestPostgresTransactionLogger_ReadEvents_QueryError(t *testing.T) {
 	defer dbCleanup(t, db, mock)
 
 	// Return query error
-	mock.ExpectQuery(`SELECT sequence, event_type, key, value FROM transactions`).
+	mock.ExpectQuery(`SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions`).
 		WillReturnError(fmt.Errorf("database connection lost"))
 
 	logger := &PostgresTransactionLogger{db: db}
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	// Collect all events
 	var events []Event
@@ -392,13 +528,13 @@ func TestPostgresTransactionLogger_ReadEvents_ScanError(t *testing.T) {
 	defer dbCleanup(t, db, mock)
 
 	// Return rows with invalid data type for sequence (string instead of int)
-	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value"}).
-		AddRow("invalid", EventPut, "key1", "value1")
-	mock.ExpectQuery(`SELECT sequence, event_type, key, value FROM transactions`).WillReturnRows(rows)
+	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value", "written_at", "expires_at", "content_type", "metadata"}).
+		AddRow("invalid", EventPut, "key1", "value1", nil, nil, nil, nil)
+	mock.ExpectQuery(`SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions`).WillReturnRows(rows)
 
 	logger := &PostgresTransactionLogger{db: db}
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	// Collect all events
 	var events []Event
@@ -443,13 +579,13 @@ func TestPostgresTransactionLogger_ReadEvents_ScanError_NoCorruptEvents(t *testi
 	defer dbCleanup(t, db, mock)
 
 	// Return rows with invalid data type for sequence (string instead of int)
-	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value"}).
-		AddRow("invalid", EventPut, "key1", "value1")
-	mock.ExpectQuery(`SELECT sequence, event_type, key, value FROM transactions`).WillReturnRows(rows)
+	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value", "written_at", "expires_at", "content_type", "metadata"}).
+		AddRow("invalid", EventPut, "key1", "value1", nil, nil, nil, nil)
+	mock.ExpectQuery(`SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions`).WillReturnRows(rows)
 
 	logger := &PostgresTransactionLogger{db: db}
 
-	eventChan, errChan := logger.ReadEvents()
+	eventChan, errChan := logger.ReadEvents(t.Context())
 
 	var events []Event
 	done := make(chan bool)
@@ -488,8 +624,8 @@ func TestPostgresTransactionLogger_WriteError(t *testing.T) {
 	defer dbCleanup(t, db, mock)
 
 	// Return error on INSERT
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventPut, "key1", "value1").
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", []byte("value1"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
 		WillReturnError(fmt.Errorf("simulated write error"))
 
 	logger := &PostgresTransactionLogger{db: db}
@@ -498,7 +634,7 @@ func TestPostgresTransactionLogger_WriteError(t *testing.T) {
 	// Give goroutine time to start
 	time.Sleep(10 * time.Millisecond)
 
-	logger.WritePut("key1", "value1")
+	assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
 
 	// Wait for error to be sent
 	select {
@@ -513,6 +649,65 @@ func TestPostgresTransactionLogger_WriteError(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// TestPostgresTransactionLogger_DurableWriteCancelled tests that a durable write gives up on its INSERT once its
+// context is done, without reporting the log as failed
+func TestPostgresTransactionLogger_DurableWriteCancelled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer dbCleanup(t, db, mock)
+
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", []byte("value1"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(1))
+
+	logger := &PostgresTransactionLogger{db: db, durable: true}
+	logger.Run()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, writeErr(logger.WritePut(ctx, "key1", []byte("value1"))), context.DeadlineExceeded)
+
+	select {
+	case err := <-logger.Err():
+		t.Fatalf("Expected no error from an abandoned write, got %v", err)
+	default:
+	}
+}
+
+// TestPostgresTransactionLogger_TracesInserts tests that each INSERT is traced, recording its failure
+func TestPostgresTransactionLogger_TracesInserts(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer dbCleanup(t, db, mock)
+
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", []byte("value1"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventDelete, "key1", []byte(nil), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnError(errors.New("simulated write error"))
+
+	logger := &PostgresTransactionLogger{db: db, durable: true}
+	logger.Run()
+
+	require.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+	require.Error(t, writeErr(logger.WriteDelete(t.Context(), "key1")))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.Equal(t, "INSERT transactions", span.Name())
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+		assert.Contains(t, span.Attributes(), semconv.DBSystemNamePostgreSQL)
+	}
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
 // TestPostgresTransactionLogger_SequenceIncrement tests that multiple writes work correctly
 func TestPostgresTransactionLogger_SequenceIncrement(t *testing.T) {
 	db, mock, err := sqlmock.New()
@@ -521,9 +716,9 @@ func TestPostgresTransactionLogger_SequenceIncrement(t *testing.T) {
 
 	// Expect 10 INSERT queries
 	for i := 1; i <= 10; i++ {
-		mock.ExpectExec(`INSERT INTO transactions`).
-			WithArgs(EventPut, fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)).
-			WillReturnResult(sqlmock.NewResult(int64(i), 1))
+		mock.ExpectQuery(`INSERT INTO transactions`).
+			WithArgs(EventPut, fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+			WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(int64(i)))
 	}
 	mock.ExpectClose()
 
@@ -535,7 +730,7 @@ func TestPostgresTransactionLogger_SequenceIncrement(t *testing.T) {
 
 	// Write multiple events
 	for i := 1; i <= 10; i++ {
-		logger.WritePut(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+		assert.NoError(t, writeErr(logger.WritePut(t.Context(), fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)))))
 	}
 
 	// Give time for writes to complete
@@ -563,7 +758,7 @@ func TestPostgresTransactionLogger_verifyTableExists(t *testing.T) {
 
 		logger := &PostgresTransactionLogger{db: db}
 
-		exists, err := logger.verifyTableExists()
+		exists, err := logger.verifyTableExists(t.Context())
 		require.NoError(t, err)
 		assert.True(t, exists)
 
@@ -582,7 +777,7 @@ func TestPostgresTransactionLogger_verifyTableExists(t *testing.T) {
 
 		logger := &PostgresTransactionLogger{db: db}
 
-		exists, err := logger.verifyTableExists()
+		exists, err := logger.verifyTableExists(t.Context())
 		require.NoError(t, err)
 		assert.False(t, exists)
 
@@ -601,7 +796,7 @@ func TestPostgresTransactionLogger_verifyTableExists(t *testing.T) {
 
 		logger := &PostgresTransactionLogger{db: db}
 
-		exists, err := logger.verifyTableExists()
+		exists, err := logger.verifyTableExists(t.Context())
 		require.NoError(t, err)
 		assert.False(t, exists)
 
@@ -620,7 +815,7 @@ func TestPostgresTransactionLogger_verifyTableExists_Error(t *testing.T) {
 
 	logger := &PostgresTransactionLogger{db: db}
 
-	_, err = logger.verifyTableExists()
+	_, err = logger.verifyTableExists(t.Context())
 	assert.Error(t, err)
 
 	err = mock.ExpectationsWereMet()
@@ -637,7 +832,26 @@ func TestPostgresTransactionLogger_createTable(t *testing.T) {
 
 	logger := &PostgresTransactionLogger{db: db}
 
-	err = logger.createTable()
+	err = logger.createTable(t.Context())
+	require.NoError(t, err)
+
+	err = mock.ExpectationsWereMet()
+	assert.NoError(t, err)
+}
+
+// TestPostgresTransactionLogger_migrateTable tests that an existing table gains the written_at, expires_at,
+// content_type and metadata columns, and has its value column turned into BYTEA
+func TestPostgresTransactionLogger_migrateTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer dbCleanup(t, db, mock)
+
+	mock.ExpectExec(`(?s)ALTER TABLE transactions ADD COLUMN IF NOT EXISTS written_at.*ADD COLUMN IF NOT EXISTS metadata JSONB.*ALTER COLUMN value TYPE BYTEA`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	logger := &PostgresTransactionLogger{db: db}
+
+	err = logger.migrateTable(t.Context())
 	require.NoError(t, err)
 
 	err = mock.ExpectationsWereMet()
@@ -654,7 +868,7 @@ func TestPostgresTransactionLogger_createTable_Error(t *testing.T) {
 
 	logger := &PostgresTransactionLogger{db: db}
 
-	err = logger.createTable()
+	err = logger.createTable(t.Context())
 	assert.Error(t, err)
 	assert.ErrorContains(t, err, "failed to create table")
 
@@ -669,9 +883,9 @@ func TestPostgresTransactionLogger_Close(t *testing.T) {
 	defer dbCleanup(t, db, mock)
 
 	// Set up expectations for write
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventPut, "key1", "value1").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", []byte("value1"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(1))
 	mock.ExpectClose()
 
 	logger := &PostgresTransactionLogger{db: db}
@@ -681,7 +895,7 @@ func TestPostgresTransactionLogger_Close(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 
 	// Write an event
-	logger.WritePut("key1", "value1")
+	assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
 
 	// Give time for write to complete
 	time.Sleep(50 * time.Millisecond)
@@ -697,6 +911,17 @@ func TestPostgresTransactionLogger_Close(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// TestPostgresTransactionLogger_CloseWithoutRun tests that a logger only used for reading can be closed
+func TestPostgresTransactionLogger_CloseWithoutRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectClose()
+
+	logger := &PostgresTransactionLogger{db: db}
+	assert.NoError(t, logger.Close())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 // TestPostgresTransactionLogger_WriteReadRoundTrip is a stub for testing that events written
 // via Run can be read back via ReadEvents and produce consistent results.
 // This requires a real Postgres database to be meaningful since sqlmock-based tests
@@ -717,11 +942,11 @@ func TestPostgresTransactionLogger_CloseDeadlockOnUnreadErrors(t *testing.T) {
 	require.NoError(t, err)
 
 	// Both writes will fail
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventPut, "key1", "value1").
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", []byte("value1"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
 		WillReturnError(fmt.Errorf("error 1"))
-	mock.ExpectExec(`INSERT INTO transactions`).
-		WithArgs(EventPut, "key2", "value2").
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key2", []byte("value2"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
 		WillReturnError(fmt.Errorf("error 2"))
 	mock.ExpectClose()
 
@@ -732,11 +957,11 @@ func TestPostgresTransactionLogger_CloseDeadlockOnUnreadErrors(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 
 	// First write error fills the error channel buffer (size 1)
-	logger.WritePut("key1", "value1")
+	assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
 	time.Sleep(50 * time.Millisecond)
 
 	// Second write error blocks the goroutine trying to send on full error channel
-	logger.WritePut("key2", "value2")
+	assert.NoError(t, writeErr(logger.WritePut(t.Context(), "key2", []byte("value2"))))
 	time.Sleep(50 * time.Millisecond)
 
 	// Intentionally do NOT read from Err() - the error channel is full
@@ -774,9 +999,9 @@ func TestPostgresTransactionLogger_EventsNotDroppedOnClose(t *testing.T) {
 
 		// Expect all 5 events to be written
 		for i := 1; i <= 5; i++ {
-			mock.ExpectExec(`INSERT INTO transactions`).
-				WithArgs(EventPut, fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)).
-				WillReturnResult(sqlmock.NewResult(int64(i), 1))
+			mock.ExpectQuery(`INSERT INTO transactions`).
+				WithArgs(EventPut, fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+				WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(int64(i)))
 		}
 		mock.ExpectClose()
 
@@ -788,7 +1013,7 @@ func TestPostgresTransactionLogger_EventsNotDroppedOnClose(t *testing.T) {
 
 		// Write events into the buffered channel
 		for i := 1; i <= 5; i++ {
-			logger.WritePut(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+			assert.NoError(t, writeErr(logger.WritePut(t.Context(), fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)))))
 		}
 
 		// Close immediately without giving the goroutine time to process
@@ -809,3 +1034,251 @@ func dbCleanup(t *testing.T, db *sql.DB, mock sqlmock.Sqlmock) {
 	err := db.Close()
 	assert.NoError(t, err)
 }
+
+// TestPostgresTransactionLogger_DurableWrites tests that durable writes wait for the INSERT and report its outcome
+func TestPostgresTransactionLogger_DurableWrites(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", []byte("value1"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(7))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventDelete, "key2", []byte(nil), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnError(fmt.Errorf("simulated write error"))
+	mock.ExpectClose()
+
+	logger := &PostgresTransactionLogger{db: db, durable: true}
+	logger.Run()
+
+	// the sequence is the one the database gave the row
+	seq, err := logger.WritePut(t.Context(), "key1", []byte("value1"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), seq)
+
+	err = writeErr(logger.WriteDelete(t.Context(), "key2"))
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "simulated write error")
+
+	require.NoError(t, logger.Close())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestPostgresTransactionLogger_Wrote tests that the logger reports the rows it inserted, once each, and not those it
+// failed to insert
+func TestPostgresTransactionLogger_Wrote(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventPut, "key1", []byte("value1"), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(7))
+	mock.ExpectQuery(`INSERT INTO transactions`).
+		WithArgs(EventDelete, "key2", []byte(nil), sql.NullTime{}, sql.NullString{}, sql.NullString{}).
+		WillReturnError(fmt.Errorf("simulated write error"))
+	mock.ExpectClose()
+
+	logger := &PostgresTransactionLogger{db: db, durable: true}
+	logger.Run()
+
+	seq, err := logger.WritePut(t.Context(), "key1", []byte("value1"))
+	require.NoError(t, err)
+	require.Error(t, writeErr(logger.WriteDelete(t.Context(), "key2")))
+
+	assert.False(t, logger.Wrote(seq-1), "a row written by another replica")
+	assert.True(t, logger.Wrote(seq))
+	assert.False(t, logger.Wrote(seq), "a row already reported")
+	assert.False(t, logger.Wrote(seq+1), "a row which failed to insert")
+
+	require.NoError(t, logger.Close())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestPostgresTransactionLogger_LastSequence tests reading the last allocated sequence
+func TestPostgresTransactionLogger_LastSequence(t *testing.T) {
+	t.Run("allocated", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer dbCleanup(t, db, mock)
+
+		mock.ExpectQuery(`FROM transactions_sequence_seq`).
+			WillReturnRows(sqlmock.NewRows([]string{"last_value"}).AddRow(42))
+
+		logger := &PostgresTransactionLogger{db: db}
+		seq, err := logger.LastSequence()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(42), seq)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer dbCleanup(t, db, mock)
+
+		mock.ExpectQuery(`FROM transactions_sequence_seq`).WillReturnError(fmt.Errorf("database error"))
+
+		logger := &PostgresTransactionLogger{db: db}
+		_, err = logger.LastSequence()
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "failed to read last sequence")
+	})
+}
+
+// TestPostgresTransactionLogger_Compact tests deleting compacted events
+func TestPostgresTransactionLogger_Compact(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer dbCleanup(t, db, mock)
+
+		mock.ExpectExec(`DELETE FROM transactions WHERE sequence <= \$1`).
+			WithArgs(uint64(10)).
+			WillReturnResult(sqlmock.NewResult(0, 10))
+
+		logger := &PostgresTransactionLogger{db: db}
+		require.NoError(t, logger.Compact(10))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer dbCleanup(t, db, mock)
+
+		mock.ExpectExec(`DELETE FROM transactions`).WillReturnError(fmt.Errorf("database error"))
+
+		logger := &PostgresTransactionLogger{db: db}
+		err = logger.Compact(10)
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "failed to compact transactions")
+	})
+}
+
+// TestPostgresTailer_Poll tests that the tailer waits for gaps in the sequence to fill before skipping them
+func TestPostgresTailer_Poll(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer dbCleanup(t, db, mock)
+
+		columns := []string{"sequence", "event_type", "key", "value", "written_at", "expires_at", "content_type", "metadata"}
+		tailer := (&PostgresTransactionLogger{db: db}).Tail()
+
+		var got []uint64
+		collect := func(e Event) error {
+			got = append(got, e.Sequence)
+			return nil
+		}
+
+		// sequence 12 hasn't committed yet
+		mock.ExpectQuery(`WHERE sequence > \$1`).WithArgs(uint64(10)).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(11, EventPut, "key11", "value11", nil, nil, nil, nil).
+				AddRow(13, EventPut, "key13", "value13", nil, nil, nil, nil))
+		require.NoError(t, tailer.Poll(t.Context(), 10, collect))
+		assert.Equal(t, []uint64{11}, got)
+
+		// and it still hasn't, but we haven't waited long enough to give up on it
+		time.Sleep(defaultGapTimeout / 2)
+		mock.ExpectQuery(`WHERE sequence > \$1`).WithArgs(uint64(11)).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(13, EventPut, "key13", "value13", nil, nil, nil, nil))
+		require.NoError(t, tailer.Poll(t.Context(), 11, collect))
+		assert.Equal(t, []uint64{11}, got)
+
+		// by now it must have rolled back
+		time.Sleep(defaultGapTimeout / 2)
+		mock.ExpectQuery(`WHERE sequence > \$1`).WithArgs(uint64(11)).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(13, EventPut, "key13", "value13", nil, nil, nil, nil).
+				AddRow(14, EventDelete, "key13", "", nil, nil, nil, nil))
+		require.NoError(t, tailer.Poll(t.Context(), 11, collect))
+		assert.Equal(t, []uint64{11, 13, 14}, got)
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// TestPostgresTransactionLogger_ReadEvents_Range tests that ranged reads are pushed down into the query
+func TestPostgresTransactionLogger_ReadEvents_Range(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []ReadOption
+		after   uint64
+		through uint64
+	}{
+		{name: "everything", opts: nil, after: 0, through: math.MaxInt64},
+		{name: "after", opts: []ReadOption{ReadAfter(5)}, after: 5, through: math.MaxInt64},
+		{name: "between", opts: []ReadOption{ReadAfter(5), ReadThrough(10)}, after: 5, through: 10},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer dbCleanup(t, db, mock)
+
+			rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value", "written_at", "expires_at", "content_type", "metadata"}).
+				AddRow(6, EventPut, "key6", "value6", nil, nil, nil, nil)
+			mock.ExpectQuery(`WHERE sequence > \$1 AND sequence <= \$2`).
+				WithArgs(tc.after, tc.through).
+				WillReturnRows(rows)
+
+			logger := &PostgresTransactionLogger{db: db}
+			eventChan, errChan := logger.ReadEvents(t.Context(), tc.opts...)
+
+			var events []Event
+			for e := range eventChan {
+				events = append(events, e)
+			}
+			require.NoError(t, <-errChan)
+			assert.Equal(t, []Event{{Sequence: 6, Kind: EventPut, Key: "key6", Value: []byte("value6")}}, events)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// TestPostgresTransactionLogger_Ping tests that Ping reports whether the database can be reached
+func TestPostgresTransactionLogger_Ping(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer dbCleanup(t, db, mock)
+
+	mock.ExpectPing()
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+	logger := &PostgresTransactionLogger{db: db}
+	require.NoError(t, logger.Ping(t.Context()))
+	assert.ErrorContains(t, logger.Ping(t.Context()), "connection refused")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestPostgresReader_ReadEvents tests that the reader only ever queries the table, leaving its schema alone
+func TestPostgresReader_ReadEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	rows := sqlmock.NewRows([]string{"sequence", "event_type", "key", "value", "written_at", "expires_at", "content_type", "metadata"}).
+		AddRow(1, EventPut, "key1", "value1", nil, nil, nil, nil).
+		AddRow(2, EventDelete, "key1", nil, nil, nil, nil, nil)
+	mock.ExpectQuery(`SELECT sequence, event_type, key, value, written_at, expires_at, content_type, metadata FROM transactions`).
+		WithArgs(uint64(0), uint64(2)).
+		WillReturnRows(rows)
+	mock.ExpectClose()
+
+	reader := &PostgresReader{db: db}
+	eventChan, errChan := reader.ReadEvents(t.Context(), ReadThrough(2))
+
+	var events []Event
+	for e := range eventChan {
+		events = append(events, e)
+	}
+	require.NoError(t, <-errChan)
+	assert.Equal(t, []Event{
+		{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
+		{Sequence: 2, Kind: EventDelete, Key: "key1"},
+	}, events)
+
+	require.NoError(t, reader.Close())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
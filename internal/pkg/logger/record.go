@@ -0,0 +1,440 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/treyburn/lockbox/internal/pkg/disk"
+)
+
+// Binary records are laid out as:
+//
+//	magic (1) | version (1) | payload length (4, big endian) | crc32c of payload (4, big endian) | payload
+//
+// and a version 3 payload is:
+//
+//	uvarint sequence | varint timestamp | varint expiry | kind (1) | uvarint key length | key | uvarint value length | value
+//
+// where the timestamp and expiry are in unix nanoseconds, zero meaning unknown and never respectively. Version 2
+// payloads are the same without the expiry, and version 1 payloads without the timestamp either; they are still read,
+// but no longer written.
+//
+// A batch is written as version 4, which is version 3 followed by the ops of the batch:
+//
+//	uvarint op count | op...
+//
+// with each op laid out as
+//
+//	kind (1) | varint expiry | uvarint key length | key | uvarint value length | value
+//
+// Every other event is still written as version 3, so a log holds nothing an older release can't read until it holds a
+// batch.
+//
+// An event which has a content type or metadata, or a batch with an op which does, is written as version 5, which is
+// version 4 with the description of the value following the value of the event and of each of its ops:
+//
+//	uvarint content type length | content type | uvarint field count | (uvarint name length | name | uvarint value length | value)...
+//
+// with the metadata fields in order of name.
+//
+// The magic byte can never start a legacy tab separated line (which always begins with an ASCII digit), so a single
+// file may hold legacy lines followed by binary records and the reader picks the right decoder per record.
+const (
+	recordMagic    byte = 0xB7
+	recordVersion1 byte = 1
+	recordVersion2 byte = 2
+	recordVersion3 byte = 3
+	recordVersion4 byte = 4
+	recordVersion5 byte = 5
+
+	recordHeaderSize = 10
+	// maxRecordSize bounds the payload length we are willing to allocate for, so a corrupt length prefix can't
+	// make us allocate gigabytes before the checksum has a chance to reject the record.
+	maxRecordSize = 64 << 20
+)
+
+var (
+	ErrChecksumMismatch = errors.New("record checksum mismatch")
+	ErrCorruptRecord    = errors.New("corrupt record")
+	// ErrTornWrite indicates that the final record in the log is incomplete, which is what a crash part way through
+	// a write leaves behind. Unlike corruption earlier in the log, it is safe to discard.
+	ErrTornWrite = errors.New("torn write at end of log")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// appendRecord encodes e as a binary record and appends it to buf.
+func appendRecord(buf []byte, e Event) []byte {
+	start := len(buf)
+	version := recordVersion3
+	switch {
+	case described(e):
+		version = recordVersion5
+	case e.Kind == EventBatch:
+		version = recordVersion4
+	}
+	buf = append(buf, recordMagic, version, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	buf = binary.AppendUvarint(buf, e.Sequence)
+	buf = binary.AppendVarint(buf, nanosFromTimestamp(e.Timestamp))
+	buf = binary.AppendVarint(buf, nanosFromTimestamp(e.Expires))
+	buf = append(buf, byte(e.Kind))
+	buf = binary.AppendUvarint(buf, uint64(len(e.Key)))
+	buf = append(buf, e.Key...)
+	buf = binary.AppendUvarint(buf, uint64(len(e.Value)))
+	buf = append(buf, e.Value...)
+	if version >= recordVersion5 {
+		buf = appendDescription(buf, e)
+	}
+	if e.Kind == EventBatch {
+		buf = appendBatch(buf, e.Batch, version)
+	}
+
+	payload := buf[start+recordHeaderSize:]
+	binary.BigEndian.PutUint32(buf[start+2:], uint32(len(payload))) //nolint:gosec // bounded by maxRecordSize on read
+	binary.BigEndian.PutUint32(buf[start+6:], crc32.Checksum(payload, crcTable))
+
+	return buf
+}
+
+// described reports whether e, or any op of a batch, has a content type or metadata.
+func described(e Event) bool {
+	if e.ContentType != "" || len(e.Metadata) > 0 {
+		return true
+	}
+	return slices.ContainsFunc(e.Batch, described)
+}
+
+// appendDescription appends the content type and metadata of e, its fields sorted so that the same event is always
+// written the same way.
+func appendDescription(buf []byte, e Event) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(e.ContentType)))
+	buf = append(buf, e.ContentType...)
+	buf = binary.AppendUvarint(buf, uint64(len(e.Metadata)))
+	for _, name := range slices.Sorted(maps.Keys(e.Metadata)) {
+		buf = binary.AppendUvarint(buf, uint64(len(name)))
+		buf = append(buf, name...)
+		buf = binary.AppendUvarint(buf, uint64(len(e.Metadata[name])))
+		buf = append(buf, e.Metadata[name]...)
+	}
+	return buf
+}
+
+// appendBatch appends the ops of a batch to the payload of its record of the given version.
+func appendBatch(buf []byte, ops []Event, version byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(ops)))
+	for _, op := range ops {
+		buf = append(buf, byte(op.Kind))
+		buf = binary.AppendVarint(buf, nanosFromTimestamp(op.Expires))
+		buf = binary.AppendUvarint(buf, uint64(len(op.Key)))
+		buf = append(buf, op.Key...)
+		buf = binary.AppendUvarint(buf, uint64(len(op.Value)))
+		buf = append(buf, op.Value...)
+		if version >= recordVersion5 {
+			buf = appendDescription(buf, op)
+		}
+	}
+	return buf
+}
+
+// decodePayload decodes a record payload of the given version.
+func decodePayload(version byte, payload []byte) (Event, error) {
+	var e Event
+
+	seq, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return Event{}, fmt.Errorf("%w: invalid sequence", ErrCorruptRecord)
+	}
+	e.Sequence = seq
+	payload = payload[n:]
+
+	var err error
+	if version >= recordVersion2 {
+		if e.Timestamp, payload, err = readTime(payload); err != nil {
+			return Event{}, fmt.Errorf("%w: invalid timestamp", ErrCorruptRecord)
+		}
+	}
+
+	if version >= recordVersion3 {
+		if e.Expires, payload, err = readTime(payload); err != nil {
+			return Event{}, fmt.Errorf("%w: invalid expiry", ErrCorruptRecord)
+		}
+	}
+
+	if len(payload) < 1 {
+		return Event{}, fmt.Errorf("%w: missing event kind", ErrCorruptRecord)
+	}
+	e.Kind = EventKind(payload[0])
+	payload = payload[1:]
+
+	key, payload, err := disk.ReadBytes(payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("%w: invalid key: %w", ErrCorruptRecord, err)
+	}
+	e.Key = string(key)
+
+	value, payload, err := disk.ReadBytes(payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("%w: invalid value: %w", ErrCorruptRecord, err)
+	}
+	e.Value = clone(value)
+
+	if version >= recordVersion5 {
+		if e.ContentType, e.Metadata, payload, err = readDescription(payload); err != nil {
+			return Event{}, fmt.Errorf("%w: invalid description: %w", ErrCorruptRecord, err)
+		}
+	}
+
+	if version >= recordVersion4 && e.Kind == EventBatch {
+		if e.Batch, payload, err = decodeBatch(version, payload); err != nil {
+			return Event{}, fmt.Errorf("%w: invalid batch: %w", ErrCorruptRecord, err)
+		}
+	}
+
+	if len(payload) != 0 {
+		return Event{}, fmt.Errorf("%w: %d trailing bytes", ErrCorruptRecord, len(payload))
+	}
+
+	return e, nil
+}
+
+// decodeBatch decodes the ops of a batch in a record of the given version from the front of b and returns them along
+// with the remainder.
+func decodeBatch(version byte, b []byte) ([]Event, []byte, error) {
+	count, n := binary.Uvarint(b)
+	// every op takes several bytes, so a corrupt count can't make us allocate much more than the record holds
+	if n <= 0 || count > uint64(len(b)) {
+		return nil, nil, errors.New("invalid op count")
+	}
+	b = b[n:]
+
+	ops := make([]Event, 0, count)
+	for i := range count {
+		if len(b) < 1 {
+			return nil, nil, fmt.Errorf("op %d: missing kind", i)
+		}
+		op := Event{Kind: EventKind(b[0])}
+
+		var key, value []byte
+		var err error
+		if op.Expires, b, err = readTime(b[1:]); err != nil {
+			return nil, nil, fmt.Errorf("op %d: invalid expiry: %w", i, err)
+		}
+		if key, b, err = disk.ReadBytes(b); err != nil {
+			return nil, nil, fmt.Errorf("op %d: invalid key: %w", i, err)
+		}
+		if value, b, err = disk.ReadBytes(b); err != nil {
+			return nil, nil, fmt.Errorf("op %d: invalid value: %w", i, err)
+		}
+		op.Key, op.Value = string(key), clone(value)
+		if version >= recordVersion5 {
+			if op.ContentType, op.Metadata, b, err = readDescription(b); err != nil {
+				return nil, nil, fmt.Errorf("op %d: invalid description: %w", i, err)
+			}
+		}
+		ops = append(ops, op)
+	}
+	return ops, b, nil
+}
+
+// readDescription reads the content type and metadata of a value from the front of b and returns them along with the
+// remainder. Metadata with no fields is returned as nil.
+func readDescription(b []byte) (string, map[string]string, []byte, error) {
+	contentType, b, err := disk.ReadBytes(b)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid content type: %w", err)
+	}
+
+	count, n := binary.Uvarint(b)
+	// as with the ops of a batch, every field takes at least two bytes
+	if n <= 0 || count > uint64(len(b)) {
+		return "", nil, nil, errors.New("invalid metadata field count")
+	}
+	b = b[n:]
+
+	var metadata map[string]string
+	for i := range count {
+		var name, value []byte
+		if name, b, err = disk.ReadBytes(b); err != nil {
+			return "", nil, nil, fmt.Errorf("metadata field %d: invalid name: %w", i, err)
+		}
+		if value, b, err = disk.ReadBytes(b); err != nil {
+			return "", nil, nil, fmt.Errorf("metadata field %d: invalid value: %w", i, err)
+		}
+		if metadata == nil {
+			metadata = make(map[string]string, count)
+		}
+		metadata[string(name)] = string(value)
+	}
+	return string(contentType), metadata, b, nil
+}
+
+// clone copies b out of the record it was read from, whose buffer may be reused, leaving an empty value nil.
+func clone(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return bytes.Clone(b)
+}
+
+// nanosFromTimestamp converts t to unix nanoseconds as stored in the log, with the zero time stored as zero.
+func nanosFromTimestamp(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// timestampFromNanos converts unix nanoseconds, as stored in the log, to a timestamp. Zero means the time isn't set,
+// which is the case for events written before timestamps were recorded and for keys which never expire.
+func timestampFromNanos(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
+
+// readTime reads a varint timestamp in unix nanoseconds from the front of b and returns it along with the remainder.
+func readTime(b []byte) (time.Time, []byte, error) {
+	nanos, n := binary.Varint(b)
+	if n <= 0 {
+		return time.Time{}, nil, errors.New("invalid varint")
+	}
+	return timestampFromNanos(nanos), b[n:], nil
+}
+
+// recordReader decodes events from a transaction log, transparently handling both binary records and legacy tab
+// separated lines. offset always points just past the last successfully decoded record, which makes it the size to
+// truncate the log back to when the tail is torn.
+type recordReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func newRecordReader(r io.Reader) *recordReader {
+	return &recordReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next event in the log. It returns io.EOF once the log is exhausted.
+func (rr *recordReader) Next() (Event, error) {
+	first, err := rr.r.Peek(1)
+	if err != nil {
+		return Event{}, err
+	}
+
+	if first[0] == recordMagic {
+		return rr.nextRecord()
+	}
+
+	return rr.nextLine()
+}
+
+func (rr *recordReader) nextRecord() (Event, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(rr.r, header); err != nil {
+		return Event{}, rr.wrapReadErr("header", err)
+	}
+
+	if header[1] < recordVersion1 || header[1] > recordVersion5 {
+		return Event{}, fmt.Errorf("%w: unsupported record version %d at offset %d", ErrCorruptRecord, header[1], rr.offset)
+	}
+
+	size := binary.BigEndian.Uint32(header[2:])
+	if size > maxRecordSize {
+		return Event{}, fmt.Errorf("%w: record size %d at offset %d exceeds limit", ErrCorruptRecord, size, rr.offset)
+	}
+
+	payload := make([]byte, size)
+	if n, err := io.ReadFull(rr.r, payload); err != nil {
+		// a length prefix which was corrupted rather than cut short runs on through the intact records after it, and
+		// treating it as torn would throw them all away
+		if containsRecord(payload[:n]) {
+			return Event{}, fmt.Errorf("%w: record length %d at offset %d runs over the records after it",
+				ErrCorruptRecord, size, rr.offset)
+		}
+		return Event{}, rr.wrapReadErr("payload", err)
+	}
+
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[6:]) {
+		// a complete record with a bad checksum is only a torn write if nothing follows it - e.g. the filesystem
+		// extended the file but never persisted the data. Anywhere else it is corruption and must not be skipped.
+		if rr.atEOF() {
+			return Event{}, fmt.Errorf("%w at offset %d: %w", ErrTornWrite, rr.offset, ErrChecksumMismatch)
+		}
+		return Event{}, fmt.Errorf("%w at offset %d", ErrChecksumMismatch, rr.offset)
+	}
+
+	e, err := decodePayload(header[1], payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("error decoding record at offset %d: %w", rr.offset, err)
+	}
+
+	rr.offset += recordHeaderSize + int64(size)
+	return e, nil
+}
+
+func (rr *recordReader) nextLine() (Event, error) {
+	line, err := rr.r.ReadString('\n')
+	if errors.Is(err, io.EOF) {
+		// every legacy line was written with a trailing newline, so one without it was cut short
+		return Event{}, fmt.Errorf("%w at offset %d: unterminated line %q", ErrTornWrite, rr.offset, line)
+	}
+	if err != nil {
+		return Event{}, fmt.Errorf("error reading events: %w", err)
+	}
+
+	e, err := parseEvent(strings.TrimSuffix(line, "\n"))
+	if err != nil {
+		return Event{}, err
+	}
+
+	rr.offset += int64(len(line))
+	return e, nil
+}
+
+// containsRecord reports whether a complete record, with a payload which matches its checksum, starts anywhere in b.
+func containsRecord(b []byte) bool {
+	for i := range b {
+		rest := b[i:]
+		if len(rest) < recordHeaderSize || rest[0] != recordMagic {
+			continue
+		}
+		if rest[1] < recordVersion1 || rest[1] > recordVersion5 {
+			continue
+		}
+		// every payload holds at least a sequence and a kind, so an empty one is just bytes which happen to match
+		size := binary.BigEndian.Uint32(rest[2:])
+		if size == 0 || uint64(size) > uint64(len(rest)-recordHeaderSize) {
+			continue
+		}
+		payload := rest[recordHeaderSize : recordHeaderSize+int(size)]
+		if crc32.Checksum(payload, crcTable) == binary.BigEndian.Uint32(rest[6:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapReadErr classifies an error from reading part of a binary record. Running out of data part way through a record
+// means the tail of the log is torn.
+func (rr *recordReader) wrapReadErr(part string, err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w at offset %d: incomplete record %s: %w", ErrTornWrite, rr.offset, part, io.ErrUnexpectedEOF)
+	}
+	return fmt.Errorf("error reading record %s at offset %d: %w", part, rr.offset, err)
+}
+
+// atEOF reports whether the reader has no further data.
+func (rr *recordReader) atEOF() bool {
+	_, err := rr.r.Peek(1)
+	return errors.Is(err, io.EOF)
+}
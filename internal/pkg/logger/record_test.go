@@ -0,0 +1,322 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeAll decodes every event in data, failing the test on any error
+func decodeAll(t *testing.T, data string) []Event {
+	t.Helper()
+
+	reader := newRecordReader(bytes.NewBufferString(data))
+
+	var events []Event
+	for {
+		e, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return events
+		}
+		require.NoError(t, err)
+		events = append(events, e)
+	}
+}
+
+// withoutTimestamps clears the timestamps the logger stamps on events, for comparing them with expected events
+func withoutTimestamps(events []Event) []Event {
+	var out []Event
+	for _, e := range events {
+		e.Timestamp = time.Time{}
+		out = append(out, e)
+	}
+	return out
+}
+
+// TestRecord_RoundTrip tests that events survive encoding and decoding regardless of their contents
+func TestRecord_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+	}{
+		{
+			name:  "simple put",
+			event: Event{Sequence: 1, Kind: EventPut, Key: "key", Value: []byte("value")},
+		},
+		{
+			name:  "delete with empty value",
+			event: Event{Sequence: 2, Kind: EventDelete, Key: "key"},
+		},
+		{
+			name:  "tabs and newlines",
+			event: Event{Sequence: 3, Kind: EventPut, Key: "a\tkey\n", Value: []byte("line1\nline2\tcol2\n")},
+		},
+		{
+			name:  "binary value",
+			event: Event{Sequence: 4, Kind: EventPut, Key: "blob", Value: []byte{0x00, recordMagic, 0xff, '\n', '\t'}},
+		},
+		{
+			name:  "empty key",
+			event: Event{Sequence: 5, Kind: EventPut, Key: "", Value: []byte("value")},
+		},
+		{
+			name:  "large sequence",
+			event: Event{Sequence: 1<<64 - 1, Kind: EventPut, Key: "key", Value: []byte("value")},
+		},
+		{
+			name:  "timestamp",
+			event: Event{Sequence: 6, Kind: EventPut, Key: "key", Value: []byte("value"), Timestamp: time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)},
+		},
+		{
+			name:  "timestamp before the epoch",
+			event: Event{Sequence: 7, Kind: EventDelete, Key: "key", Timestamp: time.Date(1969, 7, 20, 20, 17, 0, 0, time.UTC)},
+		},
+		{
+			name: "expiry",
+			event: Event{
+				Sequence: 8, Kind: EventPut, Key: "key", Value: []byte("value"),
+				Timestamp: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), Expires: time.Date(2024, 5, 6, 8, 8, 9, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := appendRecord(nil, tc.event)
+			assert.Equal(t, recordMagic, buf[0])
+			assert.Equal(t, recordVersion3, buf[1])
+
+			events := decodeAll(t, string(buf))
+			require.Len(t, events, 1)
+			assert.Equal(t, tc.event, events[0])
+		})
+	}
+}
+
+// TestRecord_Version1 tests that records written before timestamps were recorded are still read, without one
+func TestRecord_Version1(t *testing.T) {
+	payload := binary.AppendUvarint(nil, 7)
+	payload = append(payload, byte(EventPut))
+	payload = binary.AppendUvarint(payload, 3)
+	payload = append(payload, "key"...)
+	payload = binary.AppendUvarint(payload, 5)
+	payload = append(payload, "value"...)
+
+	buf := []byte{recordMagic, recordVersion1}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload))) //nolint:gosec // test payload is tiny
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(payload, crcTable))
+	buf = append(buf, payload...)
+
+	assert.Equal(t, []Event{{Sequence: 7, Kind: EventPut, Key: "key", Value: []byte("value")}}, decodeAll(t, string(buf)))
+}
+
+// TestRecord_Version2 tests that records written before expiries were recorded are still read, as never expiring
+func TestRecord_Version2(t *testing.T) {
+	written := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	payload := binary.AppendUvarint(nil, 7)
+	payload = binary.AppendVarint(payload, written.UnixNano())
+	payload = append(payload, byte(EventPut))
+	payload = binary.AppendUvarint(payload, 3)
+	payload = append(payload, "key"...)
+	payload = binary.AppendUvarint(payload, 5)
+	payload = append(payload, "value"...)
+
+	buf := []byte{recordMagic, recordVersion2}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload))) //nolint:gosec // test payload is tiny
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(payload, crcTable))
+	buf = append(buf, payload...)
+
+	expected := []Event{{Sequence: 7, Kind: EventPut, Key: "key", Value: []byte("value"), Timestamp: written}}
+	assert.Equal(t, expected, decodeAll(t, string(buf)))
+}
+
+// TestRecord_Batch tests that a batch is written as a version 4 record which holds every op, and that a batch whose
+// ops are cut short is rejected
+func TestRecord_Batch(t *testing.T) {
+	batch := Event{
+		Sequence:  9,
+		Kind:      EventBatch,
+		Timestamp: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		Batch: []Event{
+			{Kind: EventPut, Key: "key1", Value: []byte("value1"), Expires: time.Date(2024, 5, 6, 8, 8, 9, 0, time.UTC)},
+			{Kind: EventDelete, Key: "key2"},
+			{Kind: EventPut, Key: "key3", Value: []byte{0x00, recordMagic, '\n'}},
+		},
+	}
+
+	buf := appendRecord(nil, batch)
+	assert.Equal(t, recordVersion4, buf[1])
+	assert.Equal(t, []Event{batch}, decodeAll(t, string(buf)))
+
+	// drop the last op's value and fix up the header, so that only the batch is at fault
+	payload := buf[recordHeaderSize : len(buf)-4]
+	corrupt := []byte{recordMagic, recordVersion4}
+	corrupt = binary.BigEndian.AppendUint32(corrupt, uint32(len(payload))) //nolint:gosec // test payload is tiny
+	corrupt = binary.BigEndian.AppendUint32(corrupt, crc32.Checksum(payload, crcTable))
+	corrupt = append(corrupt, payload...)
+
+	_, err := newRecordReader(bytes.NewReader(corrupt)).Next()
+	require.ErrorIs(t, err, ErrCorruptRecord)
+	assert.ErrorContains(t, err, "op 2")
+}
+
+// TestRecord_Description tests that an event with a content type or metadata is written as a version 5 record, whose
+// ops carry theirs too, and that every other event is still written as an older version
+func TestRecord_Description(t *testing.T) {
+	put := Event{
+		Sequence:    3,
+		Kind:        EventPut,
+		Key:         "key",
+		Value:       []byte("{}"),
+		ContentType: "application/json",
+		Metadata:    map[string]string{"Owner": "alice", "Empty": ""},
+	}
+	buf := appendRecord(nil, put)
+	assert.Equal(t, recordVersion5, buf[1])
+	assert.Equal(t, []Event{put}, decodeAll(t, string(buf)))
+
+	batch := Event{
+		Sequence: 4,
+		Kind:     EventBatch,
+		Batch: []Event{
+			{Kind: EventPut, Key: "key1", Value: []byte("v"), Metadata: map[string]string{"Owner": "bob"}},
+			{Kind: EventDelete, Key: "key2"},
+		},
+	}
+	buf = appendRecord(nil, batch)
+	assert.Equal(t, recordVersion5, buf[1])
+	assert.Equal(t, []Event{batch}, decodeAll(t, string(buf)))
+
+	assert.Equal(t, recordVersion3, appendRecord(nil, Event{Sequence: 5, Kind: EventPut, Key: "key"})[1])
+	batch.Batch[0].Metadata = nil
+	assert.Equal(t, recordVersion4, appendRecord(nil, batch)[1])
+}
+
+// TestRecord_MixedFormats tests that legacy lines followed by binary records are all replayed in order
+func TestRecord_MixedFormats(t *testing.T) {
+	data := []byte("1\t2\tkey1\tvalue1\n2\t1\tkey2\t\n")
+	data = appendRecord(data, Event{Sequence: 3, Kind: EventPut, Key: "key3", Value: []byte("has\ttab")})
+	data = appendRecord(data, Event{Sequence: 4, Kind: EventDelete, Key: "key1"})
+
+	expected := []Event{
+		{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
+		{Sequence: 2, Kind: EventDelete, Key: "key2"},
+		{Sequence: 3, Kind: EventPut, Key: "key3", Value: []byte("has\ttab")},
+		{Sequence: 4, Kind: EventDelete, Key: "key1"},
+	}
+	assert.Equal(t, expected, decodeAll(t, string(data)))
+}
+
+// TestRecord_Corruption tests that damaged records are rejected rather than replayed
+func TestRecord_Corruption(t *testing.T) {
+	valid := appendRecord(nil, Event{Sequence: 1, Kind: EventPut, Key: "key", Value: []byte("value")})
+
+	tests := []struct {
+		name      string
+		mutate    func([]byte) []byte
+		expectErr error
+		contains  string
+	}{
+		{
+			name: "flipped payload bit",
+			mutate: func(b []byte) []byte {
+				b[len(b)-1] ^= 0x01
+				return b
+			},
+			expectErr: ErrChecksumMismatch,
+		},
+		{
+			name: "unsupported version",
+			mutate: func(b []byte) []byte {
+				b[1] = 0xff
+				return b
+			},
+			expectErr: ErrCorruptRecord,
+			contains:  "unsupported record version",
+		},
+		{
+			name: "oversized length",
+			mutate: func(b []byte) []byte {
+				binary.BigEndian.PutUint32(b[2:], maxRecordSize+1)
+				return b
+			},
+			expectErr: ErrCorruptRecord,
+			contains:  "exceeds limit",
+		},
+		{
+			name: "truncated payload",
+			mutate: func(b []byte) []byte {
+				return b[:len(b)-2]
+			},
+			expectErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name: "truncated header",
+			mutate: func(b []byte) []byte {
+				return b[:4]
+			},
+			expectErr: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data := tc.mutate(bytes.Clone(valid))
+
+			_, err := newRecordReader(bytes.NewReader(data)).Next()
+			require.Error(t, err)
+			assert.ErrorIs(t, err, tc.expectErr)
+			if tc.contains != "" {
+				assert.ErrorContains(t, err, tc.contains)
+			}
+		})
+	}
+}
+
+// TestFileTransactionLogger_RoundTripSpecialCharacters tests that keys and values containing the characters which
+// broke the legacy format are written and replayed intact
+func TestFileTransactionLogger_RoundTripSpecialCharacters(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		mock := newMockReadWriteCloser("")
+		logger := NewFileTransactionLogger(mock)
+		logger.Run()
+
+		time.Sleep(time.Millisecond)
+
+		assert.NoError(t, writeErr(logger.WritePut(t.Context(), "tab\tkey", []byte("value\twith\ttabs"))))
+		assert.NoError(t, writeErr(logger.WritePut(t.Context(), "newline\nkey", []byte("multi\nline\nvalue\n"))))
+		assert.NoError(t, writeErr(logger.WriteDelete(t.Context(), "tab\tkey")))
+
+		time.Sleep(time.Millisecond)
+
+		require.NoError(t, logger.Close())
+		synctest.Wait()
+
+		readLogger := NewFileTransactionLogger(mock)
+		eventChan, errChan := readLogger.ReadEvents(t.Context())
+
+		var events []Event
+		for e := range eventChan {
+			events = append(events, e)
+		}
+		for err := range errChan {
+			assert.NoError(t, err)
+		}
+
+		expected := []Event{
+			{Sequence: 1, Kind: EventPut, Key: "tab\tkey", Value: []byte("value\twith\ttabs")},
+			{Sequence: 2, Kind: EventPut, Key: "newline\nkey", Value: []byte("multi\nline\nvalue\n")},
+			{Sequence: 3, Kind: EventDelete, Key: "tab\tkey"},
+		}
+		assert.Equal(t, expected, withoutTimestamps(events))
+	})
+}
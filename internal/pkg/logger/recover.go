@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// errStopRead stops a read once it has gone past the end of what was asked for.
+var errStopRead = errors.New("read stopped")
+
+// EventReader is a log which can be read from the start, such as a TransactionManager or a SegmentReader.
+type EventReader interface {
+	ReadEvents(ctx context.Context, opts ...ReadOption) (<-chan Event, <-chan error)
+}
+
+// RecoveryPoint is where point in time recovery stops replaying the log. Either bound may be left zero, and when both
+// are set recovery stops at whichever is reached first.
+type RecoveryPoint struct {
+	// Sequence is the last event to replay.
+	Sequence uint64
+	// Time is the latest write time to replay, events written after it are left out.
+	Time time.Time
+}
+
+// includes reports whether e was written at or before the recovery point. Events written before timestamps were
+// recorded have none, and as they predate every event that does they are always included.
+func (p RecoveryPoint) includes(e Event) bool {
+	if p.Sequence != 0 && e.Sequence > p.Sequence {
+		return false
+	}
+	return p.Time.IsZero() || e.Timestamp.IsZero() || !e.Timestamp.After(p.Time)
+}
+
+// Recover replays the events in the log after the given sequence, through to the recovery point, calling apply with
+// each of them in order. It is used to rebuild the store as it was at some point in the past, starting from an empty
+// store or a snapshot taken before the recovery point. It returns the sequence number of the last event applied.
+func Recover(ctx context.Context, log EventReader, after uint64, point RecoveryPoint, apply func(Event) error) (uint64, error) {
+	events, errs := log.ReadEvents(ctx, ReadAfter(after), ReadThrough(point.Sequence))
+
+	last := after
+	stopped := false
+	var err error
+	for e := range events {
+		if stopped || err != nil {
+			// drain the rest of the log so that the reader can finish
+			continue
+		}
+		if !point.includes(e) {
+			stopped = true
+			continue
+		}
+		if err = apply(e); err != nil {
+			err = fmt.Errorf("failed to apply event %d: %w", e.Sequence, err)
+			continue
+		}
+		last = e.Sequence
+	}
+
+	for readErr := range errs {
+		err = errors.Join(err, fmt.Errorf("failed to read transaction log: %w", readErr))
+	}
+
+	return last, err
+}
+
+// compile time assertion that SegmentReader is an EventReader
+var _ EventReader = (*SegmentReader)(nil)
+
+// NewSegmentReader returns a reader for the segmented logs in dirs, which are read one after the other. Passing a
+// compaction archive followed by the log directory it was archived from reads all of the history which has been kept.
+func NewSegmentReader(dirs ...string) *SegmentReader {
+	return &SegmentReader{dirs: dirs}
+}
+
+// SegmentReader reads segmented logs without locking them, so unlike a FileTransactionLogger it can read a log which
+// another process is writing to. Events which are still being written when it reaches them are left out.
+type SegmentReader struct {
+	dirs []string
+}
+
+func (s *SegmentReader) ReadEvents(ctx context.Context, opts ...ReadOption) (<-chan Event, <-chan error) {
+	r := newReadRange(opts...)
+
+	outEvent := make(chan Event)
+	outErr := make(chan error, 1)
+
+	go func() {
+		defer close(outEvent)
+		defer close(outErr)
+
+		last := r.after
+		for _, dir := range s.dirs {
+			// the archive isn't created until something is compacted into it
+			if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			err := NewSegmentTailer(dir).Poll(ctx, last, func(e Event) error {
+				if r.beyond(e.Sequence) {
+					return errStopRead
+				}
+				if err := send(ctx, outEvent, e); err != nil {
+					return err
+				}
+				last = e.Sequence
+				return nil
+			})
+			if errors.Is(err, errStopRead) {
+				return
+			}
+			if err != nil {
+				outErr <- err
+				return
+			}
+		}
+	}()
+
+	return outEvent, outErr
+}
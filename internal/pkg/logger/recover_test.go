@@ -0,0 +1,182 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceReader is an EventReader over an in memory log
+type sliceReader struct {
+	events []Event
+	err    error
+}
+
+func (s sliceReader) ReadEvents(ctx context.Context, opts ...ReadOption) (<-chan Event, <-chan error) {
+	r := newReadRange(opts...)
+
+	outEvent := make(chan Event)
+	outErr := make(chan error, 1)
+
+	go func() {
+		defer close(outEvent)
+		defer close(outErr)
+
+		for _, e := range s.events {
+			if e.Sequence <= r.after {
+				continue
+			}
+			if r.beyond(e.Sequence) {
+				return
+			}
+			if send(ctx, outEvent, e) != nil {
+				return
+			}
+		}
+		if s.err != nil {
+			outErr <- s.err
+		}
+	}()
+
+	return outEvent, outErr
+}
+
+// TestFileTransactionLogger_Timestamps tests that events are stamped with the time they were written
+func TestFileTransactionLogger_Timestamps(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		mock := newMockReadWriteCloser("")
+		logger := NewFileTransactionLogger(mock, WithDurableWrites())
+		logger.Run()
+
+		first := time.Now().UTC()
+		require.NoError(t, writeErr(logger.WritePut(t.Context(), "key1", []byte("value1"))))
+		time.Sleep(time.Hour)
+		second := time.Now().UTC()
+		require.NoError(t, writeErr(logger.WriteDelete(t.Context(), "key1")))
+		require.NoError(t, logger.Close())
+
+		expected := []Event{
+			{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1"), Timestamp: first},
+			{Sequence: 2, Kind: EventDelete, Key: "key1", Timestamp: second},
+		}
+		assert.Equal(t, expected, decodeAll(t, mock.String()))
+	})
+}
+
+// TestRecover tests that recovery replays the log up to the recovery point and no further
+func TestRecover(t *testing.T) {
+	start := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	events := []Event{
+		// written before timestamps were recorded
+		{Sequence: 1, Kind: EventPut, Key: "key1", Value: []byte("value1")},
+		{Sequence: 2, Kind: EventPut, Key: "key2", Value: []byte("value2"), Timestamp: start},
+		{Sequence: 3, Kind: EventDelete, Key: "key1", Timestamp: start.Add(time.Hour)},
+		{Sequence: 4, Kind: EventPut, Key: "key2", Value: []byte("value4"), Timestamp: start.Add(2 * time.Hour)},
+		{Sequence: 5, Kind: EventPut, Key: "key5", Value: []byte("value5"), Timestamp: start.Add(2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name     string
+		after    uint64
+		point    RecoveryPoint
+		expected uint64
+	}{
+		{name: "everything", point: RecoveryPoint{}, expected: 5},
+		{name: "sequence", point: RecoveryPoint{Sequence: 3}, expected: 3},
+		{name: "time", point: RecoveryPoint{Time: start.Add(time.Hour)}, expected: 3},
+		{name: "time between events", point: RecoveryPoint{Time: start.Add(90 * time.Minute)}, expected: 3},
+		{name: "time before the first timestamp", point: RecoveryPoint{Time: start.Add(-time.Hour)}, expected: 1},
+		{name: "sequence reached first", point: RecoveryPoint{Sequence: 2, Time: start.Add(time.Hour)}, expected: 2},
+		{name: "time reached first", point: RecoveryPoint{Sequence: 4, Time: start}, expected: 2},
+		{name: "after a snapshot", after: 2, point: RecoveryPoint{Sequence: 4}, expected: 4},
+		{name: "snapshot at the recovery point", after: 4, point: RecoveryPoint{Sequence: 4}, expected: 4},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			applied := []Event{}
+			last, err := Recover(t.Context(), sliceReader{events: events}, tc.after, tc.point, func(e Event) error {
+				applied = append(applied, e)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, last)
+			assert.Equal(t, events[tc.after:tc.expected], applied)
+		})
+	}
+
+	t.Run("apply error", func(t *testing.T) {
+		applyErr := errors.New("boom")
+		last, err := Recover(t.Context(), sliceReader{events: events}, 0, RecoveryPoint{}, func(e Event) error {
+			if e.Sequence == 3 {
+				return applyErr
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, applyErr)
+		assert.Equal(t, uint64(2), last)
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		readErr := errors.New("boom")
+		_, err := Recover(t.Context(), sliceReader{events: events, err: readErr}, 0, RecoveryPoint{Time: start}, func(Event) error {
+			return nil
+		})
+		assert.ErrorIs(t, err, readErr)
+	})
+}
+
+// TestSegmentReader tests that the reader carries on from a compaction archive into the live log
+func TestSegmentReader(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "archive")
+	record := appendRecord(nil, putEvents(1, 1)[0])
+
+	logger, _ := openSegmentedLog(t, dir,
+		WithDurableWrites(),
+		WithSegmentSize(int64(2*len(record))),
+		WithSegmentArchive(archive),
+	)
+	logger.Run()
+	writePuts(t, logger, 1, 5)
+	require.NoError(t, logger.Compact(2))
+
+	tests := []struct {
+		name     string
+		dirs     []string
+		opts     []ReadOption
+		expected []Event
+	}{
+		{name: "archive and log", dirs: []string{archive, dir}, expected: putEvents(1, 5)},
+		{name: "after", dirs: []string{archive, dir}, opts: []ReadOption{ReadAfter(3)}, expected: putEvents(4, 5)},
+		{name: "through", dirs: []string{archive, dir}, opts: []ReadOption{ReadThrough(3)}, expected: putEvents(1, 3)},
+		{
+			name:     "no archive yet",
+			dirs:     []string{filepath.Join(t.TempDir(), "missing"), dir},
+			opts:     []ReadOption{ReadAfter(2)},
+			expected: putEvents(3, 5),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// the log is still locked by its writer
+			events, err := collectEvents(t, NewSegmentReader(tc.dirs...), tc.opts...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, withoutTimestamps(events))
+		})
+	}
+
+	t.Run("compacted", func(t *testing.T) {
+		_, err := collectEvents(t, NewSegmentReader(dir))
+		assert.ErrorIs(t, err, ErrCompacted)
+	})
+
+	require.NoError(t, logger.Close())
+}
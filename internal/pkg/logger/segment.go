@@ -0,0 +1,322 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treyburn/lockbox/internal/pkg/disk"
+)
+
+// A segmented log is a directory of segment files, each named after the sequence number of the first event written to
+// it, zero padded so that lexical and numeric order agree. Only the newest segment, the active one, is ever written to;
+// the others are sealed, having been synced before the log rolled over to the next.
+const (
+	segmentSuffix      = ".log"
+	segmentPerm        = 0o600
+	defaultSegmentSize = 64 << 20
+)
+
+// NewSegmentedFileTransactionLogger creates a logger which writes to a series of segment files in dir rather than a
+// single ever-growing file. The log rolls over to a new segment once the active one reaches the size set by
+// WithSegmentSize or the age set by WithSegmentAge, ReadEvents replays every segment in order, and Compact deletes, or
+// archives, the segments which are entirely covered by a snapshot.
+//
+// Only one process may write to a segmented log at a time, which is enforced with a lock on dir; see LockDir. If no
+// lock is passed in with WithDirLock, the constructor takes it itself and fails with ErrLogLocked if it is held
+// elsewhere.
+func NewSegmentedFileTransactionLogger(dir string, opts ...FileOption) (*FileTransactionLogger, error) {
+	l := &FileTransactionLogger{segments: segmentSet{dir: dir, maxSize: defaultSegmentSize}}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	if l.segments.lock == nil {
+		lock, err := LockDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		l.segments.lock = lock
+	}
+
+	if err := l.open(); err != nil {
+		_ = l.segments.lock.Unlock()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// open opens the active segment, creating the first one if the log is empty.
+func (l *FileTransactionLogger) open() error {
+	dir := l.segments.dir
+
+	bases, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	created := len(bases) == 0
+	if created {
+		// the first event written will be the one after the snapshot the store was restored from, if any
+		bases = []uint64{l.lastSequence.Load() + 1}
+	}
+
+	file, err := os.OpenFile(segmentPath(dir, bases[len(bases)-1]), os.O_RDWR|os.O_APPEND|os.O_CREATE, segmentPerm)
+	if err != nil {
+		return fmt.Errorf("failed to open active segment: %w", err)
+	}
+
+	if created {
+		if err = disk.SyncDir(dir); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+
+	l.file = file
+	l.segments.bases = bases
+	return nil
+}
+
+// WithSegmentSize sets the size in bytes at which a segmented log rolls over to a new segment. The default is 64MiB.
+// It has no effect on a logger which isn't segmented.
+func WithSegmentSize(size int64) FileOption {
+	return func(l *FileTransactionLogger) {
+		l.segments.maxSize = size
+	}
+}
+
+// WithSegmentAge makes a segmented log roll over to a new segment once the active one has been written to for age,
+// which bounds how long events wait before they can be compacted away or shipped elsewhere. Rolling happens on the
+// next write after the segment comes of age. It is disabled by default and has no effect on a logger which isn't
+// segmented.
+func WithSegmentAge(age time.Duration) FileOption {
+	return func(l *FileTransactionLogger) {
+		l.segments.maxAge = age
+	}
+}
+
+// WithSegmentArchive makes compaction move covered segments into dir instead of deleting them. dir must be on the same
+// filesystem as the log. It has no effect on a logger which isn't segmented.
+func WithSegmentArchive(dir string) FileOption {
+	return func(l *FileTransactionLogger) {
+		l.segments.archiveDir = dir
+	}
+}
+
+// MigrateToSegments moves a log written by NewFileTransactionLogger at path into dir as its first segment, so that
+// NewSegmentedFileTransactionLogger carries on from it. It does nothing if there is no file at path or if dir already
+// holds segments.
+func MigrateToSegments(path, dir string) error {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	bases, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	if len(bases) > 0 {
+		return nil
+	}
+
+	// the legacy log may have been compacted, so 1 is only a lower bound for its first event, but that is all a
+	// segment's name needs to be until another segment follows it
+	err = os.Rename(path, segmentPath(dir, 1))
+	if errors.Is(err, fs.ErrNotExist) {
+		// another replica got there first
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate log: %w", err)
+	}
+
+	slog.Info("migrated transaction log to segments", slog.String("from", path), slog.String("to", dir))
+	return disk.SyncDir(dir)
+}
+
+// segmentSet tracks the segments of a segmented log. It is empty for a logger writing to a single file.
+type segmentSet struct {
+	dir        string
+	archiveDir string
+	maxSize    int64
+	maxAge     time.Duration
+	lock       *DirLock
+	// bases holds the first sequence number of each segment in ascending order, the last being the active segment
+	bases []uint64
+}
+
+func (s *segmentSet) enabled() bool {
+	return s.dir != ""
+}
+
+// sealed returns the paths of the segments before the active one which may hold events after the given sequence,
+// oldest first.
+func (s *segmentSet) sealed(after uint64) []string {
+	if len(s.bases) == 0 {
+		return nil
+	}
+
+	var paths []string
+	for i, base := range s.bases[:len(s.bases)-1] {
+		if s.bases[i+1] <= after+1 {
+			// everything in this segment comes before the one after it starts
+			continue
+		}
+		paths = append(paths, segmentPath(s.dir, base))
+	}
+	return paths
+}
+
+// due reports whether the active segment should be sealed before anything more is written to it. An empty segment is
+// never rolled, which keeps segment names unique.
+func (s *segmentSet) due(size int64, age time.Duration) bool {
+	if !s.enabled() || size == 0 {
+		return false
+	}
+
+	return (s.maxSize > 0 && size >= s.maxSize) || (s.maxAge > 0 && age >= s.maxAge)
+}
+
+// discard deletes or archives the segment starting at base.
+func (s *segmentSet) discard(base uint64) error {
+	path := segmentPath(s.dir, base)
+
+	if s.archiveDir == "" {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove segment: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(s.archiveDir, 0o750); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	if err := os.Rename(path, segmentPath(s.archiveDir, base)); err != nil {
+		return fmt.Errorf("failed to archive segment: %w", err)
+	}
+	return nil
+}
+
+func segmentPath(dir string, base uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, segmentSuffix))
+}
+
+// listSegments returns the first sequence number of each segment in dir in ascending order.
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments: %w", err)
+	}
+
+	var bases []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+
+	slices.Sort(bases)
+	return bases, nil
+}
+
+// activeSize returns the size of the active segment, or zero if the handle can't report it.
+func (l *FileTransactionLogger) activeSize() (int64, error) {
+	stater, ok := l.file.(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return 0, nil
+	}
+
+	info, err := stater.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat active segment: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// roll seals the active segment and starts a new one for the events that follow.
+func (c *committer) roll() error {
+	s := &c.logger.segments
+
+	// a sealed segment is never written to again, so it must be complete on disk before we move on from it
+	if err := c.sync(); err != nil {
+		return err
+	}
+
+	base := c.logger.lastSequence.Load() + 1
+	next, err := os.OpenFile(segmentPath(s.dir, base), os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_EXCL, segmentPerm)
+	if err != nil {
+		return fmt.Errorf("failed to create segment: %w", err)
+	}
+
+	if err = disk.SyncDir(s.dir); err != nil {
+		_ = next.Close()
+		return err
+	}
+
+	previous := c.logger.file
+	c.logger.file = next
+	s.bases = append(s.bases, base)
+	c.segmentSize = 0
+	c.segmentOpened = time.Now()
+
+	if err = previous.Close(); err != nil {
+		slog.Warn("failed to close sealed segment", slog.Any("error", err))
+	}
+
+	slog.Info("rolled transaction log segment", slog.Uint64("base", base))
+	return nil
+}
+
+// compactSegments discards every sealed segment whose events are all at or below through. The active segment is always
+// kept, and any covered events left in it are skipped on replay by WithStartSequence.
+func (c *committer) compactSegments(through uint64) error {
+	s := &c.logger.segments
+
+	discarded := 0
+	// a segment is covered once the segment after it starts no later than the first event the snapshot doesn't cover
+	for len(s.bases) > 1 && s.bases[1] <= through+1 {
+		if err := s.discard(s.bases[0]); err != nil {
+			return err
+		}
+		s.bases = s.bases[1:]
+		discarded++
+	}
+
+	if discarded == 0 {
+		return nil
+	}
+
+	slog.Info("compacted transaction log", slog.Uint64("through", through), slog.Int("segments", discarded))
+
+	if s.archiveDir != "" {
+		if err := disk.SyncDir(s.archiveDir); err != nil {
+			return err
+		}
+	}
+	return disk.SyncDir(s.dir)
+}
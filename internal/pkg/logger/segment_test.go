@@ -0,0 +1,436 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openSegmentedLog opens the segmented log in dir and replays it, ready for Run
+func openSegmentedLog(t *testing.T, dir string, opts ...FileOption) (*FileTransactionLogger, []Event) {
+	t.Helper()
+
+	logger, err := NewSegmentedFileTransactionLogger(dir, opts...)
+	require.NoError(t, err)
+	// a no-op for loggers which have already been closed
+	t.Cleanup(func() {
+		_ = logger.file.Close()
+		_ = logger.segments.lock.Unlock()
+	})
+
+	events, err := collectEvents(t, logger)
+	require.NoError(t, err)
+
+	return logger, events
+}
+
+// segmentNames lists the segment files in dir
+func segmentNames(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), segmentSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+// putEvents builds the events written by writing key<n>/value<n> for each n in [from, to]
+func putEvents(from, to int) []Event {
+	var events []Event
+	for i := from; i <= to; i++ {
+		events = append(events, Event{
+			Sequence: uint64(i), //nolint:gosec // test sequences are small and positive
+			Kind:     EventPut,
+			Key:      fmt.Sprintf("key%d", i),
+			Value:    []byte(fmt.Sprintf("value%d", i)),
+		})
+	}
+	return events
+}
+
+// writePuts writes key<n>/value<n> for each n in [from, to]
+func writePuts(t *testing.T, logger *FileTransactionLogger, from, to int) {
+	t.Helper()
+
+	for i := from; i <= to; i++ {
+		require.NoError(t, writeErr(logger.WritePut(t.Context(), fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)))))
+	}
+}
+
+// TestNewSegmentedFileTransactionLogger tests that a new log starts with a single segment named after the first event
+// it will hold
+func TestNewSegmentedFileTransactionLogger(t *testing.T) {
+	t.Run("empty directory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "log")
+		logger, events := openSegmentedLog(t, dir)
+		require.NoError(t, logger.file.Close())
+
+		assert.Empty(t, events)
+		assert.Equal(t, []string{"00000000000000000001.log"}, segmentNames(t, dir))
+	})
+
+	t.Run("after a snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		logger, _ := openSegmentedLog(t, dir, WithDurableWrites(), WithStartSequence(41))
+		logger.Run()
+		writePuts(t, logger, 42, 42)
+		require.NoError(t, logger.Close())
+
+		assert.Equal(t, []string{"00000000000000000042.log"}, segmentNames(t, dir))
+
+		_, events := openSegmentedLog(t, dir, WithStartSequence(41))
+		assert.Equal(t, putEvents(42, 42), withoutTimestamps(events))
+	})
+}
+
+// TestSegmentedFileTransactionLogger_RollBySize tests that the log rolls over once a segment reaches its size limit and
+// that ReadEvents replays every segment in order
+func TestSegmentedFileTransactionLogger_RollBySize(t *testing.T) {
+	dir := t.TempDir()
+	record := appendRecord(nil, putEvents(1, 1)[0])
+
+	// every segment holds exactly two events
+	logger, _ := openSegmentedLog(t, dir, WithDurableWrites(), WithSegmentSize(int64(2*len(record))))
+	logger.Run()
+	writePuts(t, logger, 1, 5)
+	require.NoError(t, logger.Close())
+
+	expectedSegments := []string{
+		"00000000000000000001.log",
+		"00000000000000000003.log",
+		"00000000000000000005.log",
+	}
+	assert.Equal(t, expectedSegments, segmentNames(t, dir))
+
+	// the log carries on from the active segment when reopened
+	logger, events := openSegmentedLog(t, dir, WithDurableWrites(), WithSegmentSize(int64(2*len(record))))
+	assert.Equal(t, putEvents(1, 5), withoutTimestamps(events))
+
+	logger.Run()
+	writePuts(t, logger, 6, 7)
+	require.NoError(t, logger.Close())
+
+	assert.Equal(t, append(expectedSegments, "00000000000000000007.log"), segmentNames(t, dir))
+
+	_, events = openSegmentedLog(t, dir)
+	assert.Equal(t, putEvents(1, 7), withoutTimestamps(events))
+}
+
+// TestSegmentedFileTransactionLogger_RollByAge tests that the log rolls over on the first write after the active
+// segment comes of age, and never rolls an empty segment
+func TestSegmentedFileTransactionLogger_RollByAge(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		dir := t.TempDir()
+		logger, _ := openSegmentedLog(t, dir, WithDurableWrites(), WithSegmentAge(time.Hour))
+		logger.Run()
+
+		writePuts(t, logger, 1, 1)
+		time.Sleep(30 * time.Minute)
+		writePuts(t, logger, 2, 2)
+		assert.Len(t, segmentNames(t, dir), 1)
+
+		time.Sleep(30 * time.Minute)
+		writePuts(t, logger, 3, 3)
+		assert.Equal(t, []string{"00000000000000000001.log", "00000000000000000003.log"}, segmentNames(t, dir))
+
+		require.NoError(t, logger.Close())
+
+		_, events := openSegmentedLog(t, dir)
+		assert.Equal(t, putEvents(1, 3), withoutTimestamps(events))
+	})
+}
+
+// TestSegmentedFileTransactionLogger_Compact tests that compaction discards only the sealed segments which are wholly
+// covered by the snapshot
+func TestSegmentedFileTransactionLogger_Compact(t *testing.T) {
+	record := appendRecord(nil, putEvents(1, 1)[0])
+	opts := []FileOption{WithDurableWrites(), WithSegmentSize(int64(2 * len(record)))}
+
+	tests := []struct {
+		name     string
+		through  uint64
+		expected []string
+	}{
+		{
+			name:     "nothing covered",
+			through:  1,
+			expected: []string{"00000000000000000001.log", "00000000000000000003.log", "00000000000000000005.log"},
+		},
+		{
+			name:     "first segment covered",
+			through:  2,
+			expected: []string{"00000000000000000003.log", "00000000000000000005.log"},
+		},
+		{
+			name:     "active segment is kept",
+			through:  5,
+			expected: []string{"00000000000000000005.log"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			logger, _ := openSegmentedLog(t, dir, opts...)
+			logger.Run()
+			writePuts(t, logger, 1, 5)
+
+			require.NoError(t, logger.Compact(tc.through))
+			assert.Equal(t, tc.expected, segmentNames(t, dir))
+
+			// the log carries on writing after compaction
+			writePuts(t, logger, 6, 6)
+			require.NoError(t, logger.Close())
+
+			_, events := openSegmentedLog(t, dir, WithStartSequence(tc.through))
+			assert.Equal(t, putEvents(int(tc.through)+1, 6), withoutTimestamps(events)) //nolint:gosec // test sequences are small
+		})
+	}
+}
+
+// TestSegmentedFileTransactionLogger_Archive tests that compaction moves covered segments into the archive directory
+func TestSegmentedFileTransactionLogger_Archive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "archive")
+	record := appendRecord(nil, putEvents(1, 1)[0])
+
+	logger, _ := openSegmentedLog(t, dir,
+		WithDurableWrites(),
+		WithSegmentSize(int64(2*len(record))),
+		WithSegmentArchive(archive),
+	)
+	logger.Run()
+	writePuts(t, logger, 1, 5)
+	require.NoError(t, logger.Compact(4))
+	require.NoError(t, logger.Close())
+
+	assert.Equal(t, []string{"00000000000000000005.log"}, segmentNames(t, dir))
+	assert.Equal(t, []string{"00000000000000000001.log", "00000000000000000003.log"}, segmentNames(t, archive))
+
+	// the archived segments are still a readable log
+	_, events := openSegmentedLog(t, archive)
+	assert.Equal(t, putEvents(1, 4), withoutTimestamps(events))
+}
+
+// TestSegmentedFileTransactionLogger_TornWrite tests that a torn write is only recoverable at the end of the active
+// segment
+func TestSegmentedFileTransactionLogger_TornWrite(t *testing.T) {
+	first := appendRecord(nil, putEvents(1, 1)[0])
+	second := appendRecord(nil, putEvents(2, 2)[0])
+	torn := second[:len(second)-3]
+
+	t.Run("active segment is repaired", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(segmentPath(dir, 1), first, 0o600))
+		require.NoError(t, os.WriteFile(segmentPath(dir, 2), torn, 0o600))
+
+		logger, events := openSegmentedLog(t, dir, WithDurableWrites(), WithRecoveryMode(RecoveryRepair))
+		assert.Equal(t, putEvents(1, 1), events)
+
+		logger.Run()
+		writePuts(t, logger, 2, 2)
+		require.NoError(t, logger.Close())
+
+		_, events = openSegmentedLog(t, dir)
+		assert.Equal(t, putEvents(1, 2), withoutTimestamps(events))
+	})
+
+	t.Run("sealed segment is corrupt", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(segmentPath(dir, 1), torn, 0o600))
+		require.NoError(t, os.WriteFile(segmentPath(dir, 2), second, 0o600))
+
+		logger, err := NewSegmentedFileTransactionLogger(dir, WithRecoveryMode(RecoveryRepair))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = logger.file.Close()
+			_ = logger.segments.lock.Unlock()
+		})
+
+		_, err = collectEvents(t, logger)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrTornWrite)
+		assert.ErrorContains(t, err, "00000000000000000001.log")
+
+		info, err := os.Stat(segmentPath(dir, 1))
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(torn)), info.Size(), "a sealed segment must never be truncated")
+	})
+}
+
+// shortFile is a segment whose writes stop half way through, as they would on a full disk
+type shortFile struct {
+	*os.File
+}
+
+func (f shortFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p[:len(p)/2])
+	return n, errors.Join(errors.New("no space left on device"), err)
+}
+
+// TestSegmentedFileTransactionLogger_FailedWrite tests that a batch which is only partly written is removed from the
+// log, so that a logger reopened on it carries on from the last event written
+func TestSegmentedFileTransactionLogger_FailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	logger, _ := openSegmentedLog(t, dir, WithDurableWrites())
+	logger.Run()
+	writePuts(t, logger, 1, 2)
+	require.NoError(t, logger.Close())
+
+	logger, _ = openSegmentedLog(t, dir, WithDurableWrites())
+	logger.file = shortFile{File: logger.file.(*os.File)}
+	logger.Run()
+
+	assert.ErrorContains(t, writeErr(logger.WritePut(t.Context(), "key3", []byte("value3"))), "no space left on device")
+	for range logger.Err() {
+	}
+	seq, err := logger.LastSequence()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), seq, "the failed batch's sequence numbers are given back")
+	require.NoError(t, logger.Close())
+
+	// reading strictly proves that nothing of the failed batch is left behind
+	logger, events := openSegmentedLog(t, dir, WithDurableWrites())
+	assert.Equal(t, putEvents(1, 2), withoutTimestamps(events))
+	logger.Run()
+	writePuts(t, logger, 3, 3)
+	require.NoError(t, logger.Close())
+
+	_, events = openSegmentedLog(t, dir)
+	assert.Equal(t, putEvents(1, 3), withoutTimestamps(events))
+}
+
+// TestMigrateToSegments tests that a single file log becomes the first segment of a segmented log
+func TestMigrateToSegments(t *testing.T) {
+	legacy := filepath.Join(t.TempDir(), "transaction.log")
+	require.NoError(t, os.WriteFile(legacy, []byte("1\t2\tkey1\tvalue1\n2\t2\tkey2\tvalue2\n"), 0o600))
+	dir := filepath.Join(t.TempDir(), "transactions")
+
+	require.NoError(t, MigrateToSegments(legacy, dir))
+	assert.NoFileExists(t, legacy)
+	assert.Equal(t, []string{"00000000000000000001.log"}, segmentNames(t, dir))
+
+	logger, events := openSegmentedLog(t, dir, WithDurableWrites())
+	assert.Equal(t, putEvents(1, 2), events)
+
+	logger.Run()
+	writePuts(t, logger, 3, 3)
+	require.NoError(t, logger.Close())
+
+	_, events = openSegmentedLog(t, dir)
+	assert.Equal(t, putEvents(1, 3), withoutTimestamps(events))
+
+	// there is nothing left to migrate
+	require.NoError(t, MigrateToSegments(legacy, dir))
+
+	// and a directory which already holds segments is left alone
+	require.NoError(t, os.WriteFile(legacy, nil, 0o600))
+	require.NoError(t, MigrateToSegments(legacy, dir))
+	assert.FileExists(t, legacy)
+	assert.Equal(t, []string{"00000000000000000001.log"}, segmentNames(t, dir))
+}
+
+// TestSegmentedFileTransactionLogger_Lock tests that only one logger at a time can hold a segmented log
+func TestSegmentedFileTransactionLogger_Lock(t *testing.T) {
+	dir := t.TempDir()
+
+	writer, _ := openSegmentedLog(t, dir)
+	writer.Run()
+
+	_, err := NewSegmentedFileTransactionLogger(dir)
+	require.ErrorIs(t, err, ErrLogLocked)
+
+	_, err = LockDir(dir)
+	require.ErrorIs(t, err, ErrLogLocked)
+
+	// the lock is given up when the writer closes
+	require.NoError(t, writer.Close())
+
+	lock, err := LockDir(dir)
+	require.NoError(t, err)
+
+	// and a lock taken up front is handed over to the logger
+	logger, _ := openSegmentedLog(t, dir, WithDirLock(lock))
+	logger.Run()
+	require.NoError(t, logger.Close())
+
+	// a logger which was only read from gives it up too
+	reader, _ := openSegmentedLog(t, dir)
+	require.NoError(t, reader.Close())
+
+	lock, err = LockDir(dir)
+	require.NoError(t, err)
+	require.NoError(t, lock.Unlock())
+}
+
+// TestSegmentedFileTransactionLogger_ReadRange tests that a ranged read returns only the events asked for, without
+// reading the segments before them
+func TestSegmentedFileTransactionLogger_ReadRange(t *testing.T) {
+	dir := t.TempDir()
+	record := appendRecord(nil, putEvents(1, 1)[0])
+
+	logger, _ := openSegmentedLog(t, dir, WithDurableWrites(), WithSegmentSize(int64(2*len(record))))
+	logger.Run()
+	writePuts(t, logger, 1, 7)
+	require.NoError(t, logger.Close())
+
+	// if the first segment were read the corruption would fail the read
+	require.NoError(t, os.WriteFile(segmentPath(dir, 1), []byte("garbage"), 0o600))
+
+	tests := []struct {
+		name     string
+		opts     []ReadOption
+		expected []Event
+	}{
+		{name: "after", opts: []ReadOption{ReadAfter(3)}, expected: putEvents(4, 7)},
+		{name: "after a segment boundary", opts: []ReadOption{ReadAfter(2)}, expected: putEvents(3, 7)},
+		{name: "through", opts: []ReadOption{ReadAfter(2), ReadThrough(5)}, expected: putEvents(3, 5)},
+		{name: "through the active segment", opts: []ReadOption{ReadAfter(4), ReadThrough(6)}, expected: putEvents(5, 6)},
+		{name: "empty", opts: []ReadOption{ReadAfter(7)}, expected: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger, err := NewSegmentedFileTransactionLogger(dir)
+			require.NoError(t, err)
+			defer func() {
+				_ = logger.file.Close()
+				_ = logger.segments.lock.Unlock()
+			}()
+
+			var events []Event
+			eventChan, errChan := logger.ReadEvents(t.Context(), tc.opts...)
+			for e := range eventChan {
+				events = append(events, e)
+			}
+			require.NoError(t, <-errChan)
+			assert.Equal(t, tc.expected, withoutTimestamps(events))
+		})
+	}
+
+	t.Run("from the start", func(t *testing.T) {
+		logger, err := NewSegmentedFileTransactionLogger(dir)
+		require.NoError(t, err)
+		defer func() {
+			_ = logger.file.Close()
+			_ = logger.segments.lock.Unlock()
+		}()
+
+		_, err = collectEvents(t, logger)
+		assert.Error(t, err, "the corrupted segment is still part of the log")
+	})
+}
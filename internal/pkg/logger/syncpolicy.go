@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"fmt"
+	"time"
+)
+
+type syncMode byte
+
+const (
+	syncNever syncMode = iota
+	syncAlways
+	syncInterval
+	syncEveryN
+)
+
+// SyncPolicy decides when the FileTransactionLogger fsyncs the log. Syncing is what makes a write survive a power
+// loss rather than just a process crash, but it is also by far the most expensive part of a write, so the policy
+// trades durability against throughput.
+type SyncPolicy struct {
+	mode     syncMode
+	interval time.Duration
+	events   int
+}
+
+// SyncNever leaves flushing to the operating system. This is the default.
+func SyncNever() SyncPolicy {
+	return SyncPolicy{mode: syncNever}
+}
+
+// SyncAlways fsyncs after every batch of events, so every acknowledged durable write is on disk.
+func SyncAlways() SyncPolicy {
+	return SyncPolicy{mode: syncAlways}
+}
+
+// SyncInterval fsyncs at most once per interval, bounding how much acknowledged data a power loss can lose by time.
+func SyncInterval(interval time.Duration) SyncPolicy {
+	return SyncPolicy{mode: syncInterval, interval: interval}
+}
+
+// SyncEveryN fsyncs once at least n events have been written since the last sync, bounding how much acknowledged data
+// a power loss can lose by count.
+func SyncEveryN(n int) SyncPolicy {
+	return SyncPolicy{mode: syncEveryN, events: n}
+}
+
+func (p SyncPolicy) String() string {
+	switch p.mode {
+	case syncNever:
+		return "never"
+	case syncAlways:
+		return "always"
+	case syncInterval:
+		return fmt.Sprintf("every %s", p.interval)
+	case syncEveryN:
+		return fmt.Sprintf("every %d events", p.events)
+	default:
+		return fmt.Sprintf("SyncPolicy(%d)", p.mode)
+	}
+}
+
+// due reports whether a sync is needed after a batch, given the number of events written and the time elapsed since
+// the last sync.
+func (p SyncPolicy) due(unsynced int, sinceLast time.Duration) bool {
+	if unsynced == 0 {
+		return false
+	}
+
+	switch p.mode {
+	case syncAlways:
+		return true
+	case syncInterval:
+		return sinceLast >= p.interval
+	case syncEveryN:
+		return unsynced >= p.events
+	case syncNever:
+		return false
+	default:
+		return false
+	}
+}
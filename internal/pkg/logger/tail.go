@@ -0,0 +1,151 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// compile time assertion that SegmentTailer is an EventSource
+var _ EventSource = (*SegmentTailer)(nil)
+
+func NewSegmentTailer(dir string) *SegmentTailer {
+	return &SegmentTailer{dir: dir}
+}
+
+// SegmentTailer reads a segmented log while another process writes to it. It remembers where it got to, so each poll
+// only reads what has been appended since the last.
+type SegmentTailer struct {
+	dir string
+	// base and offset locate the end of the last complete record read, and last is the sequence number of that record
+	base   uint64
+	offset int64
+	last   uint64
+}
+
+// Poll calls fn with every complete event after the given sequence. A record which is still being written is left for
+// the next poll.
+func (t *SegmentTailer) Poll(ctx context.Context, after uint64, fn func(Event) error) error {
+	apply := fn
+	fn = func(e Event) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return apply(e)
+	}
+
+	if t.base == 0 || after != t.last {
+		found, err := t.seek(after)
+		if err != nil || !found {
+			return err
+		}
+	}
+
+	for {
+		if _, err := t.read(fn); err != nil {
+			return err
+		}
+
+		bases, err := listSegments(t.dir)
+		if err != nil {
+			return err
+		}
+
+		next, ok := segmentAfter(bases, t.base)
+		if !ok {
+			return nil
+		}
+
+		// the writer seals a segment before it starts the next one, so anything we raced with is there now
+		complete, err := t.read(fn)
+		if err != nil {
+			return err
+		}
+		if !complete {
+			return fmt.Errorf("failed to read sealed segment %s: %w", filepath.Base(segmentPath(t.dir, t.base)), ErrTornWrite)
+		}
+
+		t.base, t.offset = next, 0
+	}
+}
+
+// seek positions the tailer at the start of the segment holding the event after the given sequence. It reports false
+// if the log has no segments yet.
+func (t *SegmentTailer) seek(after uint64) (bool, error) {
+	bases, err := listSegments(t.dir)
+	if err != nil {
+		return false, err
+	}
+	if len(bases) == 0 {
+		return false, nil
+	}
+
+	if bases[0] > after+1 {
+		return false, fmt.Errorf("%w: log starts at %d, after %d was requested", ErrCompacted, bases[0], after)
+	}
+
+	t.base = bases[0]
+	for _, base := range bases {
+		if base <= after+1 {
+			t.base = base
+		}
+	}
+	t.offset, t.last = 0, after
+
+	return true, nil
+}
+
+// read calls fn with the events in the current segment past the offset, reporting whether it reached the end of the
+// segment rather than a record which is still being written.
+func (t *SegmentTailer) read(fn func(Event) error) (bool, error) {
+	path := segmentPath(t.dir, t.base)
+	file, err := os.Open(path) //nolint:gosec // path is a segment in the log directory we were given
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("%w: segment %s was removed while being read", ErrCompacted, filepath.Base(path))
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open segment: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	if _, err = file.Seek(t.offset, io.SeekStart); err != nil {
+		return false, fmt.Errorf("failed to seek in segment: %w", err)
+	}
+
+	start := t.offset
+	reader := newRecordReader(file)
+	for {
+		e, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if errors.Is(err, ErrTornWrite) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read segment %s: %w", filepath.Base(path), err)
+		}
+
+		if e.Sequence > t.last {
+			if err = fn(e); err != nil {
+				return false, err
+			}
+			t.last = e.Sequence
+		}
+		t.offset = start + reader.offset
+	}
+}
+
+// segmentAfter returns the first segment in bases after base.
+func segmentAfter(bases []uint64, base uint64) (uint64, bool) {
+	for _, b := range bases {
+		if b > base {
+			return b, true
+		}
+	}
+	return 0, false
+}
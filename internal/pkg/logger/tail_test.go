@@ -0,0 +1,127 @@
+package logger
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pollAll polls the tailer once, returning the events it read
+func pollAll(t *testing.T, tailer *SegmentTailer, after uint64) ([]Event, error) {
+	t.Helper()
+
+	var events []Event
+	err := tailer.Poll(t.Context(), after, func(e Event) error {
+		events = append(events, e)
+		return nil
+	})
+	return events, err
+}
+
+// TestSegmentTailer_FollowsWriter tests that the tailer picks up each batch of writes, across segment rolls
+func TestSegmentTailer_FollowsWriter(t *testing.T) {
+	dir := t.TempDir()
+	record := appendRecord(nil, putEvents(1, 1)[0])
+
+	writer, _ := openSegmentedLog(t, dir, WithDurableWrites(), WithSegmentSize(int64(2*len(record))))
+	writer.Run()
+	defer func() { require.NoError(t, writer.Close()) }()
+
+	tailer := NewSegmentTailer(dir)
+
+	events, err := pollAll(t, tailer, 0)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	writePuts(t, writer, 1, 3)
+	events, err = pollAll(t, tailer, 0)
+	require.NoError(t, err)
+	assert.Equal(t, putEvents(1, 3), withoutTimestamps(events))
+
+	writePuts(t, writer, 4, 7)
+	events, err = pollAll(t, tailer, 3)
+	require.NoError(t, err)
+	assert.Equal(t, putEvents(4, 7), withoutTimestamps(events))
+
+	// a fresh tailer can start part way through the log
+	events, err = pollAll(t, NewSegmentTailer(dir), 4)
+	require.NoError(t, err)
+	assert.Equal(t, putEvents(5, 7), withoutTimestamps(events))
+}
+
+// TestSegmentTailer_PartialRecord tests that a record which is still being written is picked up once it is complete
+func TestSegmentTailer_PartialRecord(t *testing.T) {
+	dir := t.TempDir()
+	first := appendRecord(nil, putEvents(1, 1)[0])
+	second := appendRecord(nil, putEvents(2, 2)[0])
+	half := len(second) / 2
+
+	require.NoError(t, os.WriteFile(segmentPath(dir, 1), append(first, second[:half]...), 0o600))
+
+	tailer := NewSegmentTailer(dir)
+	events, err := pollAll(t, tailer, 0)
+	require.NoError(t, err)
+	assert.Equal(t, putEvents(1, 1), events)
+
+	file, err := os.OpenFile(segmentPath(dir, 1), os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = file.Write(second[half:])
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	events, err = pollAll(t, tailer, 1)
+	require.NoError(t, err)
+	assert.Equal(t, putEvents(2, 2), events)
+}
+
+// TestSegmentTailer_SealedTornWrite tests that an incomplete record in a segment which has been rolled is an error
+func TestSegmentTailer_SealedTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	first := appendRecord(nil, putEvents(1, 1)[0])
+	second := appendRecord(nil, putEvents(2, 2)[0])
+
+	require.NoError(t, os.WriteFile(segmentPath(dir, 1), append(first, second[:len(second)/2]...), 0o600))
+	require.NoError(t, os.WriteFile(segmentPath(dir, 3), appendRecord(nil, putEvents(3, 3)[0]), 0o600))
+
+	events, err := pollAll(t, NewSegmentTailer(dir), 0)
+	require.ErrorIs(t, err, ErrTornWrite)
+	assert.Equal(t, putEvents(1, 1), events)
+}
+
+// TestSegmentTailer_Compacted tests that a tailer which has fallen behind compaction reports ErrCompacted
+func TestSegmentTailer_Compacted(t *testing.T) {
+	dir := t.TempDir()
+	record := appendRecord(nil, putEvents(1, 1)[0])
+
+	writer, _ := openSegmentedLog(t, dir, WithDurableWrites(), WithSegmentSize(int64(2*len(record))))
+	writer.Run()
+	defer func() { require.NoError(t, writer.Close()) }()
+
+	writePuts(t, writer, 1, 5)
+
+	// this tailer is part way through the first segment when it is compacted away
+	lagging := NewSegmentTailer(dir)
+	var seen int
+	err := lagging.Poll(t.Context(), 0, func(Event) error {
+		seen++
+		if seen == 2 {
+			return assert.AnError
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, assert.AnError)
+
+	require.NoError(t, writer.Compact(4))
+
+	_, err = pollAll(t, lagging, 1)
+	assert.ErrorIs(t, err, ErrCompacted, "a tailer whose segment was removed should need a snapshot")
+
+	_, err = pollAll(t, NewSegmentTailer(dir), 0)
+	assert.ErrorIs(t, err, ErrCompacted, "a tailer starting before the log should need a snapshot")
+
+	events, err := pollAll(t, NewSegmentTailer(dir), 4)
+	require.NoError(t, err)
+	assert.Equal(t, putEvents(5, 5), withoutTimestamps(events))
+}
@@ -1,15 +1,256 @@
 package logger
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrLogStopped is returned for writes made after the logger has stopped, which for a FileTransactionLogger happens
+// at the first error it reports on Err.
+var ErrLogStopped = errors.New("transaction log has stopped")
+
+// TransactionLog records mutations to the store. In the default asynchronous mode a write returns as soon as it has
+// been queued, while in durable mode it blocks until the write has been flushed by the logger and reports the outcome.
+// A write fails with the context's error if the context is done before the write has been queued.
+//
+// A durable write returns the sequence number the event was given, which is the order it is replayed in. An
+// asynchronous write returns zero, as its event isn't given one until after the write has returned.
+//
+// WriteBatch records ops, made with PutOp and DeleteOp, as a single EventBatch, which takes a single sequence number and
+// so is replayed all or nothing.
 type TransactionLog interface {
-	WritePut(key, value string)
-	WriteDelete(key string)
+	WritePut(ctx context.Context, key string, value []byte, opts ...WriteOption) (uint64, error)
+	WriteDelete(ctx context.Context, key string) (uint64, error)
+	WriteBatch(ctx context.Context, ops []Event) (uint64, error)
 }
 
 type TransactionManager interface {
 	TransactionLog
 
 	Run()
-	ReadEvents() (<-chan Event, <-chan error)
+	// ReadEvents reads the events in the log in order, narrowed by ReadAfter and ReadThrough. The read stops early,
+	// reporting the context's error, if the context is done first.
+	ReadEvents(ctx context.Context, opts ...ReadOption) (<-chan Event, <-chan error)
 	Err() <-chan error
 	Close() error
+
+	// LastSequence returns the sequence number of the most recent event in the log.
+	LastSequence() (uint64, error)
+	// Compact discards every event with a sequence number at or below through, once they are covered by a snapshot.
+	Compact(through uint64) error
+}
+
+// WriteOption adds detail to the event recorded by WritePut, or to an op made with PutOp.
+type WriteOption = func(*Event)
+
+// PutOp returns the event recording a put of key, with opts applied, which is also what WriteBatch takes for a put.
+func PutOp(key string, value []byte, opts ...WriteOption) Event {
+	e := Event{Kind: EventPut, Key: key, Value: value}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	return e
+}
+
+// DeleteOp returns the event recording a delete of key, which is also what WriteBatch takes for a delete.
+func DeleteOp(key string) Event {
+	return Event{Kind: EventDelete, Key: key}
+}
+
+// batchEvent returns the event recording ops as a batch. It fails if there are no ops or any op isn't a put or delete.
+func batchEvent(ops []Event) (Event, error) {
+	if len(ops) == 0 {
+		return Event{}, errors.New("batch has no ops")
+	}
+	for i, op := range ops {
+		if op.Kind != EventPut && op.Kind != EventDelete {
+			return Event{}, fmt.Errorf("batch op %d has kind %d, which is neither a put nor a delete", i, op.Kind)
+		}
+	}
+	return Event{Kind: EventBatch, Batch: ops}, nil
+}
+
+// ExpiresAt records that the key being put expires at t, so that replaying the log doesn't bring it back afterwards.
+func ExpiresAt(t time.Time) WriteOption {
+	return func(e *Event) {
+		e.Expires = t
+	}
+}
+
+// WithContentType records the media type of the value being put.
+func WithContentType(contentType string) WriteOption {
+	return func(e *Event) {
+		e.ContentType = contentType
+	}
+}
+
+// WithMetadata records the user metadata stored alongside the value being put.
+func WithMetadata(metadata map[string]string) WriteOption {
+	return func(e *Event) {
+		e.Metadata = metadata
+	}
+}
+
+// ReadOption narrows the events returned by ReadEvents.
+type ReadOption = func(*readRange)
+
+// readRange is the span of sequence numbers a read returns, after being exclusive and through inclusive. A zero
+// through means the read carries on to the end of the log.
+type readRange struct {
+	after   uint64
+	through uint64
+}
+
+func newReadRange(opts ...ReadOption) readRange {
+	var r readRange
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return r
+}
+
+// ReadAfter makes ReadEvents start with the first event after seq, skipping as much of the log as it can without
+// reading it.
+func ReadAfter(seq uint64) ReadOption {
+	return func(r *readRange) {
+		r.after = seq
+	}
+}
+
+// ReadThrough makes ReadEvents stop once it has returned the event at seq, with zero meaning the end of the log. The
+// rest of the log is left unread, so a FileTransactionLogger which is going to be written to must not use it: it
+// wouldn't know where the log ends.
+func ReadThrough(seq uint64) ReadOption {
+	return func(r *readRange) {
+		r.through = seq
+	}
+}
+
+// beyond reports whether seq is past the end of the range.
+func (r readRange) beyond(seq uint64) bool {
+	return r.through != 0 && seq > r.through
+}
+
+// send sends e to a reader on out, unless ctx is done first.
+func send(ctx context.Context, out chan<- Event, e Event) error {
+	select {
+	case out <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pendingEvent is an event queued for the writer goroutine. For durable writes ack receives the outcome once the event
+// has been written.
+type pendingEvent struct {
+	Event
+	// ctx is the context of the write which queued the event, which the writer may give up on it for
+	ctx context.Context //nolint:containedctx // the event carries its write's context across to the writer goroutine
+	ack chan<- outcome
+}
+
+// outcome is what a durable writer is told once its event has been written: the sequence number it was given, or the
+// error which stopped it being written.
+type outcome struct {
+	sequence uint64
+	err      error
+}
+
+// sendGuard stops a logger's events channel being closed while a write is sending on it, which would panic. Writes hold
+// it shared while they send, and Close holds it exclusively to close the channel, after which writes are turned away
+// with ErrLogStopped.
+type sendGuard struct {
+	mu     sync.RWMutex
+	closed bool
+}
+
+// closeEvents closes events once no write is sending on it, unless it has been closed already.
+func (g *sendGuard) closeEvents(events chan<- pendingEvent) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.closed {
+		g.closed = true
+		close(events)
+	}
+}
+
+// send queues p on events for the writer, unless events has been closed or done is closed or ctx is done first.
+func (g *sendGuard) send(ctx context.Context, events chan<- pendingEvent, done <-chan struct{}, p pendingEvent) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.closed {
+		return ErrLogStopped
+	}
+
+	select {
+	case events <- p:
+		return nil
+	case <-done:
+		return ErrLogStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// submit queues e for the writer and, if durable, waits for it to be acknowledged. It fails with ErrLogStopped rather
+// than blocking once done is closed, as nothing will read the queue or acknowledge the event any more, and with ctx's
+// error if ctx is done before e has been queued.
+//
+// Once queued the event is on its way to the log, so a durable write waits for the outcome even if ctx is done in the
+// meantime, leaving it to the writer to give up on the event for it. An asynchronous write outlives its context, which
+// the writer is given without its cancellation.
+func submit(ctx context.Context, guard *sendGuard, events chan<- pendingEvent, done <-chan struct{}, e Event, durable bool) (uint64, error) {
+	select {
+	case <-done:
+		return 0, ErrLogStopped
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var ack chan outcome
+	writeCtx := ctx
+	if durable {
+		ack = make(chan outcome, 1)
+	} else {
+		writeCtx = context.WithoutCancel(ctx)
+	}
+
+	if err := guard.send(ctx, events, done, pendingEvent{Event: e, ctx: writeCtx, ack: ack}); err != nil {
+		return 0, err
+	}
+	if !durable {
+		return 0, nil
+	}
+
+	select {
+	case o := <-ack:
+		return o.sequence, o.err
+	case <-done:
+		// the writer may have acknowledged the event on its way out
+		select {
+		case o := <-ack:
+			return o.sequence, o.err
+		default:
+			return 0, ErrLogStopped
+		}
+	}
+}
+
+// acknowledge reports the outcome of writing p, which was given p.Sequence unless err is set, to a waiting durable
+// writer, if there is one.
+func (p pendingEvent) acknowledge(err error) {
+	if p.ack == nil {
+		return
+	}
+	if err != nil {
+		p.ack <- outcome{err: err}
+		return
+	}
+	p.ack <- outcome{sequence: p.Sequence}
 }
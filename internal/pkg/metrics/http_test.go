@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHTTPMetrics tests that requests are counted by route template, method and status code
+func TestHTTPMetrics(t *testing.T) {
+	m := NewHTTPMetrics(prometheus.NewRegistry())
+
+	r := mux.NewRouter()
+	r.Use(m.Middleware)
+	r.HandleFunc("/v1/{key}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}).Methods(http.MethodPut)
+	r.HandleFunc("/v1/{key}", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("value"))
+	}).Methods(http.MethodGet)
+
+	for _, path := range []string{"/v1/key1", "/v1/key2"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, path, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/key1", nil))
+
+	assert.InDelta(t, 2, testutil.ToFloat64(m.requests.WithLabelValues("/v1/{key}", http.MethodPut, "201")), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.requests.WithLabelValues("/v1/{key}", http.MethodGet, "200")), 0)
+	assert.Equal(t, 2, testutil.CollectAndCount(m.duration))
+	assert.InDelta(t, 0, testutil.ToFloat64(m.inFlight), 0)
+}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/treyburn/lockbox/internal/pkg/logger"
+)
+
+// LogMetrics records writes to, reads from and failures of a transaction log. A replica may replace its log, for
+// instance when it takes over as the writer, so the same LogMetrics instruments each log in turn.
+type LogMetrics struct {
+	writes        *prometheus.CounterVec
+	writeDuration *prometheus.HistogramVec
+	errors        prometheus.Counter
+	eventsRead    prometheus.Counter
+	readDuration  prometheus.Histogram
+	// pending counts the events queued for the running log, if it can
+	pending atomic.Pointer[func() int]
+}
+
+// NewLogMetrics returns LogMetrics registered with reg.
+func NewLogMetrics(reg prometheus.Registerer) *LogMetrics {
+	m := &LogMetrics{
+		writes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "transaction_log",
+			Name:      "writes_total",
+			Help:      "Events written to the transaction log, by kind and result.",
+		}, []string{"kind", "result"}),
+		writeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "transaction_log",
+			Name:      "write_duration_seconds",
+			Help:      "Time taken to write an event, which for asynchronous writes is only the time taken to queue it.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"kind"}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "transaction_log",
+			Name:      "errors_total",
+			Help:      "Errors reported by the transaction log in the background.",
+		}),
+		eventsRead: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "transaction_log",
+			Name:      "events_read_total",
+			Help:      "Events read back from the transaction log.",
+		}),
+		readDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "transaction_log",
+			Name:      "read_duration_seconds",
+			Help:      "Time taken to read through the transaction log, such as when replaying it at startup.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+		}),
+	}
+
+	pending := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "transaction_log",
+		Name:      "pending_events",
+		Help:      "Events queued for the transaction log which haven't been written yet.",
+	}, func() float64 {
+		if count := m.pending.Load(); count != nil {
+			return float64((*count)())
+		}
+		return 0
+	})
+
+	reg.MustRegister(m.writes, m.writeDuration, m.errors, m.eventsRead, m.readDuration, pending)
+	return m
+}
+
+// Instrument returns log decorated to record its metrics. The events queued for it are reported too once it is running,
+// if it can count them.
+func (m *LogMetrics) Instrument(log logger.TransactionManager) logger.TransactionManager {
+	return &instrumentedLog{TransactionManager: log, metrics: m}
+}
+
+// observeWrite records a write of kind which started at start and ended with err.
+func (m *LogMetrics) observeWrite(kind string, start time.Time, err error) {
+	m.writeDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+
+	result := resultOK
+	if err != nil {
+		result = resultError
+	}
+	m.writes.WithLabelValues(kind, result).Inc()
+}
+
+type instrumentedLog struct {
+	logger.TransactionManager
+
+	metrics *LogMetrics
+	errOnce sync.Once
+	errs    <-chan error
+}
+
+func (l *instrumentedLog) WritePut(
+	ctx context.Context, key string, value []byte, opts ...logger.WriteOption,
+) (uint64, error) {
+	start := time.Now()
+	seq, err := l.TransactionManager.WritePut(ctx, key, value, opts...)
+	l.metrics.observeWrite("put", start, err)
+	return seq, err
+}
+
+func (l *instrumentedLog) WriteDelete(ctx context.Context, key string) (uint64, error) {
+	start := time.Now()
+	seq, err := l.TransactionManager.WriteDelete(ctx, key)
+	l.metrics.observeWrite("delete", start, err)
+	return seq, err
+}
+
+func (l *instrumentedLog) WriteBatch(ctx context.Context, ops []logger.Event) (uint64, error) {
+	start := time.Now()
+	seq, err := l.TransactionManager.WriteBatch(ctx, ops)
+	l.metrics.observeWrite("batch", start, err)
+	return seq, err
+}
+
+func (l *instrumentedLog) Run() {
+	l.TransactionManager.Run()
+	if counted, ok := l.TransactionManager.(interface{ Pending() int }); ok {
+		count := counted.Pending
+		l.metrics.pending.Store(&count)
+	}
+}
+
+// Err counts the errors reported by the log on their way through. As with the log itself, it must be called after Run.
+func (l *instrumentedLog) Err() <-chan error {
+	l.errOnce.Do(func() {
+		inner := l.TransactionManager.Err()
+		errs := make(chan error, 1)
+		l.errs = errs
+
+		go func() {
+			defer close(errs)
+			for err := range inner {
+				l.metrics.errors.Inc()
+				errs <- err
+			}
+		}()
+	})
+	return l.errs
+}
+
+// ReadEvents counts the events read, and records how long it took to read them all.
+func (l *instrumentedLog) ReadEvents(ctx context.Context, opts ...logger.ReadOption) (<-chan logger.Event, <-chan error) {
+	start := time.Now()
+	inner, errs := l.TransactionManager.ReadEvents(ctx, opts...)
+
+	events := make(chan logger.Event)
+	go func() {
+		defer close(events)
+		for e := range inner {
+			select {
+			case events <- e:
+				l.metrics.eventsRead.Inc()
+			case <-ctx.Done():
+				// the inner read is stopping too, and reports why
+			}
+		}
+		l.metrics.readDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	return events, errs
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/treyburn/lockbox/internal/pkg/logger"
+)
+
+// fakeLog is a transaction log holding events to read back, which fails every write
+type fakeLog struct {
+	logger.TransactionManager
+
+	events  []logger.Event
+	errs    chan error
+	pending int
+}
+
+func (f *fakeLog) Run() {}
+
+func (f *fakeLog) WritePut(_ context.Context, _ string, _ []byte, _ ...logger.WriteOption) (uint64, error) {
+	return 0, errors.New("disk full")
+}
+
+func (f *fakeLog) WriteDelete(_ context.Context, _ string) (uint64, error) { return 1, nil }
+
+func (f *fakeLog) WriteBatch(_ context.Context, _ []logger.Event) (uint64, error) { return 2, nil }
+
+func (f *fakeLog) Err() <-chan error { return f.errs }
+
+func (f *fakeLog) Pending() int { return f.pending }
+
+func (f *fakeLog) ReadEvents(context.Context, ...logger.ReadOption) (<-chan logger.Event, <-chan error) {
+	events := make(chan logger.Event)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(events)
+		defer close(errs)
+		for _, e := range f.events {
+			events <- e
+		}
+	}()
+	return events, errs
+}
+
+// pendingEvents is the exposition of the pending events gauge at n
+func pendingEvents(n int) io.Reader {
+	return strings.NewReader(fmt.Sprintf(`
+# HELP lockbox_transaction_log_pending_events Events queued for the transaction log which haven't been written yet.
+# TYPE lockbox_transaction_log_pending_events gauge
+lockbox_transaction_log_pending_events %d
+`, n))
+}
+
+// TestLogMetrics tests that writes, reads and errors are counted, and that the pending events are reported once the
+// log is running
+func TestLogMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewLogMetrics(reg)
+	fake := &fakeLog{
+		events:  []logger.Event{{Sequence: 1}, {Sequence: 2}},
+		errs:    make(chan error, 1),
+		pending: 3,
+	}
+	log := m.Instrument(fake)
+
+	events, errs := log.ReadEvents(t.Context())
+	var read []logger.Event
+	for e := range events {
+		read = append(read, e)
+	}
+	require.NoError(t, <-errs)
+	assert.Equal(t, fake.events, read)
+	assert.InDelta(t, 2, testutil.ToFloat64(m.eventsRead), 0)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, pendingEvents(0), "lockbox_transaction_log_pending_events"))
+	log.Run()
+	assert.NoError(t, testutil.GatherAndCompare(reg, pendingEvents(3), "lockbox_transaction_log_pending_events"))
+
+	_, err := log.WritePut(t.Context(), "key", []byte("value"))
+	require.Error(t, err)
+	seq, err := log.WriteDelete(t.Context(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+	seq, err = log.WriteBatch(t.Context(), []logger.Event{logger.DeleteOp("key")})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), seq)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.writes.WithLabelValues("put", resultError)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.writes.WithLabelValues("delete", resultOK)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.writes.WithLabelValues("batch", resultOK)), 0)
+
+	fake.errs <- errors.New("disk full")
+	close(fake.errs)
+	assert.EqualError(t, <-log.Err(), "disk full")
+	_, open := <-log.Err()
+	assert.False(t, open, "Err is closed along with the log's")
+	assert.InDelta(t, 1, testutil.ToFloat64(m.errors), 0)
+	assert.Equal(t, 1, testutil.CollectAndCount(m.readDuration))
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+// Results of an operation, used as the result label.
+const (
+	resultOK       = "ok"
+	resultNotFound = "not_found"
+	resultConflict = "conflict"
+	resultError    = "error"
+)
+
+// StoreMetrics records the operations made on a store.
+type StoreMetrics struct {
+	operations *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	// len counts the keys in the instrumented store, if it can
+	len atomic.Pointer[func() int]
+}
+
+// NewStoreMetrics returns StoreMetrics registered with reg.
+func NewStoreMetrics(reg prometheus.Registerer) *StoreMetrics {
+	m := &StoreMetrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "operations_total",
+			Help:      "Operations on the store, by operation and result.",
+		}, []string{"operation", "result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "operation_duration_seconds",
+			Help:      "Time taken by operations on the store.",
+			// the store is in memory, so operations take from around a microsecond up to however long they wait on a lock
+			Buckets: prometheus.ExponentialBuckets(0.000001, 4, 10),
+		}, []string{"operation"}),
+	}
+
+	keys := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "keys",
+		Help:      "Keys in the store.",
+	}, func() float64 {
+		if count := m.len.Load(); count != nil {
+			return float64((*count)())
+		}
+		return 0
+	})
+
+	reg.MustRegister(m.operations, m.duration, keys)
+	return m
+}
+
+// Instrument returns s decorated to record its operations. The number of keys is reported too if s can count them, as
+// an InMemoryStore can.
+func (m *StoreMetrics) Instrument(s store.Store) store.Store {
+	if counted, ok := s.(interface{ Len() int }); ok {
+		count := counted.Len
+		m.len.Store(&count)
+	}
+	return &instrumentedStore{Store: s, metrics: m}
+}
+
+// observe records an operation which started at start and ended with err.
+func (m *StoreMetrics) observe(operation string, start time.Time, err error) {
+	m.duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+
+	result := resultOK
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		result = resultNotFound
+	case errors.Is(err, store.ErrVersionMismatch):
+		result = resultConflict
+	case err != nil:
+		result = resultError
+	}
+	m.operations.WithLabelValues(operation, result).Inc()
+}
+
+type instrumentedStore struct {
+	store.Store
+
+	metrics *StoreMetrics
+}
+
+func (s *instrumentedStore) Put(ctx context.Context, key string, value []byte, opts ...store.WriteOption) error {
+	start := time.Now()
+	err := s.Store.Put(ctx, key, value, opts...)
+	s.metrics.observe("put", start, err)
+	return err
+}
+
+func (s *instrumentedStore) Get(ctx context.Context, key string) (store.Entry, error) {
+	start := time.Now()
+	entry, err := s.Store.Get(ctx, key)
+	s.metrics.observe("get", start, err)
+	return entry, err
+}
+
+func (s *instrumentedStore) GetMany(ctx context.Context, keys []string) (map[string]store.Entry, error) {
+	start := time.Now()
+	entries, err := s.Store.GetMany(ctx, keys)
+	s.metrics.observe("get_many", start, err)
+	return entries, err
+}
+
+func (s *instrumentedStore) Delete(ctx context.Context, key string, opts ...store.WriteOption) error {
+	start := time.Now()
+	err := s.Store.Delete(ctx, key, opts...)
+	s.metrics.observe("delete", start, err)
+	return err
+}
+
+func (s *instrumentedStore) List(ctx context.Context, opts ...store.ListOption) ([]store.Item, error) {
+	start := time.Now()
+	items, err := s.Store.List(ctx, opts...)
+	s.metrics.observe("list", start, err)
+	return items, err
+}
+
+func (s *instrumentedStore) Batch(ctx context.Context, ops []store.Op, opts ...store.WriteOption) error {
+	start := time.Now()
+	err := s.Store.Batch(ctx, ops, opts...)
+	s.metrics.observe("batch", start, err)
+	return err
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+// TestStoreMetrics tests that operations are counted by result and that the keys in the store are reported
+func TestStoreMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewStoreMetrics(reg)
+	s := m.Instrument(store.NewInMemoryStore())
+
+	require.NoError(t, s.Put(t.Context(), "key1", []byte("value1")))
+	require.NoError(t, s.Put(t.Context(), "key2", []byte("value2")))
+	_, err := s.Get(t.Context(), "key1")
+	require.NoError(t, err)
+	_, err = s.Get(t.Context(), "missing")
+	require.ErrorIs(t, err, store.ErrNotFound)
+	require.ErrorIs(t, s.Put(t.Context(), "key1", []byte("value3"), store.IfVersion(0)), store.ErrVersionMismatch)
+	require.NoError(t, s.Delete(t.Context(), "key2"))
+	_, err = s.List(t.Context())
+	require.NoError(t, err)
+	require.NoError(t, s.Batch(t.Context(), []store.Op{store.PutOp("key3", []byte("value3"))}))
+	_, err = s.GetMany(t.Context(), []string{"key1", "missing"})
+	require.NoError(t, err)
+
+	assert.InDelta(t, 2, testutil.ToFloat64(m.operations.WithLabelValues("put", resultOK)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.operations.WithLabelValues("put", resultConflict)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.operations.WithLabelValues("get", resultOK)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.operations.WithLabelValues("get", resultNotFound)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.operations.WithLabelValues("delete", resultOK)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.operations.WithLabelValues("list", resultOK)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.operations.WithLabelValues("batch", resultOK)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.operations.WithLabelValues("get_many", resultOK)), 0)
+	assert.Equal(t, 6, testutil.CollectAndCount(m.duration))
+
+	expected := `
+# HELP lockbox_store_keys Keys in the store.
+# TYPE lockbox_store_keys gauge
+lockbox_store_keys 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "lockbox_store_keys"))
+}
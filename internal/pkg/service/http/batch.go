@@ -0,0 +1,116 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxBatchOps is the most ops a batch may hold.
+const maxBatchOps = 1000
+
+var errInvalidBatch = errors.New("invalid batch")
+
+// batchRequest is the body of a batch.
+type batchRequest struct {
+	Ops []batchOp `json:"ops"`
+}
+
+// batchOp is a put or delete in the body of a batch. A put's value is given either as text or in base64. TTL is given
+// as for TTLHeader, and IfVersion makes the op conditional on the key being at that version, as given by its ETag, or
+// on it not existing when zero.
+type batchOp struct {
+	JSONValue
+
+	Op        string  `json:"op"`
+	Key       string  `json:"key"`
+	TTL       string  `json:"ttl,omitempty"`
+	IfVersion *uint64 `json:"if_version,omitempty"`
+}
+
+// batchWrite is a batchOp once it has been checked.
+type batchWrite struct {
+	key    string
+	value  []byte
+	delete bool
+	ttl    time.Duration
+	cond   precondition
+}
+
+// readBatch reads and checks the ops of a batch from its body. Each key may only be written once.
+func readBatch(body io.Reader) ([]batchWrite, error) {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	var req batchRequest
+	if err := decoder.Decode(&req); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidBatch, err)
+	}
+	if len(req.Ops) == 0 || len(req.Ops) > maxBatchOps {
+		return nil, fmt.Errorf("%w: must have between 1 and %d ops, not %d", errInvalidBatch, maxBatchOps, len(req.Ops))
+	}
+
+	writes := make([]batchWrite, 0, len(req.Ops))
+	seen := make(map[string]bool, len(req.Ops))
+	for i, op := range req.Ops {
+		write, err := op.check()
+		if err != nil {
+			return nil, fmt.Errorf("%w: op %d: %w", errInvalidBatch, i, err)
+		}
+		if seen[write.key] {
+			return nil, fmt.Errorf("%w: op %d: key %q is written more than once", errInvalidBatch, i, write.key)
+		}
+		seen[write.key] = true
+		writes = append(writes, write)
+	}
+	return writes, nil
+}
+
+// check checks op and returns the write it makes.
+func (op batchOp) check() (batchWrite, error) {
+	if op.Key == "" {
+		return batchWrite{}, errors.New("missing key")
+	}
+	write := batchWrite{key: op.Key}
+
+	switch op.Op {
+	case "put":
+		value, err := op.bytes()
+		if err != nil {
+			return batchWrite{}, fmt.Errorf("put %w", err)
+		}
+		write.value = value
+		if op.TTL != "" {
+			ttl, err := parseTTL(op.TTL)
+			if err != nil {
+				return batchWrite{}, err
+			}
+			write.ttl = ttl
+		}
+	case "delete":
+		if op.given() || op.TTL != "" {
+			return batchWrite{}, errors.New("delete can't have a value or ttl")
+		}
+		write.delete = true
+	default:
+		return batchWrite{}, fmt.Errorf("op %q is neither put nor delete", op.Op)
+	}
+
+	write.cond = versionPrecondition(op.IfVersion)
+	return write, nil
+}
+
+// versionPrecondition returns the precondition that a key is at version, or doesn't exist if version is zero, which
+// is what the If-Match or If-None-Match header of a single write would say. A nil version is no precondition at all.
+func versionPrecondition(version *uint64) precondition {
+	switch {
+	case version == nil:
+		return precondition{}
+	case *version == 0:
+		return precondition{noneMatch: []string{"*"}}
+	default:
+		return precondition{match: []string{etag(*version)}}
+	}
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReadBatch tests reading and checking the ops of a batch from its body
+func TestReadBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []batchWrite
+		wantErr string
+	}{
+		{
+			name: "put and delete",
+			body: `{"ops": [{"op": "put", "key": "a", "value": "1", "ttl": "5m"}, {"op": "delete", "key": "b"}]}`,
+			want: []batchWrite{{key: "a", value: []byte("1"), ttl: 5 * time.Minute}, {key: "b", delete: true}},
+		},
+		{
+			name: "empty value",
+			body: `{"ops": [{"op": "put", "key": "a", "value": ""}]}`,
+			want: []batchWrite{{key: "a", value: []byte{}}},
+		},
+		{
+			name: "versions",
+			body: `{"ops": [{"op": "put", "key": "a", "value": "1", "if_version": 3}, {"op": "delete", "key": "b", "if_version": 0}]}`,
+			want: []batchWrite{
+				{key: "a", value: []byte("1"), cond: precondition{match: []string{`"3"`}}},
+				{key: "b", delete: true, cond: precondition{noneMatch: []string{"*"}}},
+			},
+		},
+		{
+			name: "binary value",
+			body: `{"ops": [{"op": "put", "key": "a", "value_base64": "/wA="}]}`,
+			want: []batchWrite{{key: "a", value: []byte{0xff, 0x00}}},
+		},
+		{name: "both values", body: `{"ops": [{"op": "put", "key": "a", "value": "1", "value_base64": "MQ=="}]}`, wantErr: "has both"},
+		{name: "delete with binary value", body: `{"ops": [{"op": "delete", "key": "a", "value_base64": "MQ=="}]}`, wantErr: "can't have"},
+		{name: "not json", body: `put a 1`, wantErr: "invalid character"},
+		{name: "unknown field", body: `{"ops": [{"op": "put", "key": "a", "value": "1", "if_match": "3"}]}`, wantErr: "unknown field"},
+		{name: "no ops", body: `{"ops": []}`, wantErr: "between 1 and"},
+		{name: "missing key", body: `{"ops": [{"op": "delete"}]}`, wantErr: "missing key"},
+		{name: "missing value", body: `{"ops": [{"op": "put", "key": "a"}]}`, wantErr: "no value"},
+		{name: "delete with value", body: `{"ops": [{"op": "delete", "key": "a", "value": "1"}]}`, wantErr: "can't have"},
+		{name: "invalid ttl", body: `{"ops": [{"op": "put", "key": "a", "value": "1", "ttl": "-1s"}]}`, wantErr: "invalid ttl"},
+		{name: "unknown op", body: `{"ops": [{"op": "get", "key": "a"}]}`, wantErr: "neither put nor delete"},
+		{
+			name:    "repeated key",
+			body:    `{"ops": [{"op": "put", "key": "a", "value": "1"}, {"op": "delete", "key": "a"}]}`,
+			wantErr: "more than once",
+		},
+		{
+			name:    "too many ops",
+			body:    `{"ops": [` + strings.Repeat(`{"op": "delete", "key": "a"},`, maxBatchOps) + `{"op": "delete", "key": "b"}]}`,
+			wantErr: fmt.Sprintf("not %d", maxBatchOps+1),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			writes, err := readBatch(strings.NewReader(tc.body))
+			if tc.wantErr != "" {
+				require.ErrorIs(t, err, errInvalidBatch)
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, writes)
+		})
+	}
+}
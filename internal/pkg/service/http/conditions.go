@@ -0,0 +1,88 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+var (
+	errInvalidPrecondition = errors.New("invalid precondition")
+	// errUnstableVersions is reported for a conditional write to a service made WithAsyncWrites.
+	errUnstableVersions = errors.New("conditional writes need durable writes")
+)
+
+// etag returns the entity tag of a key at version.
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// precondition is what the If-Match and If-None-Match headers of a write require of the key it writes. Each holds the
+// entity tags listed by its header, or "*" for any, and is nil if the header wasn't given.
+type precondition struct {
+	match     []string
+	noneMatch []string
+}
+
+// requestPrecondition returns the precondition of the write r.
+func requestPrecondition(r *http.Request) (precondition, error) {
+	var p precondition
+	var err error
+	if p.match, err = entityTags(r.Header.Values("If-Match")); err != nil {
+		return precondition{}, fmt.Errorf("%w: If-Match: %w", errInvalidPrecondition, err)
+	}
+	if p.noneMatch, err = entityTags(r.Header.Values("If-None-Match")); err != nil {
+		return precondition{}, fmt.Errorf("%w: If-None-Match: %w", errInvalidPrecondition, err)
+	}
+	return p, nil
+}
+
+// entityTags parses the comma separated entity tags of a header's values.
+func entityTags(values []string) ([]string, error) {
+	var tags []string
+	for _, value := range values {
+		for tag := range strings.SplitSeq(value, ",") {
+			tag = strings.TrimSpace(tag)
+			opaque := strings.TrimPrefix(tag, "W/")
+			if tag != "*" && (len(opaque) < 2 || opaque[0] != '"' || opaque[len(opaque)-1] != '"') {
+				return nil, fmt.Errorf("malformed entity tag %q", tag)
+			}
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// conditional reports whether the write has a precondition at all.
+func (p precondition) conditional() bool {
+	return p.match != nil || p.noneMatch != nil
+}
+
+// holds reports whether the precondition holds for the key, which is at entry if it exists. If-Match compares entity
+// tags strongly and If-None-Match weakly, so only If-None-Match matches a weak tag.
+func (p precondition) holds(entry store.Entry, exists bool) bool {
+	current := etag(entry.Version)
+
+	if p.match != nil {
+		matched := false
+		for _, tag := range p.match {
+			if exists && (tag == "*" || tag == current) {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, tag := range p.noneMatch {
+		if exists && (tag == "*" || strings.TrimPrefix(tag, "W/") == current) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+// TestRequestPrecondition tests reading the If-Match and If-None-Match headers of a write and checking them against
+// the key it writes
+func TestRequestPrecondition(t *testing.T) {
+	current := store.Entry{Value: []byte("some-value"), Version: 3}
+
+	tests := []struct {
+		name        string
+		match       string
+		noneMatch   string
+		wantErr     bool
+		conditional bool
+		holds       bool
+		holdsAbsent bool
+	}{
+		{name: "none", holds: true, holdsAbsent: true},
+		{name: "match current", match: `"3"`, conditional: true, holds: true},
+		{name: "match list", match: `"1", "3"`, conditional: true, holds: true},
+		{name: "match stale", match: `"2"`, conditional: true},
+		{name: "match weak", match: `W/"3"`, conditional: true},
+		{name: "match any", match: "*", conditional: true, holds: true},
+		{name: "none match any", noneMatch: "*", conditional: true, holdsAbsent: true},
+		{name: "none match current", noneMatch: `"3"`, conditional: true, holdsAbsent: true},
+		{name: "none match weak", noneMatch: `W/"3"`, conditional: true, holdsAbsent: true},
+		{name: "none match stale", noneMatch: `"2"`, conditional: true, holds: true, holdsAbsent: true},
+		{name: "both", match: `"3"`, noneMatch: `"2"`, conditional: true, holds: true},
+		{name: "unquoted", match: "3", wantErr: true},
+		{name: "empty tag", noneMatch: `"3",`, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPut, "/v1/some-key", nil)
+			if tc.match != "" {
+				request.Header.Set("If-Match", tc.match)
+			}
+			if tc.noneMatch != "" {
+				request.Header.Set("If-None-Match", tc.noneMatch)
+			}
+
+			p, err := requestPrecondition(request)
+			if tc.wantErr {
+				require.ErrorIs(t, err, errInvalidPrecondition)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.conditional, p.conditional())
+			assert.Equal(t, tc.holds, p.holds(current, true))
+			assert.Equal(t, tc.holdsAbsent, p.holds(store.Entry{}, false))
+		})
+	}
+}
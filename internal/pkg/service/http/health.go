@@ -0,0 +1,188 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkTimeout bounds how long a readiness probe waits on any one check.
+const checkTimeout = 2 * time.Second
+
+// Health states reported by the health endpoints.
+const (
+	StatusOK          = "ok"
+	StatusStarting    = "starting"
+	StatusUnavailable = "unavailable"
+)
+
+// Check reports whether a dependency of the service is usable, returning nil if it is.
+type Check = func(ctx context.Context) error
+
+func NewHealth() *Health {
+	return &Health{checks: make(map[string]Check), writeChecks: make(map[string]Check)}
+}
+
+// Health tracks whether this replica is able to serve, for the liveness, readiness and writability endpoints. A replica
+// is live for as long as it can answer, ready once it has started, while every check passes, and writable while it is
+// ready and every write check passes too.
+type Health struct {
+	started atomic.Bool
+	// sequence is how far through the transaction log startup has got
+	sequence atomic.Uint64
+
+	mu          sync.Mutex
+	checks      map[string]Check
+	writeChecks map[string]Check
+}
+
+// Progress records the sequence number of the last event replayed while starting.
+func (h *Health) Progress(seq uint64) {
+	h.sequence.Store(seq)
+}
+
+// Started marks the replica as having finished starting, from which point it can serve requests.
+func (h *Health) Started() {
+	h.started.Store(true)
+}
+
+// AddCheck adds a check which must pass for the replica to be ready, replacing any check with the same name.
+func (h *Health) AddCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = check
+}
+
+// RemoveCheck removes the check added under name.
+func (h *Health) RemoveCheck(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.checks, name)
+}
+
+// AddWriteCheck adds a check which must pass, on top of those for readiness, for the replica to take writes, replacing
+// any write check with the same name.
+func (h *Health) AddWriteCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeChecks[name] = check
+}
+
+// RemoveWriteCheck removes the write check added under name.
+func (h *Health) RemoveWriteCheck(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.writeChecks, name)
+}
+
+// healthResponse is the body of the health endpoints.
+type healthResponse struct {
+	Status string `json:"status"`
+	// Sequence is the last event replayed, while the replica is starting
+	Sequence uint64            `json:"sequence,omitempty"`
+	Checks   map[string]string `json:"checks,omitempty"`
+}
+
+// Live is the liveness endpoint. Failures which the replica can recover from, such as the transaction log going into
+// degraded mode, are left to readiness so that it isn't restarted while repairing them.
+func (h *Health) Live(w http.ResponseWriter, _ *http.Request) {
+	writeHealth(w, healthResponse{Status: StatusOK})
+}
+
+// Ready is the readiness endpoint, which fails while the replica is starting or any check is failing, so that the load
+// balancer stops sending it requests.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	h.report(w, r, false)
+}
+
+// Writable is the endpoint the load balancer routes writes by, which fails unless the replica is ready and every write
+// check passes too, such as while another replica is the transaction log's writer.
+func (h *Health) Writable(w http.ResponseWriter, r *http.Request) {
+	h.report(w, r, true)
+}
+
+// report runs the checks and writes their outcome, including the write checks if writes is set.
+func (h *Health) report(w http.ResponseWriter, r *http.Request, writes bool) {
+	if !h.started.Load() {
+		writeHealth(w, healthResponse{Status: StatusStarting, Sequence: h.sequence.Load()})
+		return
+	}
+
+	h.mu.Lock()
+	checks := maps.Clone(h.checks)
+	if writes {
+		maps.Copy(checks, h.writeChecks)
+	}
+	h.mu.Unlock()
+
+	results := make(map[string]error, len(checks))
+	for _, name := range slices.Sorted(maps.Keys(checks)) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		results[name] = checks[name](ctx)
+		cancel()
+	}
+
+	response := healthResponse{Status: StatusOK, Checks: describe(results)}
+	for _, err := range results {
+		if err != nil {
+			response.Status = StatusUnavailable
+		}
+	}
+	writeHealth(w, response)
+}
+
+// RequireStarted is middleware which turns requests away until the replica has started, so that nothing is read from
+// the store before it has been rebuilt from the transaction log.
+func (h *Health) RequireStarted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.started.Load() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "service is starting", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// describe returns the outcome of each check, as "ok" or its error.
+func describe(results map[string]error) map[string]string {
+	if len(results) == 0 {
+		return nil
+	}
+
+	described := make(map[string]string, len(results))
+	for name, err := range results {
+		described[name] = StatusOK
+		if err != nil {
+			described[name] = err.Error()
+		}
+	}
+	return described
+}
+
+func writeHealth(w http.ResponseWriter, response healthResponse) {
+	status := http.StatusOK
+	if response.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode health: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if _, err = w.Write(body); err != nil {
+		slog.Error("failed to write response", slog.Any("error", err))
+	}
+}
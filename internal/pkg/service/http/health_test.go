@@ -0,0 +1,126 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// probe calls a health endpoint and decodes its response
+func probe(t *testing.T, endpoint http.HandlerFunc) (int, healthResponse) {
+	t.Helper()
+
+	response := httptest.NewRecorder()
+	endpoint(response, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var body healthResponse
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	return response.Code, body
+}
+
+// TestHealth_Starting tests that a replica which is still replaying is live but not ready, and reports its progress
+func TestHealth_Starting(t *testing.T) {
+	health := NewHealth()
+	health.Progress(42)
+
+	code, body := probe(t, health.Live)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthResponse{Status: StatusOK}, body)
+
+	code, body = probe(t, health.Ready)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthResponse{Status: StatusStarting, Sequence: 42}, body)
+
+	health.Started()
+	code, body = probe(t, health.Ready)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthResponse{Status: StatusOK}, body)
+}
+
+// TestHealth_Checks tests that a failing check makes the replica unready without affecting liveness
+func TestHealth_Checks(t *testing.T) {
+	health := NewHealth()
+	health.Started()
+
+	var dbErr error
+	health.AddCheck("postgres", func(context.Context) error { return dbErr })
+	health.AddCheck("follower", func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok, "checks are given a deadline")
+		return nil
+	})
+
+	code, body := probe(t, health.Ready)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"postgres": StatusOK, "follower": StatusOK}, body.Checks)
+
+	dbErr = errors.New("connection refused")
+	code, body = probe(t, health.Ready)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusUnavailable, body.Status)
+	assert.Equal(t, "connection refused", body.Checks["postgres"])
+
+	code, _ = probe(t, health.Live)
+	assert.Equal(t, http.StatusOK, code)
+
+	health.RemoveCheck("postgres")
+	code, body = probe(t, health.Ready)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"follower": StatusOK}, body.Checks)
+}
+
+// TestHealth_Writable tests that a replica is only writable while it is ready and every write check passes, and that
+// write checks don't affect readiness
+func TestHealth_Writable(t *testing.T) {
+	health := NewHealth()
+
+	code, body := probe(t, health.Writable)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusStarting, body.Status)
+
+	health.Started()
+	var dbErr error
+	health.AddCheck("postgres", func(context.Context) error { return dbErr })
+	health.AddWriteCheck("writer", func(context.Context) error { return errors.New("following another replica") })
+
+	code, body = probe(t, health.Ready)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"postgres": StatusOK}, body.Checks)
+
+	code, body = probe(t, health.Writable)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]string{"postgres": StatusOK, "writer": "following another replica"}, body.Checks)
+
+	health.RemoveWriteCheck("writer")
+	code, _ = probe(t, health.Writable)
+	assert.Equal(t, http.StatusOK, code)
+
+	dbErr = errors.New("connection refused")
+	code, body = probe(t, health.Writable)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "connection refused", body.Checks["postgres"])
+}
+
+// TestHealth_RequireStarted tests that requests are turned away until the replica has started
+func TestHealth_RequireStarted(t *testing.T) {
+	health := NewHealth()
+	handler := health.RequireStarted(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/v1/some-key", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Equal(t, "1", response.Header().Get("Retry-After"))
+
+	health.Started()
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/v1/some-key", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+}
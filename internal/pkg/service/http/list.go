@@ -0,0 +1,85 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+const (
+	// defaultListLimit is how many keys a listing returns when it isn't given a limit.
+	defaultListLimit = 100
+	// maxListLimit is the most keys a listing returns, whatever limit it's given.
+	maxListLimit = 1000
+)
+
+var errInvalidList = errors.New("invalid listing")
+
+// listRequest is what a listing asks for, from the prefix, cursor, limit and values query parameters.
+type listRequest struct {
+	prefix string
+	// cursor is the last key of the previous page, with the listing carrying on after it
+	cursor string
+	limit  int
+	// values is set when the listing returns the value of each key as well
+	values bool
+}
+
+// requestList returns the listing asked for by r.
+func requestList(r *http.Request) (listRequest, error) {
+	query := r.URL.Query()
+	l := listRequest{prefix: query.Get("prefix"), cursor: query.Get("cursor"), limit: defaultListLimit}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return listRequest{}, fmt.Errorf("%w: limit %q must be a positive number", errInvalidList, raw)
+		}
+		l.limit = min(limit, maxListLimit)
+	}
+
+	if raw := query.Get("values"); raw != "" {
+		values, err := strconv.ParseBool(raw)
+		if err != nil {
+			return listRequest{}, fmt.Errorf("%w: values %q must be true or false", errInvalidList, raw)
+		}
+		l.values = values
+	}
+	return l, nil
+}
+
+// listResponse is the body of a listing. Cursor is set while there are more keys to list, and is passed back as the
+// cursor parameter to get the next page.
+type listResponse struct {
+	Keys   []listItem `json:"keys"`
+	Cursor string     `json:"cursor,omitempty"`
+}
+
+// listItem is a key in a listing, along with its value if the listing asked for values.
+type listItem struct {
+	JSONValue
+
+	Key string `json:"key"`
+}
+
+// newListResponse returns the response to l given the items the store listed for it, which are one more than the
+// limit when there is another page to come.
+func newListResponse(l listRequest, items []store.Item) listResponse {
+	resp := listResponse{Keys: make([]listItem, 0, min(len(items), l.limit))}
+	if len(items) > l.limit {
+		items = items[:l.limit]
+		resp.Cursor = items[len(items)-1].Key
+	}
+
+	for _, item := range items {
+		entry := listItem{Key: item.Key}
+		if l.values {
+			entry.JSONValue = NewJSONValue(item.Value)
+		}
+		resp.Keys = append(resp.Keys, entry)
+	}
+	return resp
+}
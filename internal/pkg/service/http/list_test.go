@@ -0,0 +1,44 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRequestList tests reading a listing from its query parameters
+func TestRequestList(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    listRequest
+		wantErr bool
+	}{
+		{name: "none", want: listRequest{limit: defaultListLimit}},
+		{
+			name:  "all",
+			query: "?prefix=a/&cursor=a/b&limit=10&values=true",
+			want:  listRequest{prefix: "a/", cursor: "a/b", limit: 10, values: true},
+		},
+		{name: "limit capped", query: "?limit=100000", want: listRequest{limit: maxListLimit}},
+		{name: "zero limit", query: "?limit=0", wantErr: true},
+		{name: "negative limit", query: "?limit=-1", wantErr: true},
+		{name: "garbage limit", query: "?limit=lots", wantErr: true},
+		{name: "garbage values", query: "?values=please", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := requestList(httptest.NewRequest(http.MethodGet, "/v1"+tc.query, nil))
+			if tc.wantErr {
+				require.ErrorIs(t, err, errInvalidList)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, l)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/treyburn/lockbox/internal/pkg/logger"
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+const (
+	// MetadataHeaderPrefix begins the name of each header a PUT stores alongside the value as user metadata, and which
+	// GET and HEAD return it in. The rest of the header's name is the name of the field.
+	MetadataHeaderPrefix = "X-Lockbox-Meta-"
+	// maxMetadataSize is the most bytes the names and values of a key's metadata may take between them.
+	maxMetadataSize = 8 << 10
+	// defaultContentType is served for a key put without a Content-Type.
+	defaultContentType = "application/octet-stream"
+)
+
+var errInvalidMetadata = errors.New("invalid metadata")
+
+// description is what a PUT stores alongside its value to describe it: its media type, which is empty if the request
+// didn't give one, and the user metadata, which is nil if there is none.
+type description struct {
+	contentType string
+	metadata    map[string]string
+}
+
+// requestDescription returns the description of the value written by r, from its Content-Type and every header whose
+// name begins with MetadataHeaderPrefix. A field given more than once keeps all of its values, joined by commas.
+func requestDescription(r *http.Request) (description, error) {
+	var d description
+	if raw := r.Header.Get("Content-Type"); raw != "" {
+		mediaType, params, err := mime.ParseMediaType(raw)
+		if err != nil {
+			return description{}, fmt.Errorf("invalid Content-Type %q: %w", raw, err)
+		}
+		d.contentType = mime.FormatMediaType(mediaType, params)
+	}
+
+	size := 0
+	for name, values := range r.Header {
+		field, ok := strings.CutPrefix(name, MetadataHeaderPrefix)
+		if !ok {
+			continue
+		}
+		if field == "" {
+			return description{}, fmt.Errorf("%w: %s header has no field name", errInvalidMetadata, name)
+		}
+
+		value := strings.Join(values, ", ")
+		size += len(field) + len(value)
+		if size > maxMetadataSize {
+			return description{}, fmt.Errorf("%w: exceeds %d bytes", errInvalidMetadata, maxMetadataSize)
+		}
+		if d.metadata == nil {
+			d.metadata = make(map[string]string)
+		}
+		d.metadata[field] = value
+	}
+	return d, nil
+}
+
+// logOptions returns the options which record d in the transaction log.
+func (d description) logOptions() []logger.WriteOption {
+	return []logger.WriteOption{logger.WithContentType(d.contentType), logger.WithMetadata(d.metadata)}
+}
+
+// storeOptions returns the options which store d alongside the value.
+func (d description) storeOptions() []store.WriteOption {
+	return []store.WriteOption{store.WithContentType(d.contentType), store.WithMetadata(d.metadata)}
+}
+
+// setDescription sets the headers of a response, or of a part of one, holding the value of entry to its content type
+// and metadata.
+func setDescription(h http.Header, entry store.Entry) {
+	contentType := entry.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	h.Set("Content-Type", contentType)
+	for field, value := range entry.Metadata {
+		h.Set(MetadataHeaderPrefix+field, value)
+	}
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+// TestRequestDescription tests reading the content type and metadata of a put from its headers
+func TestRequestDescription(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  http.Header
+		want    description
+		wantErr bool
+	}{
+		{name: "none", header: http.Header{}},
+		{
+			name:   "content type",
+			header: http.Header{"Content-Type": {"Text/Plain; Charset=utf-8"}},
+			want:   description{contentType: "text/plain; charset=utf-8"},
+		},
+		{name: "invalid content type", header: http.Header{"Content-Type": {"text/"}}, wantErr: true},
+		{
+			name:   "metadata",
+			header: http.Header{"X-Lockbox-Meta-Owner": {"alice"}, "X-Lockbox-Meta-Tags": {"a", "b"}, "X-Other": {"x"}},
+			want:   description{metadata: map[string]string{"Owner": "alice", "Tags": "a, b"}},
+		},
+		{name: "no field name", header: http.Header{"X-Lockbox-Meta-": {"x"}}, wantErr: true},
+		{
+			name:    "too large",
+			header:  http.Header{"X-Lockbox-Meta-A": {strings.Repeat("a", 5000)}, "X-Lockbox-Meta-B": {strings.Repeat("b", 5000)}},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPut, "/v1/some-key", nil)
+			request.Header = tc.header
+
+			got, err := requestDescription(request)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+// TestSetDescription tests that a value is served as the type it was put with, or as bytes if it had none
+func TestSetDescription(t *testing.T) {
+	h := http.Header{}
+	setDescription(h, store.Entry{ContentType: "application/json", Metadata: map[string]string{"Owner": "alice"}})
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}, "X-Lockbox-Meta-Owner": {"alice"}}, h)
+
+	h = http.Header{}
+	setDescription(h, store.Entry{})
+	assert.Equal(t, http.Header{"Content-Type": {"application/octet-stream"}}, h)
+}
@@ -0,0 +1,135 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+const (
+	// maxMultiGetKeys is the most keys a multi-get may ask for.
+	maxMultiGetKeys = 1000
+	// MissingHeader marks the part of a multipart multi-get response for a key which wasn't found.
+	MissingHeader = "Lockbox-Missing"
+)
+
+var errInvalidMultiGet = errors.New("invalid multi-get")
+
+// multiGetRequest is the body of a multi-get.
+type multiGetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// readKeys reads the keys asked for by a multi-get from its body, without any repeats.
+func readKeys(body io.Reader) ([]string, error) {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	var req multiGetRequest
+	if err := decoder.Decode(&req); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidMultiGet, err)
+	}
+	if len(req.Keys) == 0 || len(req.Keys) > maxMultiGetKeys {
+		return nil, fmt.Errorf("%w: must have between 1 and %d keys, not %d",
+			errInvalidMultiGet, maxMultiGetKeys, len(req.Keys))
+	}
+
+	keys := make([]string, 0, len(req.Keys))
+	seen := make(map[string]bool, len(req.Keys))
+	for _, key := range req.Keys {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// wantsMultipart reports whether the client accepts a multipart/mixed response, which carries values exactly as they
+// were put where JSON can only carry them as UTF-8.
+func wantsMultipart(r *http.Request) bool {
+	for accepted := range strings.SplitSeq(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		if mediaType == "multipart/mixed" || mediaType == "multipart/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// multiGetResponse is the JSON body of a multi-get. Found holds the keys which were found in the order they were asked
+// for, and Missing the rest.
+type multiGetResponse struct {
+	Found   []multiGetItem `json:"found"`
+	Missing []string       `json:"missing"`
+}
+
+type multiGetItem struct {
+	JSONValue
+
+	Key  string `json:"key"`
+	ETag string `json:"etag"`
+}
+
+// writeMultiGetJSON writes the entries found for keys as a JSON multi-get response.
+func writeMultiGetJSON(w http.ResponseWriter, keys []string, entries map[string]store.Entry) error {
+	resp := multiGetResponse{Found: make([]multiGetItem, 0, len(entries)), Missing: []string{}}
+	for _, key := range keys {
+		entry, ok := entries[key]
+		if !ok {
+			resp.Missing = append(resp.Missing, key)
+			continue
+		}
+		resp.Found = append(resp.Found, multiGetItem{Key: key, JSONValue: NewJSONValue(entry.Value), ETag: etag(entry.Version)})
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("failed to encode multi-get: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	return err
+}
+
+// writeMultiGetMultipart writes the entries found for keys as a multipart/mixed multi-get response, with a part for
+// each key in the order they were asked for. The Content-Location of each part is the key's URL, and a key which wasn't
+// found has an empty part marked with MissingHeader.
+func writeMultiGetMultipart(w http.ResponseWriter, keys []string, entries map[string]store.Entry) error {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+
+	for _, key := range keys {
+		header := textproto.MIMEHeader{"Content-Location": {"/v1/" + url.PathEscape(key)}}
+		entry, ok := entries[key]
+		if ok {
+			setDescription(http.Header(header), entry)
+			header["Etag"] = []string{etag(entry.Version)}
+		} else {
+			header[MissingHeader] = []string{"true"}
+		}
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err = part.Write(entry.Value); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
@@ -0,0 +1,70 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReadKeys tests reading the keys of a multi-get from its body
+func TestReadKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr string
+	}{
+		{name: "keys", body: `{"keys": ["b", "a", ""]}`, want: []string{"b", "a", ""}},
+		{name: "repeats", body: `{"keys": ["a", "b", "a"]}`, want: []string{"a", "b"}},
+		{name: "not json", body: `a,b`, wantErr: "invalid character"},
+		{name: "unknown field", body: `{"key": ["a"]}`, wantErr: "unknown field"},
+		{name: "no keys", body: `{"keys": []}`, wantErr: "between 1 and"},
+		{
+			name:    "too many keys",
+			body:    `{"keys": [` + strings.Repeat(`"a",`, maxMultiGetKeys) + `"b"]}`,
+			wantErr: fmt.Sprintf("not %d", maxMultiGetKeys+1),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := readKeys(strings.NewReader(tc.body))
+			if tc.wantErr != "" {
+				require.ErrorIs(t, err, errInvalidMultiGet)
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, keys)
+		})
+	}
+}
+
+// TestWantsMultipart tests choosing a multipart response from the Accept header
+func TestWantsMultipart(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "application/json", want: false},
+		{accept: "multipart/mixed", want: true},
+		{accept: "application/json;q=0.5, multipart/mixed", want: true},
+		{accept: "multipart/*", want: true},
+		{accept: "multipart/mixed;q=0", want: false},
+		{accept: "*/*", want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.accept, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/v1/_multiget", nil)
+			request.Header.Set("Accept", tc.accept)
+			assert.Equal(t, tc.want, wantsMultipart(request))
+		})
+	}
+}
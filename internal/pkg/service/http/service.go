@@ -1,11 +1,18 @@
 package http
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/fnv"
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -13,37 +20,213 @@ import (
 	"github.com/treyburn/lockbox/internal/pkg/store"
 )
 
-func NewService(storage store.Store, logger logger.TransactionLog) *Service {
-	return &Service{
+// ErrDegraded is reported for writes while the service is in degraded mode.
+var ErrDegraded = errors.New("transaction log is unavailable")
+
+func NewService(storage store.Store, logger logger.TransactionLog, opts ...ServiceOption) *Service {
+	s := &Service{
 		storage: storage,
 		logger:  logger,
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
 type Service struct {
 	storage store.Store
 	logger  logger.TransactionLog
+	// writes is held shared by every write for as long as it takes to log and apply it, so that Quiesce can wait
+	// until the store and the transaction log agree with each other.
+	writes sync.RWMutex
+
+	// keys serialises the writes to each key, so that they reach the transaction log and the store in the same order
+	// and no other write made through this service lands between a write's precondition being checked and the write
+	// being logged
+	keys keyLocks
+
+	// async is set if the transaction log doesn't give a write its sequence number before acknowledging it
+	async bool
+
+	mu sync.Mutex
+	// degraded is why writes are being turned away, or nil while the transaction log is healthy
+	degraded error
+}
+
+type ServiceOption = func(*Service)
+
+// WithAsyncWrites tells the service that its transaction logs acknowledge a write before giving it a sequence number,
+// which leaves the store to pick the version of the key written. That version isn't the one the key has on other
+// replicas or once the log is replayed, so a conditional write, which compares against it, is rejected with 400.
+func WithAsyncWrites() ServiceOption {
+	return func(s *Service) {
+		s.async = true
+	}
+}
+
+// keyLockStripes is how many locks the keys are spread across. Writes to different keys only wait on each other when
+// they share a stripe.
+const keyLockStripes = 64
+
+// keyLocks is a set of locks, one of which covers each key.
+type keyLocks [keyLockStripes]sync.Mutex
+
+// lock locks key and returns the function which unlocks it.
+func (l *keyLocks) lock(key string) func() {
+	mu := &l[stripe(key)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockAll locks every key in keys and returns the function which unlocks them. The locks are taken in order, so that
+// writes locking overlapping sets of keys can't deadlock.
+func (l *keyLocks) lockAll(keys []string) func() {
+	stripes := make([]uint32, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, stripe(key))
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+
+	for _, i := range stripes {
+		l[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			l[i].Unlock()
+		}
+	}
+}
+
+// stripe returns which of the key locks covers key.
+func stripe(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32() % keyLockStripes
+}
+
+// Degrade puts the service into degraded mode, where writes are rejected with 503 rather than sent to a transaction log
+// which can't take them, until Recover is called. Reads carry on being served from the store.
+func (s *Service) Degrade(reason error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.degraded == nil {
+		slog.Error("transaction log failed, rejecting writes", slog.Any("error", reason))
+	}
+	s.degraded = reason
+}
+
+// Recover takes the service out of degraded mode.
+func (s *Service) Recover() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.degraded != nil {
+		slog.Info("transaction log recovered, accepting writes")
+	}
+	s.degraded = nil
+}
+
+// Degraded returns why the service is in degraded mode, wrapped in ErrDegraded, or nil if it isn't.
+func (s *Service) Degraded() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.degraded == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrDegraded, s.degraded)
+}
+
+// Monitor watches the transaction log's error stream. Each error puts the service into degraded mode, after which
+// repair is called every interval until it succeeds, bringing the service back. Monitor returns once errs is closed.
+func (s *Service) Monitor(errs <-chan error, repair func() error, interval time.Duration) {
+	for err := range errs {
+		s.Degrade(err)
+		for {
+			time.Sleep(interval)
+			if err = repair(); err == nil {
+				break
+			}
+			slog.Warn("failed to repair transaction log", slog.Any("error", err))
+		}
+		s.Recover()
+	}
+}
+
+// Quiesce blocks new writes, waits for in-flight writes to finish, and then runs fn. While fn runs, every event in the
+// transaction log has been applied to the store and nothing else is changing either, which is what a consistent
+// snapshot needs.
+func (s *Service) Quiesce(fn func() error) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	return fn()
+}
+
+// SetTransactionLog switches the transaction log that writes go to, once any in-flight writes have finished. A
+// replica which starts out following another's log uses it to start accepting writes when it takes over.
+func (s *Service) SetTransactionLog(log logger.TransactionLog) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	s.logger = log
+}
+
+// logErrorStatus returns the status for a write which couldn't be logged.
+func logErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, logger.ErrReadOnly):
+		// another replica is the writer, the client should retry and hopefully reach it
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrDegraded), errors.Is(err, logger.ErrLogStopped):
+		// the log is being repaired, the write can be retried once it has been
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeLog logs a write through fn unless the service is degraded, returning the sequence number it was logged with.
+// The caller must hold writes.
+func (s *Service) writeLog(fn func(logger.TransactionLog) (uint64, error)) (uint64, error) {
+	if err := s.Degraded(); err != nil {
+		return 0, err
+	}
+	return fn(s.logger)
 }
 
 func (s *Service) GetByKey(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
+	ctx, span := startSpan(r.Context(), "Service.GetByKey", key)
+	defer span.End()
 
-	value, err := s.storage.Get(key)
+	var entry store.Entry
+	err := traced(ctx, "Store.Get", func(ctx context.Context) error {
+		var err error
+		entry, err = s.storage.Get(ctx, key)
+		return err
+	})
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
 			w.WriteHeader(http.StatusNotFound)
 			slog.Warn("key not found", slog.String("key", strconv.Quote(key)))
 		} else {
+			failSpan(span, err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			slog.Error("failed to read key", slog.String("key", strconv.Quote(key)), slog.Any("error", err))
 		}
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
+	// the value is served as whatever type it was put with, so it mustn't be able to run script as the API's origin
+	setDescription(w.Header(), entry)
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(len(entry.Value)))
+	w.Header().Set("Etag", etag(entry.Version))
 	w.WriteHeader(http.StatusOK)
-	_, err = w.Write([]byte(value)) //nolint:gosec // Content-Type set to application/octet-stream which prevents XSS
+	_, err = w.Write(entry.Value) //nolint:gosec // served sandboxed by Content-Security-Policy, which prevents XSS
 	if err != nil {
 		slog.Error("failed to write response", slog.Any("error", err))
 		return
@@ -51,6 +234,131 @@ func (s *Service) GetByKey(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("retrieved key", slog.String("key", strconv.Quote(key)))
 }
 
+// GetMany reads every key asked for in the body of the request as they were at the same moment, responding with those
+// which were found and those which weren't, as JSON or, if the client accepts it, multipart/mixed.
+func (s *Service) GetMany(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			slog.Error("failed to close request body", slog.Any("error", err))
+		}
+	}()
+
+	keys, err := readKeys(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, span := startMultiGetSpan(r.Context(), len(keys))
+	defer span.End()
+
+	var entries map[string]store.Entry
+	err = traced(ctx, "Store.GetMany", func(ctx context.Context) error {
+		var err error
+		entries, err = s.storage.GetMany(ctx, keys)
+		return err
+	})
+	if err != nil {
+		failSpan(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("failed to read keys", slog.Int("keys", len(keys)), slog.Any("error", err))
+		return
+	}
+
+	if wantsMultipart(r) {
+		err = writeMultiGetMultipart(w, keys, entries)
+	} else {
+		err = writeMultiGetJSON(w, keys, entries)
+	}
+	if err != nil {
+		slog.Error("failed to write response", slog.Any("error", err))
+		return
+	}
+	slog.Debug("retrieved keys", slog.Int("keys", len(keys)), slog.Int("found", len(entries)))
+}
+
+// ListKeys lists the keys in the store in order, a page at a time.
+func (s *Service) ListKeys(w http.ResponseWriter, r *http.Request) {
+	l, err := requestList(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, span := startListSpan(r.Context(), l.prefix)
+	defer span.End()
+
+	var items []store.Item
+	err = traced(ctx, "Store.List", func(ctx context.Context) error {
+		var err error
+		// the extra item tells whether there is another page after this one
+		items, err = s.storage.List(ctx, store.WithPrefix(l.prefix), store.StartAfter(l.cursor), store.WithLimit(l.limit+1))
+		return err
+	})
+	if err != nil {
+		failSpan(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("failed to list keys", slog.String("prefix", strconv.Quote(l.prefix)), slog.Any("error", err))
+		return
+	}
+
+	listing := newListResponse(l, items)
+	body, err := json.Marshal(listing)
+	if err != nil {
+		failSpan(span, err)
+		http.Error(w, fmt.Sprintf("failed to encode listing: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(body); err != nil {
+		slog.Error("failed to write response", slog.Any("error", err))
+		return
+	}
+	slog.Debug("listed keys", slog.String("prefix", strconv.Quote(l.prefix)), slog.Int("keys", len(listing.Keys)))
+}
+
+// checkPrecondition checks the precondition of a write to key against the store, failing with store.ErrVersionMismatch
+// if it doesn't hold. It is only checked before the write is logged: once logged the write will be replayed whatever
+// the key is at by then, such as if it has since expired or a follower has written it, so the store must apply it
+// unconditionally to agree with the log. The caller must hold the key's lock.
+func (s *Service) checkPrecondition(ctx context.Context, key string, p precondition) error {
+	if !p.conditional() {
+		return nil
+	}
+	if s.async {
+		return errUnstableVersions
+	}
+
+	var entry store.Entry
+	err := traced(ctx, "Store.Get", func(ctx context.Context) error {
+		var err error
+		entry, err = s.storage.Get(ctx, key)
+		return err
+	})
+	exists := err == nil
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	if !p.holds(entry, exists) {
+		return fmt.Errorf("%w: precondition failed for key %q", store.ErrVersionMismatch, key)
+	}
+	return nil
+}
+
+// writeErrorStatus returns the status for a write which couldn't be applied to the store.
+func writeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, errUnstableVersions):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (s *Service) PutForKey(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		err := r.Body.Close()
@@ -60,39 +368,252 @@ func (s *Service) PutForKey(w http.ResponseWriter, r *http.Request) {
 	}()
 	vars := mux.Vars(r)
 	key := vars["key"]
+	ctx, span := startSpan(r.Context(), "Service.PutForKey", key)
+	defer span.End()
 
-	value, err := io.ReadAll(r.Body)
+	ttl, err := requestTTL(r)
 	if err != nil {
-		slog.Error("failed to read request", slog.Any("error", err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cond, err := requestPrecondition(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	desc, err := requestDescription(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = s.storage.Put(key, string(value))
+	value, err := io.ReadAll(r.Body)
 	if err != nil {
-		slog.Error("failed to store key", slog.Any("error", err))
+		failSpan(span, err)
+		slog.Error("failed to read request", slog.Any("error", err))
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	s.logger.WritePut(key, string(value))
+	if status, err := s.put(ctx, key, value, desc, ttl, cond); err != nil {
+		if !expected(err) {
+			failSpan(span, err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
 
 	w.WriteHeader(http.StatusCreated)
 	slog.Debug("stored key", slog.String("key", strconv.Quote(key)))
 }
 
+// put logs and then applies a put of key, described by desc, if cond holds, returning the status to report if it fails.
+func (s *Service) put(
+	ctx context.Context, key string, value []byte, desc description, ttl time.Duration, cond precondition,
+) (int, error) {
+	// the expiry is fixed before the write is logged, so that replaying the log expires the key at the same time
+	logOpts := desc.logOptions()
+	storeOpts := desc.storeOptions()
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		logOpts = append(logOpts, logger.ExpiresAt(expires))
+		storeOpts = append(storeOpts, store.ExpiresAt(expires))
+	}
+
+	defer s.keys.lock(key)()
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	// a write which fails its precondition is turned away before it is logged, leaving nothing to replay
+	if err := s.checkPrecondition(ctx, key, cond); err != nil {
+		slog.Warn("failed to check precondition", slog.String("key", strconv.Quote(key)), slog.Any("error", err))
+		return writeErrorStatus(err), err
+	}
+
+	// log before applying the change so that a write which can't be persisted is never visible to readers
+	var seq uint64
+	err := traced(ctx, "TransactionLog.WritePut", func(ctx context.Context) error {
+		var err error
+		seq, err = s.writeLog(func(log logger.TransactionLog) (uint64, error) {
+			return log.WritePut(ctx, key, value, logOpts...)
+		})
+		return err
+	})
+	if err != nil {
+		slog.Error("failed to log key", slog.Any("error", err))
+		return logErrorStatus(err), err
+	}
+	// the key takes the sequence number of its event as its version, so that it has the same version on every replica
+	// and once the log is replayed, whatever order writes to other keys were logged and applied in. An asynchronous
+	// write isn't given one until later, leaving seq zero and the store to pick the version.
+	storeOpts = append(storeOpts, store.WithVersion(seq))
+
+	// once logged the write will be replayed whatever happens, so the client going away mustn't stop it being applied
+	err = traced(context.WithoutCancel(ctx), "Store.Put", func(ctx context.Context) error {
+		return s.storage.Put(ctx, key, value, storeOpts...)
+	})
+	if err != nil {
+		slog.Error("failed to store key", slog.Any("error", err))
+		return writeErrorStatus(err), err
+	}
+	return 0, nil
+}
+
 func (s *Service) DeleteKey(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
+	ctx, span := startSpan(r.Context(), "Service.DeleteKey", key)
+	defer span.End()
 
-	err := s.storage.Delete(key)
+	cond, err := requestPrecondition(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		slog.Error("failed to delete key", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if status, err := s.delete(ctx, key, cond); err != nil {
+		if !expected(err) {
+			failSpan(span, err)
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	s.logger.WriteDelete(key)
 	w.WriteHeader(http.StatusAccepted)
 	slog.Debug("deleted key", slog.String("key", strconv.Quote(key)))
 }
+
+// delete logs and then applies a delete of key, if cond holds, returning the status to report if it fails.
+func (s *Service) delete(ctx context.Context, key string, cond precondition) (int, error) {
+	defer s.keys.lock(key)()
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	if err := s.checkPrecondition(ctx, key, cond); err != nil {
+		slog.Warn("failed to check precondition", slog.String("key", strconv.Quote(key)), slog.Any("error", err))
+		return writeErrorStatus(err), err
+	}
+
+	var seq uint64
+	err := traced(ctx, "TransactionLog.WriteDelete", func(ctx context.Context) error {
+		var err error
+		seq, err = s.writeLog(func(log logger.TransactionLog) (uint64, error) {
+			return log.WriteDelete(ctx, key)
+		})
+		return err
+	})
+	if err != nil {
+		slog.Error("failed to log key deletion", slog.Any("error", err))
+		return logErrorStatus(err), err
+	}
+	err = traced(context.WithoutCancel(ctx), "Store.Delete", func(ctx context.Context) error {
+		return s.storage.Delete(ctx, key, store.WithVersion(seq))
+	})
+	if err != nil {
+		slog.Error("failed to delete key", slog.Any("error", err))
+		return writeErrorStatus(err), err
+	}
+	return 0, nil
+}
+
+// WriteBatch applies the puts and deletes in the body of the request together, or none of them if the precondition of
+// any of them doesn't hold.
+func (s *Service) WriteBatch(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			slog.Error("failed to close request body", slog.Any("error", err))
+		}
+	}()
+
+	writes, err := readBatch(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, span := startBatchSpan(r.Context(), len(writes))
+	defer span.End()
+
+	if status, err := s.batch(ctx, writes); err != nil {
+		if !expected(err) {
+			failSpan(span, err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	slog.Debug("applied batch", slog.Int("ops", len(writes)))
+}
+
+// batch logs and then applies writes as a single batch, if all of their preconditions hold, returning the status to
+// report if it fails.
+func (s *Service) batch(ctx context.Context, writes []batchWrite) (int, error) {
+	keys := make([]string, 0, len(writes))
+	for _, write := range writes {
+		keys = append(keys, write.key)
+	}
+
+	defer s.keys.lockAll(keys)()
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+
+	logOps, storeOps, err := s.batchOps(ctx, writes)
+	if err != nil {
+		slog.Warn("failed to check precondition", slog.Any("error", err))
+		return writeErrorStatus(err), err
+	}
+
+	var seq uint64
+	err = traced(ctx, "TransactionLog.WriteBatch", func(ctx context.Context) error {
+		var err error
+		seq, err = s.writeLog(func(log logger.TransactionLog) (uint64, error) {
+			return log.WriteBatch(ctx, logOps)
+		})
+		return err
+	})
+	if err != nil {
+		slog.Error("failed to log batch", slog.Any("error", err))
+		return logErrorStatus(err), err
+	}
+
+	err = traced(context.WithoutCancel(ctx), "Store.Batch", func(ctx context.Context) error {
+		return s.storage.Batch(ctx, storeOps, store.WithVersion(seq))
+	})
+	if err != nil {
+		slog.Error("failed to apply batch", slog.Any("error", err))
+		return writeErrorStatus(err), err
+	}
+	return 0, nil
+}
+
+// batchOps checks the preconditions of writes and returns the ops which log and apply them. The caller must hold the
+// locks of every key written.
+func (s *Service) batchOps(ctx context.Context, writes []batchWrite) ([]logger.Event, []store.Op, error) {
+	// as for a single put, expiries are fixed before the batch is logged
+	now := time.Now()
+	logOps := make([]logger.Event, 0, len(writes))
+	storeOps := make([]store.Op, 0, len(writes))
+	for _, write := range writes {
+		if err := s.checkPrecondition(ctx, write.key, write.cond); err != nil {
+			return nil, nil, err
+		}
+
+		if write.delete {
+			logOps = append(logOps, logger.DeleteOp(write.key))
+			storeOps = append(storeOps, store.DeleteOp(write.key))
+			continue
+		}
+
+		var opts []store.WriteOption
+		var logOpts []logger.WriteOption
+		if write.ttl > 0 {
+			expires := now.Add(write.ttl)
+			logOpts = append(logOpts, logger.ExpiresAt(expires))
+			opts = append(opts, store.ExpiresAt(expires))
+		}
+		logOps = append(logOps, logger.PutOp(write.key, write.value, logOpts...))
+		storeOps = append(storeOps, store.PutOp(write.key, write.value, opts...))
+	}
+	return logOps, storeOps, nil
+}
@@ -1,38 +1,93 @@
 package http
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/synctest"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
+	"github.com/treyburn/lockbox/internal/pkg/logger"
 	"github.com/treyburn/lockbox/internal/pkg/store"
 )
 
 type mockTransactionLog struct {
 	mock.Mock
+
+	// expires is the expiry recorded by the most recent put
+	expires time.Time
+	// put is the event recorded by the most recent put
+	put logger.Event
+}
+
+// WritePut, like every write to the mock, returns the sequence number zero, as an asynchronous log does.
+func (m *mockTransactionLog) WritePut(
+	ctx context.Context, key string, value []byte, opts ...logger.WriteOption,
+) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var e logger.Event
+	for _, opt := range opts {
+		opt(&e)
+	}
+	m.expires = e.Expires
+	m.put = e
+	args := m.Called(key, value)
+	return 0, args.Error(0)
 }
 
-func (m *mockTransactionLog) WritePut(key, value string) {
-	m.Called(key, value)
+func (m *mockTransactionLog) WriteDelete(ctx context.Context, key string) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	args := m.Called(key)
+	return 0, args.Error(0)
 }
 
-func (m *mockTransactionLog) WriteDelete(key string) {
-	m.Called(key)
+func (m *mockTransactionLog) WriteBatch(ctx context.Context, ops []logger.Event) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	args := m.Called(ops)
+	return 0, args.Error(0)
 }
 
 type errorStore struct {
 	err error
 }
 
-func (e *errorStore) Get(_ string) (string, error) { return "", e.err }
-func (e *errorStore) Put(_, _ string) error        { return e.err }
-func (e *errorStore) Delete(_ string) error        { return e.err }
+func (e *errorStore) Get(_ context.Context, _ string) (store.Entry, error) {
+	return store.Entry{}, e.err
+}
+func (e *errorStore) GetMany(_ context.Context, _ []string) (map[string]store.Entry, error) {
+	return nil, e.err
+}
+func (e *errorStore) Put(_ context.Context, _ string, _ []byte, _ ...store.WriteOption) error {
+	return e.err
+}
+func (e *errorStore) Delete(_ context.Context, _ string, _ ...store.WriteOption) error { return e.err }
+func (e *errorStore) List(_ context.Context, _ ...store.ListOption) ([]store.Item, error) {
+	return nil, e.err
+}
+func (e *errorStore) Batch(_ context.Context, _ []store.Op, _ ...store.WriteOption) error {
+	return e.err
+}
 
 type errReader struct{}
 
@@ -53,6 +108,28 @@ func TestService_GetByKey(t *testing.T) {
 		svc.GetByKey(response, request)
 		assert.Equal(t, http.StatusOK, response.Code)
 		assert.Equal(t, "some-value", response.Body.String())
+		assert.Equal(t, "application/octet-stream", response.Header().Get("Content-Type"))
+		assert.Equal(t, "10", response.Header().Get("Content-Length"))
+	})
+
+	t.Run("content type and metadata", func(t *testing.T) {
+		cache := store.NewInMemoryStore()
+		require.NoError(t, cache.Put(t.Context(), "some-key", []byte(`{"a":1}`),
+			store.WithContentType("application/json"), store.WithMetadata(map[string]string{"Owner": "alice"})))
+		svc := NewService(cache, nil)
+
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			response := httptest.NewRecorder()
+			request := mux.SetURLVars(httptest.NewRequest(method, "/v1/some-key", nil), map[string]string{"key": "some-key"})
+
+			svc.GetByKey(response, request)
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+			assert.Equal(t, "alice", response.Header().Get("X-Lockbox-Meta-Owner"))
+			assert.Equal(t, "nosniff", response.Header().Get("X-Content-Type-Options"))
+			assert.Equal(t, "7", response.Header().Get("Content-Length"))
+			assert.Equal(t, `"1"`, response.Header().Get("Etag"))
+		}
 	})
 
 	t.Run("not found key", func(t *testing.T) {
@@ -82,12 +159,126 @@ func TestService_GetByKey(t *testing.T) {
 	})
 }
 
+func TestService_GetMany(t *testing.T) {
+	cache := store.NewInMemoryStore()
+	require.NoError(t, cache.Put(t.Context(), "some-key", []byte("some-value")))
+	require.NoError(t, cache.Put(t.Context(), "a/key", []byte("binary\x00value"),
+		store.WithContentType("image/png"), store.WithMetadata(map[string]string{"Owner": "alice"})))
+	svc := NewService(cache, nil)
+
+	getMany := func(body, accept string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/v1/_multiget", strings.NewReader(body))
+		request.Header.Set("Accept", accept)
+		svc.GetMany(response, request)
+		return response
+	}
+
+	t.Run("json", func(t *testing.T) {
+		response := getMany(`{"keys": ["some-key", "missing-key"]}`, "application/json")
+		require.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+
+		var got multiGetResponse
+		require.NoError(t, json.Unmarshal(response.Body.Bytes(), &got))
+		expected := multiGetResponse{
+			Found:   []multiGetItem{{Key: "some-key", JSONValue: NewJSONValue([]byte("some-value")), ETag: `"1"`}},
+			Missing: []string{"missing-key"},
+		}
+		assert.Equal(t, expected, got)
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		response := getMany(`{"keys": ["a/key", "missing-key"]}`, "multipart/mixed")
+		require.Equal(t, http.StatusOK, response.Code)
+
+		mediaType, params, err := mime.ParseMediaType(response.Header().Get("Content-Type"))
+		require.NoError(t, err)
+		assert.Equal(t, "multipart/mixed", mediaType)
+		reader := multipart.NewReader(response.Body, params["boundary"])
+
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "/v1/a%2Fkey", part.Header.Get("Content-Location"))
+		assert.Equal(t, `"2"`, part.Header.Get("Etag"))
+		assert.Equal(t, "image/png", part.Header.Get("Content-Type"))
+		assert.Equal(t, "alice", part.Header.Get("X-Lockbox-Meta-Owner"))
+		value, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, "binary\x00value", string(value))
+
+		part, err = reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "/v1/missing-key", part.Header.Get("Content-Location"))
+		assert.Equal(t, "true", part.Header.Get(MissingHeader))
+
+		_, err = reader.NextPart()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		response := getMany(`{"keys": "some-key"}`, "")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("internal error", func(t *testing.T) {
+		svc := NewService(&errorStore{err: errors.New("db error")}, nil)
+
+		response := httptest.NewRecorder()
+		svc.GetMany(response, httptest.NewRequest(http.MethodPost, "/v1/_multiget", strings.NewReader(`{"keys": ["a"]}`)))
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+	})
+}
+
+func TestService_ListKeys(t *testing.T) {
+	t.Run("pages", func(t *testing.T) {
+		internalStore := map[string]string{"a/1": "one", "a/2": "two", "a/3": "three", "b/1": "other"}
+		svc := NewService(store.NewInMemoryStore(store.WithStorage(internalStore)), nil)
+
+		list := func(query string) listResponse {
+			response := httptest.NewRecorder()
+			svc.ListKeys(response, httptest.NewRequest(http.MethodGet, "/v1"+query, nil))
+			require.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+
+			var listing listResponse
+			require.NoError(t, json.Unmarshal(response.Body.Bytes(), &listing))
+			return listing
+		}
+
+		listing := list("?prefix=a/&limit=2")
+		assert.Equal(t, listResponse{Keys: []listItem{{Key: "a/1"}, {Key: "a/2"}}, Cursor: "a/2"}, listing)
+
+		listing = list("?prefix=a/&limit=2&values=true&cursor=" + listing.Cursor)
+		assert.Equal(t, listResponse{Keys: []listItem{{Key: "a/3", JSONValue: NewJSONValue([]byte("three"))}}}, listing)
+
+		listing = list("?prefix=c/")
+		assert.Equal(t, listResponse{Keys: []listItem{}}, listing)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		svc := NewService(store.NewInMemoryStore(), nil)
+
+		response := httptest.NewRecorder()
+		svc.ListKeys(response, httptest.NewRequest(http.MethodGet, "/v1?limit=lots", nil))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("internal error", func(t *testing.T) {
+		svc := NewService(&errorStore{err: errors.New("db error")}, nil)
+
+		response := httptest.NewRecorder()
+		svc.ListKeys(response, httptest.NewRequest(http.MethodGet, "/v1", nil))
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+	})
+}
+
 func TestService_PutForKey(t *testing.T) {
 	t.Run("new key", func(t *testing.T) {
 		internalStore := map[string]string{}
 		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
 		txLog := &mockTransactionLog{}
-		txLog.On("WritePut", "some-key", "some-value").Return()
+		txLog.On("WritePut", "some-key", []byte("some-value")).Return(nil)
 		svc := NewService(cache, txLog)
 
 		response := httptest.NewRecorder()
@@ -104,7 +295,7 @@ func TestService_PutForKey(t *testing.T) {
 		internalStore := map[string]string{"some-key": "some-existing-value"}
 		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
 		txLog := &mockTransactionLog{}
-		txLog.On("WritePut", "some-key", "some-new-value").Return()
+		txLog.On("WritePut", "some-key", []byte("some-new-value")).Return(nil)
 		svc := NewService(cache, txLog)
 
 		response := httptest.NewRecorder()
@@ -117,6 +308,111 @@ func TestService_PutForKey(t *testing.T) {
 		txLog.AssertExpectations(t)
 	})
 
+	t.Run("content type and metadata", func(t *testing.T) {
+		cache := store.NewInMemoryStore()
+		txLog := &mockTransactionLog{}
+		txLog.On("WritePut", "some-key", []byte("<p>hi</p>")).Return(nil)
+		svc := NewService(cache, txLog)
+
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("<p>hi</p>"))
+		request.Header.Set("Content-Type", "text/html; charset=utf-8")
+		request.Header.Set("X-Lockbox-Meta-Owner", "alice")
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+		svc.PutForKey(response, request)
+		require.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, "text/html; charset=utf-8", txLog.put.ContentType)
+		assert.Equal(t, map[string]string{"Owner": "alice"}, txLog.put.Metadata)
+
+		response = httptest.NewRecorder()
+		request = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/v1/some-key", nil), map[string]string{"key": "some-key"})
+		svc.GetByKey(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "text/html; charset=utf-8", response.Header().Get("Content-Type"))
+		assert.Equal(t, "alice", response.Header().Get("X-Lockbox-Meta-Owner"))
+		// stored markup mustn't be able to run as the API's origin
+		assert.Equal(t, "sandbox", response.Header().Get("Content-Security-Policy"))
+		txLog.AssertExpectations(t)
+	})
+
+	t.Run("invalid content type", func(t *testing.T) {
+		txLog := &mockTransactionLog{}
+		svc := NewService(store.NewInMemoryStore(), txLog)
+
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-value"))
+		request.Header.Set("Content-Type", "text/")
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+		svc.PutForKey(response, request)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		txLog.AssertNotCalled(t, "WritePut", "some-key", []byte("some-value"))
+	})
+
+	t.Run("binary value", func(t *testing.T) {
+		value := []byte{0x00, 0xff, 0xfe, '\r', '\n', 0x80}
+		cache := store.NewInMemoryStore()
+		txLog := &mockTransactionLog{}
+		txLog.On("WritePut", "some-key", value).Return(nil)
+		svc := NewService(cache, txLog)
+
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/v1/some-key", bytes.NewReader(value))
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+		svc.PutForKey(response, request)
+		require.Equal(t, http.StatusCreated, response.Code)
+
+		response = httptest.NewRecorder()
+		request = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/v1/some-key", nil), map[string]string{"key": "some-key"})
+		svc.GetByKey(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, value, response.Body.Bytes())
+		txLog.AssertExpectations(t)
+	})
+
+	t.Run("cancelled request", func(t *testing.T) {
+		internalStore := map[string]string{}
+		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
+		txLog := &mockTransactionLog{}
+		svc := NewService(cache, txLog)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		response := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(ctx, http.MethodPut, "/v1/some-key", strings.NewReader("some-value"))
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+
+		svc.PutForKey(response, request)
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.Empty(t, internalStore)
+		txLog.AssertNotCalled(t, "WritePut", "some-key", []byte("some-value"))
+	})
+
+	t.Run("cancelled after logging", func(t *testing.T) {
+		internalStore := map[string]string{}
+		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
+		txLog := &gatedTransactionLog{entered: make(chan struct{}, 1), release: make(chan struct{})}
+		svc := NewService(cache, txLog)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		response := httptest.NewRecorder()
+		request := httptest.NewRequestWithContext(ctx, http.MethodPut, "/v1/some-key", strings.NewReader("some-value"))
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			svc.PutForKey(response, request)
+		}()
+
+		// the client goes away once the put is in the log, which will replay it after a restart
+		<-txLog.entered
+		cancel()
+		close(txLog.release)
+		<-done
+
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Equal(t, "some-value", internalStore["some-key"])
+	})
+
 	t.Run("read body error", func(t *testing.T) {
 		cache := store.NewInMemoryStore()
 		svc := NewService(cache, nil)
@@ -131,7 +427,9 @@ func TestService_PutForKey(t *testing.T) {
 
 	t.Run("store error", func(t *testing.T) {
 		s := &errorStore{err: errors.New("db error")}
-		svc := NewService(s, nil)
+		txLog := &mockTransactionLog{}
+		txLog.On("WritePut", "some-key", []byte("some-value")).Return(nil)
+		svc := NewService(s, txLog)
 
 		response := httptest.NewRecorder()
 		request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-value"))
@@ -140,6 +438,277 @@ func TestService_PutForKey(t *testing.T) {
 		svc.PutForKey(response, request)
 		assert.Equal(t, http.StatusInternalServerError, response.Code)
 	})
+
+	t.Run("log error", func(t *testing.T) {
+		internalStore := map[string]string{"some-key": "some-existing-value"}
+		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
+		txLog := &mockTransactionLog{}
+		txLog.On("WritePut", "some-key", []byte("some-new-value")).Return(errors.New("disk full"))
+		svc := NewService(cache, txLog)
+
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-new-value"))
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+
+		svc.PutForKey(response, request)
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.Equal(t, "some-existing-value", internalStore["some-key"], "a write that failed to log must not be applied")
+		txLog.AssertExpectations(t)
+	})
+
+	t.Run("read only replica", func(t *testing.T) {
+		internalStore := map[string]string{}
+		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
+		svc := NewService(cache, logger.ReadOnlyLog{})
+
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-value"))
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+
+		svc.PutForKey(response, request)
+		assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+		assert.Empty(t, internalStore)
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		synctest.Test(t, func(t *testing.T) {
+			cache := store.NewInMemoryStore()
+			txLog := &mockTransactionLog{}
+			txLog.On("WritePut", "some-key", []byte("some-value")).Return(nil)
+			svc := NewService(cache, txLog)
+
+			response := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPut, "/v1/some-key?ttl=1m", strings.NewReader("some-value"))
+			request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+
+			svc.PutForKey(response, request)
+			assert.Equal(t, http.StatusCreated, response.Code)
+			assert.Equal(t, time.Now().Add(time.Minute), txLog.expires, "the expiry must be logged for replay")
+
+			time.Sleep(time.Minute - time.Second)
+			entry, err := cache.Get(t.Context(), "some-key")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("some-value"), entry.Value)
+
+			time.Sleep(time.Second)
+			_, err = cache.Get(t.Context(), "some-key")
+			assert.ErrorIs(t, err, store.ErrNotFound)
+		})
+	})
+
+	t.Run("invalid ttl", func(t *testing.T) {
+		internalStore := map[string]string{}
+		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
+		txLog := &mockTransactionLog{}
+		svc := NewService(cache, txLog)
+
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-value"))
+		request.Header.Set(TTLHeader, "-5")
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+
+		svc.PutForKey(response, request)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.Empty(t, internalStore)
+		txLog.AssertNotCalled(t, "WritePut", "some-key", []byte("some-value"))
+	})
+}
+
+// TestService_Preconditions tests that writes are only made when their If-Match and If-None-Match headers hold, and
+// that a write which is turned away is never logged
+func TestService_Preconditions(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		header   string
+		value    string
+		expected int
+	}{
+		{name: "put if matched", method: http.MethodPut, header: "If-Match", value: `"1"`, expected: http.StatusCreated},
+		{name: "put if any matched", method: http.MethodPut, header: "If-Match", value: `"7", "1"`, expected: http.StatusCreated},
+		{name: "put if stale", method: http.MethodPut, header: "If-Match", value: `"7"`, expected: http.StatusPreconditionFailed},
+		{name: "put if weak", method: http.MethodPut, header: "If-Match", value: `W/"1"`, expected: http.StatusPreconditionFailed},
+		{name: "put if exists", method: http.MethodPut, header: "If-Match", value: "*", expected: http.StatusCreated},
+		{name: "put if new", method: http.MethodPut, header: "If-None-Match", value: "*", expected: http.StatusPreconditionFailed},
+		{name: "put if changed", method: http.MethodPut, header: "If-None-Match", value: `W/"1"`, expected: http.StatusPreconditionFailed},
+		{name: "put malformed", method: http.MethodPut, header: "If-Match", value: "1", expected: http.StatusBadRequest},
+		{name: "delete if matched", method: http.MethodDelete, header: "If-Match", value: `"1"`, expected: http.StatusAccepted},
+		{name: "delete if stale", method: http.MethodDelete, header: "If-Match", value: `"7"`, expected: http.StatusPreconditionFailed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := store.NewInMemoryStore()
+			require.NoError(t, cache.Put(t.Context(), "some-key", []byte("some-value")))
+			txLog := &mockTransactionLog{}
+			txLog.On("WritePut", "some-key", []byte("some-new-value")).Return(nil)
+			txLog.On("WriteDelete", "some-key").Return(nil)
+			svc := NewService(cache, txLog)
+
+			response := httptest.NewRecorder()
+			request := httptest.NewRequest(tc.method, "/v1/some-key", strings.NewReader("some-new-value"))
+			request.Header.Set(tc.header, tc.value)
+			request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+
+			if tc.method == http.MethodPut {
+				svc.PutForKey(response, request)
+			} else {
+				svc.DeleteKey(response, request)
+			}
+			assert.Equal(t, tc.expected, response.Code)
+
+			entry, err := cache.Get(t.Context(), "some-key")
+			if tc.expected >= http.StatusBadRequest {
+				require.NoError(t, err)
+				assert.Equal(t, store.Entry{Value: []byte("some-value"), Version: 1}, entry)
+				txLog.AssertNotCalled(t, "WritePut", "some-key", []byte("some-new-value"))
+				txLog.AssertNotCalled(t, "WriteDelete", "some-key")
+			} else if tc.method == http.MethodPut {
+				require.NoError(t, err)
+				assert.Equal(t, store.Entry{Value: []byte("some-new-value"), Version: 2}, entry)
+			}
+		})
+	}
+
+	t.Run("etag", func(t *testing.T) {
+		cache := store.NewInMemoryStore()
+		txLog := &mockTransactionLog{}
+		txLog.On("WritePut", "some-key", []byte("some-value")).Return(nil)
+		svc := NewService(cache, txLog)
+
+		// a key which doesn't exist yet can be created only once
+		for _, expected := range []int{http.StatusCreated, http.StatusPreconditionFailed} {
+			response := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-value"))
+			request.Header.Set("If-None-Match", "*")
+			request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+			svc.PutForKey(response, request)
+			assert.Equal(t, expected, response.Code)
+		}
+
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/v1/some-key", nil)
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+		svc.GetByKey(response, request)
+		assert.Equal(t, `"1"`, response.Header().Get("Etag"))
+	})
+}
+
+// TestService_AsyncPreconditions tests that conditional writes are turned away before they are logged when writes
+// aren't durable, as the versions they would be compared against aren't stable, while unconditional writes carry on
+func TestService_AsyncPreconditions(t *testing.T) {
+	cache := store.NewInMemoryStore()
+	require.NoError(t, cache.Put(t.Context(), "some-key", []byte("some-value")))
+	txLog := &mockTransactionLog{}
+	txLog.On("WritePut", "some-key", []byte("some-new-value")).Return(nil)
+	svc := NewService(cache, txLog, WithAsyncWrites())
+
+	for _, header := range []string{"If-Match", "If-None-Match"} {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-new-value"))
+		request.Header.Set(header, `"1"`)
+		svc.PutForKey(response, mux.SetURLVars(request, map[string]string{"key": "some-key"}))
+		assert.Equal(t, http.StatusBadRequest, response.Code, header)
+		assert.Contains(t, response.Body.String(), "conditional writes need durable writes")
+	}
+
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodDelete, "/v1/some-key", nil)
+	request.Header.Set("If-Match", `"1"`)
+	svc.DeleteKey(response, mux.SetURLVars(request, map[string]string{"key": "some-key"}))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	response = httptest.NewRecorder()
+	svc.WriteBatch(response, httptest.NewRequest(http.MethodPost, "/v1/_batch",
+		strings.NewReader(`{"ops":[{"op":"delete","key":"some-key","if_version":1}]}`)))
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	txLog.AssertNotCalled(t, "WritePut", "some-key", []byte("some-new-value"))
+	txLog.AssertNotCalled(t, "WriteDelete", "some-key")
+	txLog.AssertNotCalled(t, "WriteBatch", mock.Anything)
+
+	response = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-new-value"))
+	svc.PutForKey(response, mux.SetURLVars(request, map[string]string{"key": "some-key"}))
+	assert.Equal(t, http.StatusCreated, response.Code)
+}
+
+func TestService_WriteBatch(t *testing.T) {
+	batch := func(svc *Service, body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		svc.WriteBatch(response, httptest.NewRequest(http.MethodPost, "/v1/_batch", strings.NewReader(body)))
+		return response
+	}
+
+	t.Run("applies every op", func(t *testing.T) {
+		cache := store.NewInMemoryStore()
+		require.NoError(t, cache.Put(t.Context(), "old-key", []byte("old-value")))
+		txLog := &mockTransactionLog{}
+		txLog.On("WriteBatch", mock.Anything).Return(nil)
+		svc := NewService(cache, txLog)
+
+		response := batch(svc, `{"ops": [
+			{"op": "put", "key": "new-key", "value": "new-value", "if_version": 0},
+			{"op": "put", "key": "ttl-key", "value": "ttl-value", "ttl": "1h"},
+			{"op": "delete", "key": "old-key", "if_version": 1}
+		]}`)
+		assert.Equal(t, http.StatusNoContent, response.Code)
+
+		// the batch is logged as a whole, with the expiry it is applied with
+		txLog.AssertNumberOfCalls(t, "WriteBatch", 1)
+		ops, ok := txLog.Calls[0].Arguments.Get(0).([]logger.Event)
+		require.True(t, ok)
+		require.Len(t, ops, 3)
+		assert.Equal(t, logger.PutOp("new-key", []byte("new-value")), ops[0])
+		assert.Equal(t, logger.DeleteOp("old-key"), ops[2])
+		assert.WithinDuration(t, time.Now().Add(time.Hour), ops[1].Expires, time.Minute)
+
+		contents := cache.Snapshot()
+		assert.Equal(t, map[string]string{"new-key": "new-value", "ttl-key": "ttl-value"}, contents.Data)
+		assert.Equal(t, map[string]uint64{"new-key": 2, "ttl-key": 2}, contents.Versions)
+		assert.Equal(t, map[string]time.Time{"ttl-key": ops[1].Expires}, contents.Expiry)
+	})
+
+	t.Run("precondition failed", func(t *testing.T) {
+		cache := store.NewInMemoryStore()
+		require.NoError(t, cache.Put(t.Context(), "some-key", []byte("some-value")))
+		txLog := &mockTransactionLog{}
+		svc := NewService(cache, txLog)
+
+		response := batch(svc, `{"ops": [
+			{"op": "put", "key": "other-key", "value": "other-value"},
+			{"op": "put", "key": "some-key", "value": "stale-value", "if_version": 7}
+		]}`)
+		assert.Equal(t, http.StatusPreconditionFailed, response.Code)
+		txLog.AssertNotCalled(t, "WriteBatch", mock.Anything)
+		assert.Equal(t, map[string]string{"some-key": "some-value"}, cache.Snapshot().Data)
+	})
+
+	t.Run("invalid batch", func(t *testing.T) {
+		txLog := &mockTransactionLog{}
+		svc := NewService(store.NewInMemoryStore(), txLog)
+
+		response := batch(svc, `{"ops": [{"op": "put", "key": "some-key"}]}`)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		txLog.AssertNotCalled(t, "WriteBatch", mock.Anything)
+	})
+
+	t.Run("transaction log error", func(t *testing.T) {
+		cache := store.NewInMemoryStore()
+		txLog := &mockTransactionLog{}
+		txLog.On("WriteBatch", mock.Anything).Return(errors.New("disk full"))
+		svc := NewService(cache, txLog)
+
+		response := batch(svc, `{"ops": [{"op": "put", "key": "some-key", "value": "some-value"}]}`)
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.Equal(t, 0, cache.Len())
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		svc := NewService(store.NewInMemoryStore(), logger.ReadOnlyLog{})
+
+		response := batch(svc, `{"ops": [{"op": "delete", "key": "some-key"}]}`)
+		assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	})
 }
 
 func TestService_DeleteForKey(t *testing.T) {
@@ -147,7 +716,7 @@ func TestService_DeleteForKey(t *testing.T) {
 		internalStore := map[string]string{"some-key": "some-existing-value"}
 		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
 		txLog := &mockTransactionLog{}
-		txLog.On("WriteDelete", "some-key").Return()
+		txLog.On("WriteDelete", "some-key").Return(nil)
 		svc := NewService(cache, txLog)
 
 		response := httptest.NewRecorder()
@@ -163,7 +732,7 @@ func TestService_DeleteForKey(t *testing.T) {
 		internalStore := map[string]string{}
 		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
 		txLog := &mockTransactionLog{}
-		txLog.On("WriteDelete", "some-key").Return()
+		txLog.On("WriteDelete", "some-key").Return(nil)
 		svc := NewService(cache, txLog)
 
 		response := httptest.NewRecorder()
@@ -178,7 +747,9 @@ func TestService_DeleteForKey(t *testing.T) {
 
 	t.Run("store error", func(t *testing.T) {
 		s := &errorStore{err: errors.New("db error")}
-		svc := NewService(s, nil)
+		txLog := &mockTransactionLog{}
+		txLog.On("WriteDelete", "some-key").Return(nil)
+		svc := NewService(s, txLog)
 
 		response := httptest.NewRecorder()
 		request := httptest.NewRequest(http.MethodDelete, "/v1/some-key", nil)
@@ -187,4 +758,292 @@ func TestService_DeleteForKey(t *testing.T) {
 		svc.DeleteKey(response, request)
 		assert.Equal(t, http.StatusInternalServerError, response.Code)
 	})
+
+	t.Run("log error", func(t *testing.T) {
+		internalStore := map[string]string{"some-key": "some-existing-value"}
+		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
+		txLog := &mockTransactionLog{}
+		txLog.On("WriteDelete", "some-key").Return(errors.New("disk full"))
+		svc := NewService(cache, txLog)
+
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodDelete, "/v1/some-key", nil)
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+
+		svc.DeleteKey(response, request)
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.Equal(t, "some-existing-value", internalStore["some-key"], "a delete that failed to log must not be applied")
+		txLog.AssertExpectations(t)
+	})
+
+	t.Run("read only replica", func(t *testing.T) {
+		internalStore := map[string]string{"some-key": "some-existing-value"}
+		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
+		svc := NewService(cache, logger.ReadOnlyLog{})
+
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodDelete, "/v1/some-key", nil)
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+
+		svc.DeleteKey(response, request)
+		assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+		assert.Equal(t, "some-existing-value", internalStore["some-key"])
+	})
+}
+
+// TestService_SetTransactionLog tests that a replica starts accepting writes once it is given a writable log
+func TestService_SetTransactionLog(t *testing.T) {
+	internalStore := map[string]string{}
+	cache := store.NewInMemoryStore(store.WithStorage(internalStore))
+	svc := NewService(cache, logger.ReadOnlyLog{})
+
+	put := func() int {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-value"))
+		request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+		svc.PutForKey(response, request)
+		return response.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, put())
+
+	txLog := &mockTransactionLog{}
+	txLog.On("WritePut", "some-key", []byte("some-value")).Return(nil)
+	svc.SetTransactionLog(txLog)
+
+	assert.Equal(t, http.StatusCreated, put())
+	assert.Equal(t, "some-value", internalStore["some-key"])
+	txLog.AssertExpectations(t)
+}
+
+// gatedTransactionLog signals entered when a write reaches it and then blocks until release is closed
+type gatedTransactionLog struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (g *gatedTransactionLog) WritePut(_ context.Context, _ string, _ []byte, _ ...logger.WriteOption) (uint64, error) {
+	g.entered <- struct{}{}
+	<-g.release
+	return 0, nil
+}
+
+func (g *gatedTransactionLog) WriteDelete(_ context.Context, _ string) (uint64, error) {
+	g.entered <- struct{}{}
+	<-g.release
+	return 0, nil
+}
+
+func (g *gatedTransactionLog) WriteBatch(_ context.Context, _ []logger.Event) (uint64, error) {
+	g.entered <- struct{}{}
+	<-g.release
+	return 0, nil
+}
+
+// sequencedTransactionLog gives each write the next of a run of sequence numbers, as a durable log does, after taking
+// delay to log it
+type sequencedTransactionLog struct {
+	sequence uint64
+	delay    time.Duration
+}
+
+func (l *sequencedTransactionLog) WritePut(_ context.Context, _ string, _ []byte, _ ...logger.WriteOption) (uint64, error) {
+	return l.next(), nil
+}
+
+func (l *sequencedTransactionLog) WriteDelete(_ context.Context, _ string) (uint64, error) {
+	return l.next(), nil
+}
+
+func (l *sequencedTransactionLog) WriteBatch(_ context.Context, _ []logger.Event) (uint64, error) {
+	return l.next(), nil
+}
+
+func (l *sequencedTransactionLog) next() uint64 {
+	time.Sleep(l.delay)
+	l.sequence++
+	return l.sequence
+}
+
+// TestService_Versions tests that a key takes the sequence number its write was logged with as its version, so that it
+// is the same on every replica and after the log is replayed
+func TestService_Versions(t *testing.T) {
+	cache := store.NewInMemoryStore()
+	svc := NewService(cache, &sequencedTransactionLog{sequence: 40})
+
+	_, err := svc.put(t.Context(), "a", []byte("x"), description{}, 0, precondition{})
+	require.NoError(t, err)
+	_, err = svc.put(t.Context(), "b", []byte("y"), description{}, 0, precondition{})
+	require.NoError(t, err)
+	_, err = svc.delete(t.Context(), "b", precondition{})
+	require.NoError(t, err)
+	_, err = svc.batch(t.Context(), []batchWrite{{key: "c", value: []byte("z")}})
+	require.NoError(t, err)
+
+	entries, err := cache.GetMany(t.Context(), []string{"a", "c"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(41), entries["a"].Version)
+	assert.Equal(t, uint64(44), entries["c"].Version)
+
+	response := httptest.NewRecorder()
+	svc.GetByKey(response, mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/v1/a", nil), map[string]string{"key": "a"}))
+	assert.Equal(t, `"41"`, response.Header().Get("Etag"))
+}
+
+// TestService_ExpiresWhileLogging tests that a conditional write whose key expires after its precondition was checked,
+// while the write is being logged, is still applied, as once logged it will be replayed whatever the key is at
+func TestService_ExpiresWhileLogging(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cache := store.NewInMemoryStore()
+		svc := NewService(cache, &sequencedTransactionLog{sequence: 1, delay: time.Minute})
+		// expiring puts key at version 1, to expire before the write to it has been logged
+		expiring := func(key string) {
+			require.NoError(t, cache.Put(t.Context(), key, []byte("old"), store.ExpiresAt(time.Now().Add(time.Second)),
+				store.WithVersion(1)))
+		}
+		matched := precondition{match: []string{`"1"`}}
+
+		expiring("put")
+		_, err := svc.put(t.Context(), "put", []byte("new"), description{}, 0, matched)
+		require.NoError(t, err)
+		expiring("delete")
+		_, err = svc.delete(t.Context(), "delete", matched)
+		require.NoError(t, err)
+		expiring("batch")
+		_, err = svc.batch(t.Context(), []batchWrite{{key: "batch", value: []byte("new"), cond: matched}})
+		require.NoError(t, err)
+
+		entries, err := cache.GetMany(t.Context(), []string{"put", "delete", "batch"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]store.Entry{
+			"put":   {Value: []byte("new"), Version: 2},
+			"batch": {Value: []byte("new"), Version: 4},
+		}, entries)
+	})
+}
+
+func TestService_Quiesce(t *testing.T) {
+	t.Run("waits for in-flight writes", func(t *testing.T) {
+		internalStore := map[string]string{}
+		cache := store.NewInMemoryStore(store.WithStorage(internalStore))
+		txLog := &gatedTransactionLog{entered: make(chan struct{}, 1), release: make(chan struct{})}
+		svc := NewService(cache, txLog)
+
+		// a write is in flight, stuck in the transaction log
+		go func() {
+			request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-value"))
+			request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+			svc.PutForKey(httptest.NewRecorder(), request)
+		}()
+		<-txLog.entered
+
+		quiesced := make(chan map[string]string, 1)
+		go func() {
+			err := svc.Quiesce(func() error {
+				quiesced <- cache.Snapshot().Data
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+
+		select {
+		case <-quiesced:
+			t.Fatal("Quiesce must wait for the in-flight write")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(txLog.release)
+
+		select {
+		case snap := <-quiesced:
+			assert.Equal(t, map[string]string{"some-key": "some-value"}, snap, "the in-flight write should be applied")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Quiesce did not run once the write completed")
+		}
+	})
+
+	t.Run("returns the error from fn", func(t *testing.T) {
+		svc := NewService(store.NewInMemoryStore(), nil)
+		err := svc.Quiesce(func() error { return errors.New("snapshot failed") })
+		assert.ErrorContains(t, err, "snapshot failed")
+	})
+}
+
+func TestService_Degrade(t *testing.T) {
+	internalStore := map[string]string{"some-key": "some-value"}
+	cache := store.NewInMemoryStore(store.WithStorage(internalStore))
+	txLog := &mockTransactionLog{}
+	txLog.On("WriteDelete", "some-key").Return(nil)
+	svc := NewService(cache, txLog)
+
+	svc.Degrade(errors.New("disk full"))
+	assert.ErrorIs(t, svc.Degraded(), ErrDegraded)
+
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-new-value"))
+	request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+	svc.PutForKey(response, request)
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Contains(t, response.Body.String(), "disk full", "the reason is given to the client")
+
+	// reads are still served
+	response = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, "/v1/some-key", nil)
+	request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+	svc.GetByKey(response, request)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	svc.Recover()
+	require.NoError(t, svc.Degraded())
+
+	response = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodDelete, "/v1/some-key", nil)
+	request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+	svc.DeleteKey(response, request)
+	assert.Equal(t, http.StatusAccepted, response.Code)
+	assert.Empty(t, internalStore)
+	txLog.AssertExpectations(t)
+}
+
+func TestService_Monitor(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		svc := NewService(store.NewInMemoryStore(), &mockTransactionLog{})
+
+		errs := make(chan error)
+		var repairs atomic.Int32
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			svc.Monitor(errs, func() error {
+				if repairs.Add(1) < 3 {
+					return errors.New("still broken")
+				}
+				return nil
+			}, time.Second)
+		}()
+
+		errs <- errors.New("disk full")
+		synctest.Wait()
+		assert.ErrorContains(t, svc.Degraded(), "disk full")
+
+		time.Sleep(2 * time.Second)
+		synctest.Wait()
+		assert.Equal(t, int32(2), repairs.Load())
+		require.Error(t, svc.Degraded(), "still degraded while repair fails")
+
+		time.Sleep(time.Second)
+		synctest.Wait()
+		assert.Equal(t, int32(3), repairs.Load())
+		require.NoError(t, svc.Degraded())
+
+		close(errs)
+		<-done
+	})
+}
+
+func TestLogErrorStatus(t *testing.T) {
+	assert.Equal(t, http.StatusServiceUnavailable, logErrorStatus(logger.ErrReadOnly))
+	assert.Equal(t, http.StatusServiceUnavailable, logErrorStatus(fmt.Errorf("%w: disk full", ErrDegraded)))
+	assert.Equal(t, http.StatusServiceUnavailable, logErrorStatus(logger.ErrLogStopped))
+	assert.Equal(t, http.StatusInternalServerError, logErrorStatus(errors.New("disk full")))
 }
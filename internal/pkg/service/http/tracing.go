@@ -0,0 +1,65 @@
+package http
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+// tracerName is the instrumentation scope of the package's spans, which are created through whichever tracer provider
+// is global at the time.
+const tracerName = "github.com/treyburn/lockbox/internal/pkg/service/http"
+
+// startSpan starts the span for a request handled by the service, as a child of the request's server span.
+func startSpan(ctx context.Context, name, key string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attribute.String("lockbox.key", key)))
+}
+
+// startListSpan starts the span for a listing of the keys starting with prefix, as a child of the request's server span.
+func startListSpan(ctx context.Context, prefix string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "Service.ListKeys",
+		trace.WithAttributes(attribute.String("lockbox.prefix", prefix)))
+}
+
+// startMultiGetSpan starts the span for a multi-get of keys keys, as a child of the request's server span.
+func startMultiGetSpan(ctx context.Context, keys int) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "Service.GetMany",
+		trace.WithAttributes(attribute.Int("lockbox.multiget.keys", keys)))
+}
+
+// startBatchSpan starts the span for a batch of ops writes, as a child of the request's server span.
+func startBatchSpan(ctx context.Context, ops int) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "Service.WriteBatch",
+		trace.WithAttributes(attribute.Int("lockbox.batch.ops", ops)))
+}
+
+// traced calls fn with a span called name, a child of ctx, which records the error fn returns unless it was expected.
+func traced(ctx context.Context, name string, fn func(context.Context) error) error {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name)
+	defer span.End()
+
+	err := fn(ctx)
+	if err != nil && !expected(err) {
+		failSpan(span, err)
+	}
+	return err
+}
+
+// expected reports whether err is an outcome of the request rather than a failure as far as the trace is concerned,
+// such as a key which isn't found or a precondition which doesn't hold.
+func expected(err error) bool {
+	return errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrVersionMismatch) ||
+		errors.Is(err, errUnstableVersions)
+}
+
+// failSpan marks span as having failed with err.
+func failSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
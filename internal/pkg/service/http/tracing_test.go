@@ -0,0 +1,67 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+// TestService_Tracing tests that each request is traced through the store and the transaction log
+func TestService_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	failing := &mockTransactionLog{}
+	failing.On("WritePut", "some-key", []byte("some-value")).Return(nil)
+	failing.On("WriteDelete", "some-key").Return(errors.New("disk full"))
+	svc := NewService(store.NewInMemoryStore(), failing)
+
+	request := httptest.NewRequest(http.MethodPut, "/v1/some-key", strings.NewReader("some-value"))
+	request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+	svc.PutForKey(httptest.NewRecorder(), request)
+
+	request = httptest.NewRequest(http.MethodDelete, "/v1/some-key", nil)
+	request = mux.SetURLVars(request, map[string]string{"key": "some-key"})
+	svc.DeleteKey(httptest.NewRecorder(), request)
+
+	request = httptest.NewRequest(http.MethodGet, "/v1/other-key", nil)
+	request = mux.SetURLVars(request, map[string]string{"key": "other-key"})
+	svc.GetByKey(httptest.NewRecorder(), request)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 7)
+
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name()
+	}
+	assert.Equal(t, []string{
+		"TransactionLog.WritePut", "Store.Put", "Service.PutForKey",
+		"TransactionLog.WriteDelete", "Service.DeleteKey",
+		"Store.Get", "Service.GetByKey",
+	}, names)
+
+	// each call is a child of the span for its request
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[1].Parent().SpanID())
+	assert.Equal(t, spans[4].SpanContext().SpanID(), spans[3].Parent().SpanID())
+
+	assert.Equal(t, codes.Unset, spans[2].Status().Code)
+	assert.Equal(t, codes.Error, spans[3].Status().Code)
+	assert.Equal(t, codes.Error, spans[4].Status().Code)
+	// a missing key is an answer rather than a failure
+	assert.Equal(t, codes.Unset, spans[5].Status().Code)
+	failing.AssertExpectations(t)
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// TTLHeader sets how long a key written by PUT lives for, as a whole number of seconds or a duration such as "90s".
+	TTLHeader = "Lockbox-TTL"
+	// TTLParam is the query parameter equivalent of TTLHeader.
+	TTLParam = "ttl"
+)
+
+var errInvalidTTL = errors.New("invalid ttl")
+
+// requestTTL returns the TTL requested for the key written by r, or zero if the key shouldn't expire. It may be given
+// by either TTLHeader or TTLParam, but not by both with different values.
+func requestTTL(r *http.Request) (time.Duration, error) {
+	header := r.Header.Get(TTLHeader)
+	param := r.URL.Query().Get(TTLParam)
+
+	raw := header
+	switch {
+	case header != "" && param != "" && header != param:
+		return 0, fmt.Errorf("%w: %s header %q conflicts with %s parameter %q",
+			errInvalidTTL, TTLHeader, header, TTLParam, param)
+	case header == "":
+		raw = param
+	}
+
+	if raw == "" {
+		return 0, nil
+	}
+	return parseTTL(raw)
+}
+
+// parseTTL parses a TTL given as a whole number of seconds or as a Go duration. It must be positive.
+func parseTTL(raw string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(raw)
+	if seconds, parseErr := strconv.ParseInt(raw, 10, 64); parseErr == nil {
+		if seconds > math.MaxInt64/int64(time.Second) {
+			return 0, fmt.Errorf("%w: %q is too long", errInvalidTTL, raw)
+		}
+		ttl, err = time.Duration(seconds)*time.Second, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is neither a number of seconds nor a duration", errInvalidTTL, raw)
+	}
+
+	if ttl <= 0 {
+		return 0, fmt.Errorf("%w: %q must be positive", errInvalidTTL, raw)
+	}
+	return ttl, nil
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRequestTTL tests reading the TTL of a put from its header or query parameter
+func TestRequestTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		query   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "seconds header", header: "30", want: 30 * time.Second},
+		{name: "duration header", header: "1h30m", want: 90 * time.Minute},
+		{name: "seconds query", query: "?ttl=45", want: 45 * time.Second},
+		{name: "duration query", query: "?ttl=250ms", want: 250 * time.Millisecond},
+		{name: "header and query agree", header: "10s", query: "?ttl=10s", want: 10 * time.Second},
+		{name: "header and query conflict", header: "10s", query: "?ttl=20s", wantErr: true},
+		{name: "zero", header: "0", wantErr: true},
+		{name: "negative", query: "?ttl=-1m", wantErr: true},
+		{name: "garbage", header: "soon", wantErr: true},
+		{name: "too long", header: "9223372036854775807", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPut, "/v1/some-key"+tc.query, nil)
+			if tc.header != "" {
+				request.Header.Set(TTLHeader, tc.header)
+			}
+
+			ttl, err := requestTTL(request)
+			if tc.wantErr {
+				require.ErrorIs(t, err, errInvalidTTL)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, ttl)
+		})
+	}
+}
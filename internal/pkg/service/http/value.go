@@ -0,0 +1,43 @@
+package http
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// JSONValue is how a value is carried in the JSON bodies of listings, multi-gets and batches. A value which is valid
+// UTF-8, as text put through the API will be, is carried as a string in Text, and any other value is carried in base64
+// in Base64 instead, so that it isn't mangled by JSON, whose strings can only hold text.
+type JSONValue struct {
+	Text   *string `json:"value,omitempty"`
+	Base64 []byte  `json:"value_base64,omitempty"`
+}
+
+// NewJSONValue returns value as it is carried in JSON.
+func NewJSONValue(value []byte) JSONValue {
+	if !utf8.Valid(value) {
+		return JSONValue{Base64: value}
+	}
+	text := string(value)
+	return JSONValue{Text: &text}
+}
+
+// given reports whether v holds a value in either form.
+func (v JSONValue) given() bool {
+	return v.Text != nil || v.Base64 != nil
+}
+
+// bytes returns the value v holds, which must be given in exactly one form. Its errors are worded to follow the op,
+// such as "put has no value".
+func (v JSONValue) bytes() ([]byte, error) {
+	switch {
+	case v.Text != nil && v.Base64 != nil:
+		return nil, errors.New("has both value and value_base64")
+	case v.Text != nil:
+		return []byte(*v.Text), nil
+	case v.Base64 != nil:
+		return v.Base64, nil
+	default:
+		return nil, errors.New("has no value")
+	}
+}
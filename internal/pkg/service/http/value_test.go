@@ -0,0 +1,37 @@
+package http
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestJSONValue tests that values are carried in JSON as text when they can be and in base64 otherwise, and come back
+// exactly as they were
+func TestJSONValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value []byte
+		json  string
+	}{
+		{name: "text", value: []byte("héllo\n"), json: `{"value":"héllo\n"}`},
+		{name: "empty", value: []byte{}, json: `{"value":""}`},
+		{name: "binary", value: []byte{0xff, 0x00, 'a'}, json: `{"value_base64":"/wBh"}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := json.Marshal(NewJSONValue(tc.value))
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.json, string(encoded))
+
+			var decoded JSONValue
+			require.NoError(t, json.Unmarshal(encoded, &decoded))
+			value, err := decoded.bytes()
+			require.NoError(t, err)
+			assert.Equal(t, tc.value, value)
+		})
+	}
+}
@@ -0,0 +1,446 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treyburn/lockbox/internal/pkg/disk"
+)
+
+// Snapshot files are laid out as:
+//
+//	magic (8) | version (1) | payload | crc32c of payload (4, big endian)
+//
+// and a version 4 payload is:
+//
+//	uvarint sequence | uvarint entry count | entries...
+//
+// where each entry is a uvarint length prefixed key, a uvarint length prefixed value, a varint expiry in unix
+// nanoseconds, zero meaning never, the uvarint version of the key, a uvarint length prefixed content type and the
+// uvarint count of the key's metadata fields, followed by each of their uvarint length prefixed names and values in
+// order of name. Version 3 entries are the same without the content type and metadata, version 2 entries without the
+// version either, and version 1 entries without the expiry; they are still read, but no longer written.
+const (
+	magic          = "LBXSNAP\x00"
+	formatVersion1 = 1
+	formatVersion2 = 2
+	formatVersion3 = 3
+	formatVersion4 = 4
+
+	filePrefix = "snapshot-"
+	fileSuffix = ".snap"
+)
+
+var (
+	ErrNoSnapshot = errors.New("no snapshot found")
+	ErrCorrupt    = errors.New("corrupt snapshot")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Snapshot is a point in time copy of the store along with the sequence number of the last transaction log event
+// applied to it. Replaying the events after Sequence on top of Data reproduces the live store.
+type Snapshot struct {
+	Sequence uint64
+	Data     map[string]string
+	// Expiry holds when each key in Data which expires does so. Keys missing from it never expire.
+	Expiry map[string]time.Time
+	// Versions holds the version of each key in Data. Keys in snapshots written before versions were kept are missing
+	// from it.
+	Versions map[string]uint64
+	// ContentTypes holds the media type of each key in Data which was put with one.
+	ContentTypes map[string]string
+	// Metadata holds the user metadata of each key in Data which has any.
+	Metadata map[string]map[string]string
+}
+
+// fileName returns the name of the snapshot file for seq. Zero padding keeps lexical and numeric order the same.
+func fileName(seq uint64) string {
+	return fmt.Sprintf("%s%020d%s", filePrefix, seq, fileSuffix)
+}
+
+// Write atomically persists snap into dir and returns the path of the new snapshot file. The file only appears under
+// its final name once it is completely written and synced, so a crash never leaves a partial snapshot behind.
+func Write(dir string, snap Snapshot) (string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".snapshot-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer func() {
+		// a no-op once the rename has succeeded
+		_ = os.Remove(tmp.Name())
+	}()
+
+	err = encode(tmp, snap)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	path := filepath.Join(dir, fileName(snap.Sequence))
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to rename snapshot: %w", err)
+	}
+
+	if err = disk.SyncDir(dir); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+func encode(w io.Writer, snap Snapshot) error {
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(w)
+
+	if _, err := bw.WriteString(magic); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(formatVersion4); err != nil {
+		return err
+	}
+
+	// everything after the header goes through the checksum as well
+	payload := io.MultiWriter(bw, crc)
+
+	var buf []byte
+	buf = binary.AppendUvarint(buf, snap.Sequence)
+	buf = binary.AppendUvarint(buf, uint64(len(snap.Data)))
+	if _, err := payload.Write(buf); err != nil {
+		return err
+	}
+
+	for key, value := range snap.Data {
+		buf = binary.AppendUvarint(buf[:0], uint64(len(key)))
+		buf = append(buf, key...)
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		buf = append(buf, value...)
+		var expires int64
+		if t, ok := snap.Expiry[key]; ok && !t.IsZero() {
+			expires = t.UnixNano()
+		}
+		buf = binary.AppendVarint(buf, expires)
+		buf = binary.AppendUvarint(buf, snap.Versions[key])
+		buf = appendDescription(buf, snap.ContentTypes[key], snap.Metadata[key])
+		if _, err := payload.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	if _, err := bw.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32())); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// appendDescription appends the content type and metadata of an entry to buf, with the metadata in order of name.
+func appendDescription(buf []byte, contentType string, metadata map[string]string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(contentType)))
+	buf = append(buf, contentType...)
+	buf = binary.AppendUvarint(buf, uint64(len(metadata)))
+	for _, name := range slices.Sorted(maps.Keys(metadata)) {
+		buf = binary.AppendUvarint(buf, uint64(len(name)))
+		buf = append(buf, name...)
+		buf = binary.AppendUvarint(buf, uint64(len(metadata[name])))
+		buf = append(buf, metadata[name]...)
+	}
+	return buf
+}
+
+// Load reads and verifies the snapshot at path.
+func Load(path string) (Snapshot, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from our own snapshot directory
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	snap, err := decode(data)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to decode snapshot %s: %w", path, err)
+	}
+
+	return snap, nil
+}
+
+func decode(data []byte) (Snapshot, error) {
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return Snapshot{}, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+	data = data[len(magic):]
+
+	if len(data) < 1+crc32.Size {
+		return Snapshot{}, fmt.Errorf("%w: truncated", ErrCorrupt)
+	}
+	version := data[0]
+	if version < formatVersion1 || version > formatVersion4 {
+		return Snapshot{}, fmt.Errorf("%w: unsupported version %d", ErrCorrupt, version)
+	}
+
+	payload, trailer := data[1:len(data)-crc32.Size], data[len(data)-crc32.Size:]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(trailer) {
+		return Snapshot{}, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	seq, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return Snapshot{}, fmt.Errorf("%w: invalid sequence", ErrCorrupt)
+	}
+	payload = payload[n:]
+
+	count, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return Snapshot{}, fmt.Errorf("%w: invalid entry count", ErrCorrupt)
+	}
+	payload = payload[n:]
+
+	// every entry takes at least two bytes, which bounds the count before we trust it for an allocation
+	if count > uint64(len(payload)/2) {
+		return Snapshot{}, fmt.Errorf("%w: entry count %d exceeds payload", ErrCorrupt, count)
+	}
+
+	snap := Snapshot{Sequence: seq, Data: make(map[string]string, count)}
+	for range count {
+		var e entry
+		var err error
+		if e, payload, err = decodeEntry(version, payload); err != nil {
+			return Snapshot{}, err
+		}
+		snap.add(e)
+	}
+
+	if len(payload) != 0 {
+		return Snapshot{}, fmt.Errorf("%w: %d trailing bytes", ErrCorrupt, len(payload))
+	}
+
+	return snap, nil
+}
+
+// entry is a single key in a snapshot. The expiry is zero for keys which never expire, and the version for keys in
+// snapshots written before versions were kept.
+type entry struct {
+	key         string
+	value       string
+	expires     time.Time
+	version     uint64
+	contentType string
+	metadata    map[string]string
+}
+
+// add adds e to the snapshot.
+func (s *Snapshot) add(e entry) {
+	s.Data[e.key] = e.value
+	if !e.expires.IsZero() {
+		if s.Expiry == nil {
+			s.Expiry = make(map[string]time.Time)
+		}
+		s.Expiry[e.key] = e.expires
+	}
+	if e.version != 0 {
+		if s.Versions == nil {
+			s.Versions = make(map[string]uint64)
+		}
+		s.Versions[e.key] = e.version
+	}
+	if e.contentType != "" {
+		if s.ContentTypes == nil {
+			s.ContentTypes = make(map[string]string)
+		}
+		s.ContentTypes[e.key] = e.contentType
+	}
+	if len(e.metadata) > 0 {
+		if s.Metadata == nil {
+			s.Metadata = make(map[string]map[string]string)
+		}
+		s.Metadata[e.key] = e.metadata
+	}
+}
+
+// decodeEntry decodes the entry of the given version at the front of payload and returns it along with the remainder.
+func decodeEntry(version byte, payload []byte) (entry, []byte, error) {
+	key, payload, err := disk.ReadBytes(payload)
+	if err != nil {
+		return entry{}, nil, fmt.Errorf("%w: invalid key: %w", ErrCorrupt, err)
+	}
+	value, payload, err := disk.ReadBytes(payload)
+	if err != nil {
+		return entry{}, nil, fmt.Errorf("%w: invalid value: %w", ErrCorrupt, err)
+	}
+	e := entry{key: string(key), value: string(value)}
+
+	if version >= formatVersion2 {
+		nanos, n := binary.Varint(payload)
+		if n <= 0 {
+			return entry{}, nil, fmt.Errorf("%w: invalid expiry", ErrCorrupt)
+		}
+		if nanos != 0 {
+			e.expires = time.Unix(0, nanos).UTC()
+		}
+		payload = payload[n:]
+	}
+
+	if version >= formatVersion3 {
+		v, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return entry{}, nil, fmt.Errorf("%w: invalid version", ErrCorrupt)
+		}
+		e.version = v
+		payload = payload[n:]
+	}
+
+	if version >= formatVersion4 {
+		if e.contentType, e.metadata, payload, err = decodeDescription(payload); err != nil {
+			return entry{}, nil, err
+		}
+	}
+
+	return e, payload, nil
+}
+
+// decodeDescription decodes the content type and metadata of an entry at the front of payload and returns them along
+// with the remainder.
+func decodeDescription(payload []byte) (string, map[string]string, []byte, error) {
+	contentType, payload, err := disk.ReadBytes(payload)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("%w: invalid content type: %w", ErrCorrupt, err)
+	}
+
+	count, n := binary.Uvarint(payload)
+	// every field takes at least two bytes
+	if n <= 0 || count > uint64(len(payload)/2) {
+		return "", nil, nil, fmt.Errorf("%w: invalid metadata field count", ErrCorrupt)
+	}
+	payload = payload[n:]
+
+	var metadata map[string]string
+	for i := range count {
+		var name, value []byte
+		if name, payload, err = disk.ReadBytes(payload); err != nil {
+			return "", nil, nil, fmt.Errorf("%w: metadata field %d: invalid name: %w", ErrCorrupt, i, err)
+		}
+		if value, payload, err = disk.ReadBytes(payload); err != nil {
+			return "", nil, nil, fmt.Errorf("%w: metadata field %d: invalid value: %w", ErrCorrupt, i, err)
+		}
+		if metadata == nil {
+			metadata = make(map[string]string, count)
+		}
+		metadata[string(name)] = string(value)
+	}
+	return string(contentType), metadata, payload, nil
+}
+
+// LoadLatest loads the newest valid snapshot in dir. A snapshot which fails verification is skipped in favour of the
+// one before it, and ErrNoSnapshot is returned if there are none to fall back to.
+func LoadLatest(dir string) (Snapshot, error) {
+	return LoadThrough(dir, math.MaxUint64)
+}
+
+// LoadThrough loads the newest valid snapshot in dir which covers no events after through, falling back in the same way
+// as LoadLatest. It is the starting point for recovering the store as it was at through.
+func LoadThrough(dir string, through uint64) (Snapshot, error) {
+	seqs, err := list(dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	n, found := slices.BinarySearch(seqs, through)
+	if found {
+		n++
+	}
+
+	for _, seq := range slices.Backward(seqs[:n]) {
+		path := filepath.Join(dir, fileName(seq))
+		snap, err := Load(path)
+		if err != nil {
+			slog.Warn("skipping unreadable snapshot", slog.String("path", path), slog.Any("error", err))
+			continue
+		}
+		return snap, nil
+	}
+
+	return Snapshot{}, ErrNoSnapshot
+}
+
+// Prune deletes all but the newest retain snapshots in dir.
+func Prune(dir string, retain int) error {
+	seqs, err := list(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(seqs) <= retain {
+		return nil
+	}
+
+	for _, seq := range seqs[:len(seqs)-retain] {
+		if err = os.Remove(filepath.Join(dir, fileName(seq))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove snapshot: %w", err)
+		}
+	}
+
+	return disk.SyncDir(dir)
+}
+
+// oldest returns the sequence number of the oldest snapshot in dir, which must hold at least one.
+func oldest(dir string) (uint64, error) {
+	seqs, err := list(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(seqs) == 0 {
+		return 0, ErrNoSnapshot
+	}
+	return seqs[0], nil
+}
+
+// list returns the sequence numbers of the snapshots in dir in ascending order.
+func list(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+
+	slices.Sort(seqs)
+	return seqs, nil
+}
@@ -0,0 +1,256 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteLoad_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		snap Snapshot
+	}{
+		{
+			name: "empty",
+			snap: Snapshot{Sequence: 0, Data: map[string]string{}},
+		},
+		{
+			name: "simple",
+			snap: Snapshot{Sequence: 42, Data: map[string]string{"foo": "bar", "baz": "bing"}},
+		},
+		{
+			name: "special characters",
+			snap: Snapshot{Sequence: 7, Data: map[string]string{
+				"tab\tkey":   "value\nwith\nnewlines",
+				"":           "empty key",
+				"empty":      "",
+				"binary\x00": string([]byte{0x00, 0xff, 0xfe}),
+			}},
+		},
+		{
+			name: "expiry",
+			snap: Snapshot{
+				Sequence: 9,
+				Data:     map[string]string{"foo": "bar", "baz": "bing"},
+				Expiry:   map[string]time.Time{"foo": time.Date(2024, 5, 6, 7, 8, 9, 123, time.UTC)},
+			},
+		},
+		{
+			name: "versions",
+			snap: Snapshot{
+				Sequence: 12,
+				Data:     map[string]string{"foo": "bar", "baz": "bing"},
+				Versions: map[string]uint64{"foo": 3, "baz": 12},
+			},
+		},
+		{
+			name: "content types and metadata",
+			snap: Snapshot{
+				Sequence:     14,
+				Data:         map[string]string{"foo": "{}", "baz": "bing", "plain": "x"},
+				ContentTypes: map[string]string{"foo": "application/json", "baz": "text/plain"},
+				Metadata:     map[string]map[string]string{"foo": {"Owner": "alice", "Empty": ""}},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			path, err := Write(dir, tc.snap)
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(dir, fileName(tc.snap.Sequence)), path)
+
+			got, err := Load(path)
+			require.NoError(t, err)
+			assert.Equal(t, tc.snap, got)
+		})
+	}
+}
+
+func TestLoad_Version1(t *testing.T) {
+	payload := binary.AppendUvarint(nil, 3)
+	payload = binary.AppendUvarint(payload, 1)
+	payload = binary.AppendUvarint(payload, 3)
+	payload = append(payload, "foo"...)
+	payload = binary.AppendUvarint(payload, 3)
+	payload = append(payload, "bar"...)
+
+	data := append([]byte(magic), formatVersion1)
+	data = append(data, payload...)
+	data = binary.BigEndian.AppendUint32(data, crc32.Checksum(payload, crcTable))
+
+	path := filepath.Join(t.TempDir(), fileName(3))
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	snap, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, Snapshot{Sequence: 3, Data: map[string]string{"foo": "bar"}}, snap)
+}
+
+func TestWrite_CreatesDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "snapshots")
+
+	_, err := Write(dir, Snapshot{Sequence: 1, Data: map[string]string{"foo": "bar"}})
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary files should be left behind")
+	assert.Equal(t, fileName(1), entries[0].Name())
+}
+
+func TestLoad_Corrupt(t *testing.T) {
+	valid := &bytes.Buffer{}
+	require.NoError(t, encode(valid, Snapshot{Sequence: 3, Data: map[string]string{"foo": "bar"}}))
+
+	tests := []struct {
+		name     string
+		mutate   func([]byte) []byte
+		contains string
+	}{
+		{
+			name:     "bad magic",
+			mutate:   func(b []byte) []byte { b[0] = 'X'; return b },
+			contains: "bad magic",
+		},
+		{
+			name:     "unsupported version",
+			mutate:   func(b []byte) []byte { b[len(magic)] = 9; return b },
+			contains: "unsupported version",
+		},
+		{
+			name:     "flipped payload bit",
+			mutate:   func(b []byte) []byte { b[len(magic)+3] ^= 0x01; return b },
+			contains: "checksum mismatch",
+		},
+		{
+			name:     "truncated",
+			mutate:   func(b []byte) []byte { return b[:len(b)-6] },
+			contains: "checksum mismatch",
+		},
+		{
+			name:     "header only",
+			mutate:   func(b []byte) []byte { return b[:len(magic)+1] },
+			contains: "truncated",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), fileName(3))
+			require.NoError(t, os.WriteFile(path, tc.mutate(bytes.Clone(valid.Bytes())), 0o600))
+
+			_, err := Load(path)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, ErrCorrupt)
+			assert.ErrorContains(t, err, tc.contains)
+		})
+	}
+}
+
+func TestLoadLatest(t *testing.T) {
+	t.Run("missing directory", func(t *testing.T) {
+		_, err := LoadLatest(filepath.Join(t.TempDir(), "missing"))
+		assert.ErrorIs(t, err, ErrNoSnapshot)
+	})
+
+	t.Run("empty directory", func(t *testing.T) {
+		_, err := LoadLatest(t.TempDir())
+		assert.ErrorIs(t, err, ErrNoSnapshot)
+	})
+
+	t.Run("picks the highest sequence", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, seq := range []uint64{9, 100, 10} {
+			_, err := Write(dir, Snapshot{Sequence: seq, Data: map[string]string{"seq": string(rune('a' + seq%26))}})
+			require.NoError(t, err)
+		}
+		// unrelated files are ignored
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "snapshot-garbage.snap"), []byte("nope"), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".snapshot-123.tmp"), []byte("nope"), 0o600))
+
+		snap, err := LoadLatest(dir)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(100), snap.Sequence)
+	})
+
+	t.Run("falls back past a corrupt snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := Write(dir, Snapshot{Sequence: 5, Data: map[string]string{"foo": "old"}})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fileName(6)), []byte("corrupt"), 0o600))
+
+		snap, err := LoadLatest(dir)
+		require.NoError(t, err)
+		assert.Equal(t, Snapshot{Sequence: 5, Data: map[string]string{"foo": "old"}}, snap)
+	})
+
+	t.Run("only corrupt snapshots", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fileName(6)), []byte("corrupt"), 0o600))
+
+		_, err := LoadLatest(dir)
+		assert.ErrorIs(t, err, ErrNoSnapshot)
+	})
+}
+
+func TestLoadThrough(t *testing.T) {
+	dir := t.TempDir()
+	for _, seq := range []uint64{10, 20, 30} {
+		_, err := Write(dir, Snapshot{Sequence: seq, Data: map[string]string{}})
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		name     string
+		through  uint64
+		expected uint64
+	}{
+		{name: "exact", through: 20, expected: 20},
+		{name: "between", through: 29, expected: 20},
+		{name: "after the newest", through: 100, expected: 30},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			snap, err := LoadThrough(dir, tc.through)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, snap.Sequence)
+		})
+	}
+
+	t.Run("before the oldest", func(t *testing.T) {
+		_, err := LoadThrough(dir, 9)
+		assert.ErrorIs(t, err, ErrNoSnapshot)
+	})
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	for _, seq := range []uint64{1, 2, 3, 4} {
+		_, err := Write(dir, Snapshot{Sequence: seq, Data: map[string]string{}})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, Prune(dir, 2))
+
+	seqs, err := list(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4}, seqs)
+
+	// pruning to more than exist is a no-op
+	require.NoError(t, Prune(dir, 5))
+	seqs, err = list(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4}, seqs)
+}
@@ -0,0 +1,151 @@
+package snapshot
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Source captures a consistent snapshot of the store, i.e. one whose Data reflects every transaction log event up to
+// and including Sequence and nothing after it.
+type Source interface {
+	Capture() (Snapshot, error)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func() (Snapshot, error)
+
+func (f SourceFunc) Capture() (Snapshot, error) {
+	return f()
+}
+
+// Compactor discards transaction log events which are covered by a snapshot.
+type Compactor interface {
+	Compact(through uint64) error
+}
+
+func NewSnapshotter(dir string, source Source, compactor Compactor, opts ...Option) *Snapshotter {
+	s := &Snapshotter{
+		dir:       dir,
+		source:    source,
+		compactor: compactor,
+		interval:  5 * time.Minute,
+		retain:    2,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Snapshotter periodically snapshots the store and then compacts the transaction log up to the oldest snapshot it
+// keeps, so that startup only has to load a snapshot and replay the events written since.
+type Snapshotter struct {
+	dir       string
+	source    Source
+	compactor Compactor
+	interval  time.Duration
+	retain    int
+
+	// mu serialises snapshots taken by Run with any taken directly through Snapshot
+	mu       sync.Mutex
+	last     uint64
+	hasTaken bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type Option = func(*Snapshotter)
+
+// WithInterval sets how often Run takes a snapshot. The default is every 5 minutes.
+func WithInterval(interval time.Duration) Option {
+	return func(s *Snapshotter) {
+		s.interval = interval
+	}
+}
+
+// WithRetain sets how many snapshots are kept on disk. Keeping more than one leaves something to fall back to should
+// the newest be damaged, at the cost of keeping the transaction log back to the oldest of them. The default is 2.
+func WithRetain(retain int) Option {
+	return func(s *Snapshotter) {
+		s.retain = max(retain, 1)
+	}
+}
+
+// Snapshot captures and persists a snapshot, prunes the snapshots which are no longer retained and then compacts the
+// transaction log up to the oldest one left. It does nothing if no events have been logged since the last snapshot.
+func (s *Snapshotter) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, err := s.source.Capture()
+	if err != nil {
+		return fmt.Errorf("failed to capture snapshot: %w", err)
+	}
+
+	if s.hasTaken && snap.Sequence == s.last {
+		return nil
+	}
+
+	path, err := Write(s.dir, snap)
+	if err != nil {
+		return err
+	}
+	s.last, s.hasTaken = snap.Sequence, true
+	slog.Info("wrote snapshot", slog.String("path", path), slog.Uint64("sequence", snap.Sequence), slog.Int("keys", len(snap.Data)))
+
+	if err = Prune(s.dir, s.retain); err != nil {
+		return err
+	}
+	oldest, err := oldest(s.dir)
+	if err != nil {
+		return err
+	}
+
+	// only compact once the snapshot is safely on disk, otherwise a crash could lose the events entirely, and only
+	// through the oldest snapshot kept, so that the log still holds everything after whichever one LoadLatest falls back
+	// to
+	if err = s.compactor.Compact(oldest); err != nil {
+		return fmt.Errorf("failed to compact transaction log: %w", err)
+	}
+	return nil
+}
+
+// Run takes a snapshot every interval until Close is called.
+func (s *Snapshotter) Run() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Snapshot(); err != nil {
+					slog.Error("failed to take snapshot", slog.Any("error", err))
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the periodic snapshots started by Run.
+func (s *Snapshotter) Close() error {
+	if s.stop == nil {
+		return nil
+	}
+
+	close(s.stop)
+	<-s.done
+	return nil
+}
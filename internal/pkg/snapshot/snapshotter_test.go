@@ -0,0 +1,141 @@
+package snapshot
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCompactor records the sequences it was asked to compact through
+type fakeCompactor struct {
+	mu      sync.Mutex
+	through []uint64
+	err     error
+}
+
+func (f *fakeCompactor) Compact(through uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.through = append(f.through, through)
+	return f.err
+}
+
+func (f *fakeCompactor) Calls() []uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]uint64(nil), f.through...)
+}
+
+// staticSource returns whatever snapshot it currently holds
+type staticSource struct {
+	mu   sync.Mutex
+	snap Snapshot
+	err  error
+}
+
+func (s *staticSource) Capture() (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snap, s.err
+}
+
+func (s *staticSource) Set(snap Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snap = snap
+}
+
+func TestSnapshotter_Snapshot(t *testing.T) {
+	dir := t.TempDir()
+	source := &staticSource{snap: Snapshot{Sequence: 10, Data: map[string]string{"foo": "bar"}}}
+	compactor := &fakeCompactor{}
+	s := NewSnapshotter(dir, source, compactor)
+
+	require.NoError(t, s.Snapshot())
+
+	snap, err := LoadLatest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, source.snap, snap)
+	assert.Equal(t, []uint64{10}, compactor.Calls())
+
+	// nothing has changed, so there is nothing to do
+	require.NoError(t, s.Snapshot())
+	assert.Equal(t, []uint64{10}, compactor.Calls())
+}
+
+func TestSnapshotter_Retain(t *testing.T) {
+	dir := t.TempDir()
+	source := &staticSource{}
+	compactor := &fakeCompactor{}
+	s := NewSnapshotter(dir, source, compactor, WithRetain(2))
+
+	for seq := uint64(1); seq <= 4; seq++ {
+		source.Set(Snapshot{Sequence: seq, Data: map[string]string{}})
+		require.NoError(t, s.Snapshot())
+	}
+
+	seqs, err := list(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4}, seqs)
+	// the log is only compacted through the oldest snapshot kept, as it may have to be fallen back to
+	assert.Equal(t, []uint64{1, 1, 2, 3}, compactor.Calls())
+}
+
+func TestSnapshotter_CaptureError(t *testing.T) {
+	dir := t.TempDir()
+	compactor := &fakeCompactor{}
+	s := NewSnapshotter(dir, &staticSource{err: errors.New("capture failed")}, compactor)
+
+	err := s.Snapshot()
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "capture failed")
+
+	_, err = LoadLatest(dir)
+	assert.ErrorIs(t, err, ErrNoSnapshot)
+	assert.Empty(t, compactor.Calls(), "the log must not be compacted without a snapshot")
+}
+
+func TestSnapshotter_CompactError(t *testing.T) {
+	dir := t.TempDir()
+	source := &staticSource{snap: Snapshot{Sequence: 3, Data: map[string]string{"foo": "bar"}}}
+	s := NewSnapshotter(dir, source, &fakeCompactor{err: errors.New("compact failed")})
+
+	err := s.Snapshot()
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "compact failed")
+
+	// the snapshot itself is still usable, as the log still holds everything it covers
+	snap, err := LoadLatest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), snap.Sequence)
+}
+
+func TestSnapshotter_Run(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		dir := t.TempDir()
+		source := &staticSource{snap: Snapshot{Sequence: 1, Data: map[string]string{}}}
+		compactor := &fakeCompactor{}
+		s := NewSnapshotter(dir, source, compactor, WithInterval(time.Minute))
+		s.Run()
+
+		time.Sleep(30 * time.Second)
+		synctest.Wait()
+		assert.Empty(t, compactor.Calls())
+
+		time.Sleep(30 * time.Second)
+		synctest.Wait()
+		assert.Equal(t, []uint64{1}, compactor.Calls())
+
+		source.Set(Snapshot{Sequence: 5, Data: map[string]string{}})
+		time.Sleep(time.Minute)
+		synctest.Wait()
+		assert.Equal(t, []uint64{1, 1}, compactor.Calls(), "the first snapshot is still kept to fall back to")
+
+		require.NoError(t, s.Close())
+	})
+}
@@ -1,51 +1,470 @@
 package store
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
 
-var _ Store = (*InMemoryStore)(nil)
+var _ Memory = (*InMemoryStore)(nil)
+
+// tombstoneAge is how long the version a key was deleted at is remembered for. It only has to outlast a write which was
+// logged before the delete but is still on its way to the store, which takes no longer than the request making it.
+const tombstoneAge = time.Minute
 
 func NewInMemoryStore(opts ...InMemoryOption) *InMemoryStore {
 	store := &InMemoryStore{
-		store: make(map[string]string),
+		store:        make(map[string]string),
+		expiry:       make(map[string]time.Time),
+		versions:     make(map[string]uint64),
+		contentTypes: make(map[string]string),
+		metadata:     make(map[string]map[string]string),
+		tombstones:   make(map[string]tombstone),
 	}
 
 	for _, opt := range opts {
 		opt(store)
 	}
+	store.keys = slices.Sorted(maps.Keys(store.store))
 
 	return store
 }
 
+// InMemoryStore is a Store held in memory. Keys which expire are no longer found once they have, and are removed
+// either by the next Get of them or by Sweep, whichever comes first.
+//
+// Versions follow the sequence numbers of the transaction log: a write replayed from the log is given the sequence of
+// its event with WithVersion, and any other write, including a delete, takes the next version after the highest the
+// store has seen, which is the sequence the log gives its event when the store is written in the same order as the log.
+// It need not be: a write given a version older than the key already has, such as one logged before a write which
+// another replica's follower got to the store first, is ignored, so that the key keeps the newer value.
 type InMemoryStore struct {
-	rw    sync.RWMutex
+	rw sync.RWMutex
+	// store holds the value of each key as a string, which can't be changed by whoever put it
 	store map[string]string
+	// keys holds every key in store in order, for List
+	keys []string
+	// expiry holds when each key which expires does so, keys which never expire have no entry
+	expiry map[string]time.Time
+	// versions holds the version of each key, and version the highest version given to any write
+	versions map[string]uint64
+	version  uint64
+	// contentTypes and metadata hold what each key was put with, keys put without either having no entry
+	contentTypes map[string]string
+	metadata     map[string]map[string]string
+	// tombstones holds the version each recently deleted key was deleted at, until Sweep forgets it
+	tombstones map[string]tombstone
+}
+
+// tombstone records the version a key was deleted at and when, so that a write older than the delete can't bring the
+// key back.
+type tombstone struct {
+	version uint64
+	at      time.Time
 }
 
-func (s *InMemoryStore) Put(key, value string) error {
+func (s *InMemoryStore) Put(ctx context.Context, key string, value []byte, opts ...WriteOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	op := PutOp(key, value, opts...)
+
 	s.rw.Lock()
 	defer s.rw.Unlock()
-	s.store[key] = value
-	return nil
+	return s.write([]Op{op}, op.opts.version)
+}
+
+func (s *InMemoryStore) Get(ctx context.Context, key string) (Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return Entry{}, err
+	}
+
+	s.rw.RLock()
+	entry, ok := s.entry(key)
+	expires, expiring := s.expiry[key]
+	s.rw.RUnlock()
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+
+	if expiring && !expires.After(time.Now()) {
+		s.expire(key)
+		return Entry{}, ErrNotFound
+	}
+
+	return entry, nil
 }
 
-func (s *InMemoryStore) Get(key string) (string, error) {
+func (s *InMemoryStore) GetMany(ctx context.Context, keys []string) (map[string]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
 	s.rw.RLock()
 	defer s.rw.RUnlock()
+	entries := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if entry, ok := s.lookup(key, now); ok {
+			entries[key] = entry
+		}
+	}
+	return entries, nil
+}
+
+// lookup returns the entry of key if it exists and hasn't expired by now. As with List, an expired key is left for Get
+// or Sweep to remove. The caller must hold the lock.
+func (s *InMemoryStore) lookup(key string, now time.Time) (Entry, bool) {
+	if expires, expiring := s.expiry[key]; expiring && !expires.After(now) {
+		return Entry{}, false
+	}
+	return s.entry(key)
+}
+
+// entry returns a copy of the entry of key if it exists, whether or not it has expired. The caller must hold the lock.
+func (s *InMemoryStore) entry(key string) (Entry, bool) {
 	value, ok := s.store[key]
 	if !ok {
-		return "", ErrNotFound
+		return Entry{}, false
+	}
+	return Entry{
+		Value:       []byte(value),
+		Version:     s.versions[key],
+		ContentType: s.contentTypes[key],
+		Metadata:    maps.Clone(s.metadata[key]),
+	}, true
+}
+
+func (s *InMemoryStore) Delete(ctx context.Context, key string, opts ...WriteOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	op := DeleteOp(key, opts...)
 
-	return value, nil
+	s.rw.Lock()
+	defer s.rw.Unlock()
+	return s.write([]Op{op}, op.opts.version)
 }
 
-func (s *InMemoryStore) Delete(key string) error {
+func (s *InMemoryStore) Batch(ctx context.Context, ops []Op, opts ...WriteOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	o := newWriteOptions(opts...)
+
 	s.rw.Lock()
 	defer s.rw.Unlock()
-	delete(s.store, key)
+	return s.write(ops, o.version)
+}
+
+// write applies ops once every one of their preconditions has been checked, giving the keys they write version, or
+// the next version along if it's zero. The caller must hold the write lock.
+func (s *InMemoryStore) write(ops []Op, version uint64) error {
+	for _, op := range ops {
+		if err := s.check(op.Key, op.opts); err != nil {
+			return fmt.Errorf("%w for key %q", err, op.Key)
+		}
+	}
+	version = s.nextVersion(version)
+
+	now := time.Now()
+	for _, op := range ops {
+		s.apply(op, version, now)
+	}
+	return nil
+}
+
+// apply makes the change of op, whose precondition has already been checked, giving the key it writes version. It does
+// nothing if the key has been written or deleted at a later version already. The caller must hold the write lock.
+func (s *InMemoryStore) apply(op Op, version uint64, now time.Time) {
+	if version < s.latest(op.Key) {
+		return
+	}
+
+	switch {
+	case op.Delete:
+		s.bury(op.Key, version, now)
+	case op.opts.expires.IsZero():
+		s.set(op.Key, string(op.Value), op.opts)
+		delete(s.expiry, op.Key)
+		s.versions[op.Key] = version
+	case op.opts.expires.After(now):
+		s.set(op.Key, string(op.Value), op.opts)
+		s.expiry[op.Key] = op.opts.expires
+		s.versions[op.Key] = version
+	default:
+		// replaying a put which has expired since it was logged mustn't bring the key back
+		s.bury(op.Key, version, now)
+	}
+}
+
+// latest returns the version key was last written or deleted at, or zero if neither is known. The caller must hold the
+// lock.
+func (s *InMemoryStore) latest(key string) uint64 {
+	if version, ok := s.versions[key]; ok {
+		return version
+	}
+	return s.tombstones[key].version
+}
+
+// bury removes key, leaving a tombstone recording that it was deleted at version. The caller must hold the write lock.
+func (s *InMemoryStore) bury(key string, version uint64, now time.Time) {
+	s.remove(key)
+	s.tombstones[key] = tombstone{version: version, at: now}
+}
+
+func (s *InMemoryStore) List(ctx context.Context, opts ...ListOption) ([]Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	o := newListOptions(opts...)
+	now := time.Now()
+
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+	return s.list(o, now), nil
+}
+
+// list returns the items List returns with o. The caller must hold the lock.
+func (s *InMemoryStore) list(o listOptions, now time.Time) []Item {
+	var items []Item
+	for _, key := range s.keys[s.first(o):] {
+		if !strings.HasPrefix(key, o.prefix) || (o.limit > 0 && len(items) == o.limit) {
+			break
+		}
+		// expired keys are skipped rather than removed, which would need the write lock
+		if expires, ok := s.expiry[key]; ok && !expires.After(now) {
+			continue
+		}
+		entry, _ := s.entry(key)
+		items = append(items, Item{Entry: entry, Key: key})
+	}
+	return items
+}
+
+// first returns the index of the first key in the index which List can return with o. The caller must hold the lock.
+func (s *InMemoryStore) first(o listOptions) int {
+	first, _ := slices.BinarySearch(s.keys, o.prefix)
+	if o.after < o.prefix {
+		return first
+	}
+	after, found := slices.BinarySearch(s.keys, o.after)
+	if found {
+		after++
+	}
+	return max(first, after)
+}
+
+// check returns ErrVersionMismatch if o makes the write conditional on a version key isn't at. A key which has expired
+// is treated as not existing. The caller must hold the write lock.
+func (s *InMemoryStore) check(key string, o writeOptions) error {
+	if !o.conditional {
+		return nil
+	}
+
+	_, exists := s.store[key]
+	if expires, ok := s.expiry[key]; ok && !expires.After(time.Now()) {
+		exists = false
+	}
+
+	if o.ifVersion == 0 {
+		if exists {
+			return ErrVersionMismatch
+		}
+		return nil
+	}
+	if !exists || s.versions[key] != o.ifVersion {
+		return ErrVersionMismatch
+	}
 	return nil
 }
 
+// nextVersion returns the version for a write given version, zero meaning the next one along. The caller must hold the
+// write lock.
+func (s *InMemoryStore) nextVersion(version uint64) uint64 {
+	if version == 0 {
+		s.version++
+		return s.version
+	}
+	s.version = max(s.version, version)
+	return version
+}
+
+// set sets the value of key, along with the content type and metadata of o, adding it to the index if it's new and
+// replacing any tombstone. The caller must hold the write lock.
+func (s *InMemoryStore) set(key, value string, o writeOptions) {
+	if _, ok := s.store[key]; !ok {
+		i, _ := slices.BinarySearch(s.keys, key)
+		s.keys = slices.Insert(s.keys, i, key)
+	}
+	s.store[key] = value
+	delete(s.tombstones, key)
+
+	if o.contentType == "" {
+		delete(s.contentTypes, key)
+	} else {
+		s.contentTypes[key] = o.contentType
+	}
+	if len(o.metadata) == 0 {
+		delete(s.metadata, key)
+	} else {
+		s.metadata[key] = maps.Clone(o.metadata)
+	}
+}
+
+// remove deletes key along with everything else held for it, including its place in the index. The caller must hold the
+// write lock.
+func (s *InMemoryStore) remove(key string) {
+	if _, ok := s.store[key]; ok {
+		i, _ := slices.BinarySearch(s.keys, key)
+		s.keys = slices.Delete(s.keys, i, i+1)
+	}
+	s.forget(key)
+}
+
+// forget deletes key along with everything else held for it, leaving the index to be rebuilt. The caller must hold the
+// write lock.
+func (s *InMemoryStore) forget(key string) {
+	delete(s.store, key)
+	delete(s.expiry, key)
+	delete(s.versions, key)
+	delete(s.contentTypes, key)
+	delete(s.metadata, key)
+}
+
+// expire removes key if it has expired, which it may no longer have if it was put again since it was last looked at.
+func (s *InMemoryStore) expire(key string) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+	if expires, ok := s.expiry[key]; ok && !expires.After(time.Now()) {
+		s.remove(key)
+	}
+}
+
+// Sweep removes every key which has expired, returning how many it removed. It also forgets the versions of keys
+// deleted long enough ago that no write older than the delete can still arrive.
+func (s *InMemoryStore) Sweep() int {
+	now := time.Now()
+
+	s.rw.Lock()
+	defer s.rw.Unlock()
+	for key, t := range s.tombstones {
+		if now.Sub(t.at) >= tombstoneAge {
+			delete(s.tombstones, key)
+		}
+	}
+
+	removed := 0
+	for key, expires := range s.expiry {
+		if !expires.After(now) {
+			s.forget(key)
+			removed++
+		}
+	}
+	// removing the keys from the index one at a time would move the rest of it along for each
+	if removed > 0 {
+		s.keys = slices.DeleteFunc(s.keys, func(key string) bool {
+			_, ok := s.store[key]
+			return !ok
+		})
+	}
+	return removed
+}
+
+// Len returns the number of keys in the store, including those which have expired but haven't been removed yet.
+func (s *InMemoryStore) Len() int {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+	return len(s.store)
+}
+
+// Contents is a copy of everything held by a Memory store.
+type Contents struct {
+	Data map[string]string
+	// Expiry holds when each key which expires does so.
+	Expiry map[string]time.Time
+	// Versions holds the version of each key, and Version the highest version given to any write.
+	Versions map[string]uint64
+	Version  uint64
+	// ContentTypes and Metadata hold what each key was put with, keys put without either missing from them.
+	ContentTypes map[string]string
+	Metadata     map[string]map[string]string
+}
+
+// Snapshot returns a copy of the store's contents.
+func (s *InMemoryStore) Snapshot() Contents {
+	s.rw.RLock()
+	defer s.rw.RUnlock()
+	return Contents{
+		Data:         maps.Clone(s.store),
+		Expiry:       maps.Clone(s.expiry),
+		Versions:     maps.Clone(s.versions),
+		Version:      s.version,
+		ContentTypes: maps.Clone(s.contentTypes),
+		// the metadata of a key is replaced rather than changed, so the copy can share it
+		Metadata: maps.Clone(s.metadata),
+	}
+}
+
+// Restore replaces the store's contents with c, such as a snapshot, which the store takes ownership of. Keys which have
+// already expired are left out, and keys without a version are given c.Version, as they were written no later than
+// that.
+func (s *InMemoryStore) Restore(c Contents) {
+	c = c.prepare(time.Now())
+	keys := slices.Sorted(maps.Keys(c.Data))
+
+	s.rw.Lock()
+	defer s.rw.Unlock()
+	s.replace(c, keys)
+}
+
+// replace sets the store's contents to c, whose keys are given in order. The caller must hold the write lock.
+func (s *InMemoryStore) replace(c Contents, keys []string) {
+	s.store, s.expiry, s.versions, s.version, s.keys = c.Data, c.Expiry, c.Versions, c.Version, keys
+	s.contentTypes, s.metadata = c.ContentTypes, c.Metadata
+	s.tombstones = make(map[string]tombstone)
+}
+
+// prepare readies c to be restored at now, leaving out the keys which have expired and giving those without a version
+// c.Version.
+func (c Contents) prepare(now time.Time) Contents {
+	if c.Expiry == nil {
+		c.Expiry = make(map[string]time.Time)
+	}
+	if c.Versions == nil {
+		c.Versions = make(map[string]uint64, len(c.Data))
+	}
+	if c.ContentTypes == nil {
+		c.ContentTypes = make(map[string]string)
+	}
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]map[string]string)
+	}
+
+	for key, expires := range c.Expiry {
+		if !expires.After(now) {
+			delete(c.Data, key)
+			delete(c.Expiry, key)
+			delete(c.Versions, key)
+			delete(c.ContentTypes, key)
+			delete(c.Metadata, key)
+		}
+	}
+	for key := range c.Data {
+		if _, ok := c.Versions[key]; !ok && c.Version != 0 {
+			c.Versions[key] = c.Version
+		}
+	}
+	for _, version := range c.Versions {
+		c.Version = max(c.Version, version)
+	}
+	return c
+}
+
 type InMemoryOption = func(*InMemoryStore)
 
 func WithStorage(storage map[string]string) InMemoryOption {
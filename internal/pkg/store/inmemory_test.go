@@ -1,9 +1,11 @@
 package store_test
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
+	"testing/synctest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
@@ -17,17 +19,17 @@ func TestPut(t *testing.T) {
 	s := store.NewInMemoryStore(store.WithStorage(testStorage))
 	require.Empty(t, testStorage)
 
-	err := s.Put("foo", "bar")
+	err := s.Put(t.Context(), "foo", []byte("bar"))
 	assert.NoError(t, err)
 	assert.Len(t, testStorage, 1)
 	assert.Equal(t, testStorage["foo"], "bar")
 
-	err = s.Put("foo", "baz")
+	err = s.Put(t.Context(), "foo", []byte("baz"))
 	assert.NoError(t, err)
 	assert.Len(t, testStorage, 1)
 	assert.Equal(t, testStorage["foo"], "baz")
 
-	err = s.Put("baz", "bing")
+	err = s.Put(t.Context(), "baz", []byte("bing"))
 	assert.NoError(t, err)
 	assert.Len(t, testStorage, 2)
 	assert.Equal(t, testStorage["baz"], "bing")
@@ -38,17 +40,17 @@ func TestGet(t *testing.T) {
 	s := store.NewInMemoryStore(store.WithStorage(testStorage))
 	require.Empty(t, testStorage)
 
-	err := s.Put("foo", "bar")
+	err := s.Put(t.Context(), "foo", []byte("bar"))
 	require.NoError(t, err)
-	err = s.Put("bar", "baz")
+	err = s.Put(t.Context(), "bar", []byte("baz"))
 	require.NoError(t, err)
 	require.Len(t, testStorage, 2)
 
-	got, err := s.Get("foo")
+	got, err := s.Get(t.Context(), "foo")
 	assert.NoError(t, err)
-	assert.Equal(t, "bar", got)
+	assert.Equal(t, []byte("bar"), got.Value)
 
-	got, err = s.Get("baz")
+	got, err = s.Get(t.Context(), "baz")
 	assert.ErrorIs(t, store.ErrNotFound, err)
 	assert.Empty(t, got)
 }
@@ -58,15 +60,15 @@ func TestDelete(t *testing.T) {
 	s := store.NewInMemoryStore(store.WithStorage(testStorage))
 	require.Empty(t, testStorage)
 
-	err := s.Put("foo", "bar")
+	err := s.Put(t.Context(), "foo", []byte("bar"))
 	require.NoError(t, err)
 	require.Len(t, testStorage, 1)
 
-	err = s.Delete("bar")
+	err = s.Delete(t.Context(), "bar")
 	assert.NoError(t, err)
 	assert.Len(t, testStorage, 1)
 
-	err = s.Delete("foo")
+	err = s.Delete(t.Context(), "foo")
 	assert.NoError(t, err)
 	assert.Len(t, testStorage, 0)
 }
@@ -74,21 +76,21 @@ func TestDelete(t *testing.T) {
 func TestNewInMemoryStore(t *testing.T) {
 	s := store.NewInMemoryStore()
 
-	got, err := s.Get("foo")
+	got, err := s.Get(t.Context(), "foo")
 	assert.ErrorIs(t, store.ErrNotFound, err)
 	assert.Empty(t, got)
 
-	err = s.Put("foo", "bar")
+	err = s.Put(t.Context(), "foo", []byte("bar"))
 	assert.NoError(t, err)
 
-	got, err = s.Get("foo")
+	got, err = s.Get(t.Context(), "foo")
 	assert.NoError(t, err)
-	assert.Equal(t, "bar", got)
+	assert.Equal(t, []byte("bar"), got.Value)
 
-	err = s.Delete("foo")
+	err = s.Delete(t.Context(), "foo")
 	assert.NoError(t, err)
 
-	got, err = s.Get("foo")
+	got, err = s.Get(t.Context(), "foo")
 	assert.ErrorIs(t, store.ErrNotFound, err)
 	assert.Empty(t, got)
 }
@@ -98,9 +100,9 @@ func TestWithStorage(t *testing.T) {
 	testStorage["foo"] = "bar"
 	s := store.NewInMemoryStore(store.WithStorage(testStorage))
 
-	got, err := s.Get("foo")
+	got, err := s.Get(t.Context(), "foo")
 	assert.NoError(t, err)
-	assert.Equal(t, "bar", got)
+	assert.Equal(t, []byte("bar"), got.Value)
 }
 
 // this test must be run with 'go test -race'
@@ -130,17 +132,17 @@ func TestInMemoryStore_Concurrency(t *testing.T) {
 			for j := range numOperations {
 				switch j % 3 {
 				case 0:
-					got, err := s.Get(testKey)
+					got, err := s.Get(t.Context(), testKey)
 					if err != nil {
 						assert.ErrorIs(t, store.ErrNotFound, err)
 					} else {
-						assert.NotEmpty(t, got)
+						assert.NotEmpty(t, got.Value)
 					}
 				case 1:
-					err := s.Put(testKey, fmt.Sprintf("%v-%v", routineID, j))
+					err := s.Put(t.Context(), testKey, []byte(fmt.Sprintf("%v-%v", routineID, j)))
 					assert.NoError(t, err)
 				case 2:
-					err := s.Delete(testKey)
+					err := s.Delete(t.Context(), testKey)
 					assert.NoError(t, err)
 				}
 			}
@@ -156,3 +158,453 @@ func TestInMemoryStore_Concurrency(t *testing.T) {
 	// Wait for all goroutines to complete
 	completed.Wait()
 }
+
+func TestSnapshot(t *testing.T) {
+	testStorage := map[string]string{"foo": "bar", "baz": "bing"}
+	s := store.NewInMemoryStore(store.WithStorage(testStorage))
+
+	snap := s.Snapshot()
+	assert.Equal(t, testStorage, snap.Data)
+	assert.Empty(t, snap.Expiry)
+
+	// the snapshot must be a copy, unaffected by later writes
+	err := s.Put(t.Context(), "foo", []byte("changed"))
+	require.NoError(t, err)
+	err = s.Delete(t.Context(), "baz")
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"foo": "bar", "baz": "bing"}, snap.Data)
+}
+
+func TestRestore(t *testing.T) {
+	s := store.NewInMemoryStore(store.WithStorage(map[string]string{"foo": "bar"}))
+
+	s.Restore(store.Contents{Data: map[string]string{"baz": "bing"}})
+
+	_, err := s.Get(t.Context(), "foo")
+	require.ErrorIs(t, err, store.ErrNotFound)
+	got, err := s.Get(t.Context(), "baz")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bing"), got.Value)
+}
+
+func TestRestore_Expiry(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := store.NewInMemoryStore()
+
+		now := time.Now()
+		s.Restore(store.Contents{
+			Data:   map[string]string{"expired": "a", "expiring": "b", "forever": "c"},
+			Expiry: map[string]time.Time{"expired": now.Add(-time.Second), "expiring": now.Add(time.Minute)},
+		})
+
+		snap := s.Snapshot()
+		assert.Equal(t, map[string]string{"expiring": "b", "forever": "c"}, snap.Data)
+		assert.Equal(t, map[string]time.Time{"expiring": now.Add(time.Minute)}, snap.Expiry)
+
+		time.Sleep(time.Minute)
+		_, err := s.Get(t.Context(), "expiring")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	})
+}
+
+func TestLen(t *testing.T) {
+	s := store.NewInMemoryStore(store.WithStorage(map[string]string{"foo": "bar", "baz": "bing"}))
+	assert.Equal(t, 2, s.Len())
+
+	require.NoError(t, s.Delete(t.Context(), "foo"))
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestCancelled(t *testing.T) {
+	testStorage := map[string]string{"foo": "bar"}
+	s := store.NewInMemoryStore(store.WithStorage(testStorage))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	assert.ErrorIs(t, s.Put(ctx, "baz", []byte("bing")), context.Canceled)
+	_, err := s.Get(ctx, "foo")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, s.Delete(ctx, "foo"), context.Canceled)
+	_, err = s.List(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, s.Batch(ctx, []store.Op{store.DeleteOp("foo")}), context.Canceled)
+	_, err = s.GetMany(ctx, []string{"foo"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, map[string]string{"foo": "bar"}, testStorage)
+}
+
+func TestExpiry(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		testStorage := make(map[string]string)
+		s := store.NewInMemoryStore(store.WithStorage(testStorage))
+
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("bar"), store.ExpiresAt(time.Now().Add(time.Minute))))
+		require.NoError(t, s.Put(t.Context(), "baz", []byte("bing"), store.ExpiresAt(time.Now().Add(time.Hour))))
+		require.NoError(t, s.Put(t.Context(), "forever", []byte("young")))
+
+		time.Sleep(time.Minute - time.Nanosecond)
+		got, err := s.Get(t.Context(), "foo")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("bar"), got.Value)
+
+		// expired keys are no longer found, and are removed as they are looked up
+		time.Sleep(time.Nanosecond)
+		_, err = s.Get(t.Context(), "foo")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+		assert.NotContains(t, testStorage, "foo")
+
+		// putting a key again without an expiry keeps it for good
+		require.NoError(t, s.Put(t.Context(), "baz", []byte("bong")))
+		time.Sleep(time.Hour)
+		got, err = s.Get(t.Context(), "baz")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("bong"), got.Value)
+
+		// while putting one which has already expired removes it
+		require.NoError(t, s.Put(t.Context(), "forever", []byte("old"), store.ExpiresAt(time.Now().Add(-time.Second))))
+		assert.NotContains(t, testStorage, "forever")
+	})
+}
+
+func TestSweep(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		testStorage := make(map[string]string)
+		s := store.NewInMemoryStore(store.WithStorage(testStorage))
+
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("bar"), store.ExpiresAt(time.Now().Add(time.Minute))))
+		require.NoError(t, s.Put(t.Context(), "baz", []byte("bing"), store.ExpiresAt(time.Now().Add(time.Hour))))
+		require.NoError(t, s.Put(t.Context(), "forever", []byte("young")))
+
+		assert.Equal(t, 0, s.Sweep())
+		time.Sleep(time.Minute)
+		assert.Equal(t, 1, s.Sweep())
+		assert.Equal(t, map[string]string{"baz": "bing", "forever": "young"}, testStorage)
+	})
+}
+
+func TestVersions(t *testing.T) {
+	s := store.NewInMemoryStore()
+
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("bar")))
+	require.NoError(t, s.Put(t.Context(), "baz", []byte("bing")))
+	got, err := s.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{Value: []byte("bar"), Version: 1}, got)
+
+	// deletes take a version too, as they take a sequence number in the transaction log
+	require.NoError(t, s.Delete(t.Context(), "baz"))
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("changed")))
+	got, err = s.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{Value: []byte("changed"), Version: 4}, got)
+
+	// replayed writes keep their own version, and later writes carry on after the highest seen
+	require.NoError(t, s.Put(t.Context(), "baz", []byte("bong"), store.WithVersion(10)))
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("again")))
+	got, err = s.Get(t.Context(), "baz")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{Value: []byte("bong"), Version: 10}, got)
+	got, err = s.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{Value: []byte("again"), Version: 11}, got)
+}
+
+func TestOutOfOrderWrites(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := store.NewInMemoryStore()
+
+		// another replica's write, logged after ours, reaches the store first through the follower
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("newer"), store.WithVersion(11)))
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("older"), store.WithVersion(10)))
+		got, err := s.Get(t.Context(), "foo")
+		require.NoError(t, err)
+		assert.Equal(t, store.Entry{Value: []byte("newer"), Version: 11}, got)
+
+		// a delete holds the key back from older writes just the same, batches included
+		require.NoError(t, s.Delete(t.Context(), "foo", store.WithVersion(13)))
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("older"), store.WithVersion(12)))
+		require.NoError(t, s.Batch(t.Context(), []store.Op{store.PutOp("foo", []byte("older"))}, store.WithVersion(12)))
+		_, err = s.Get(t.Context(), "foo")
+		require.ErrorIs(t, err, store.ErrNotFound)
+
+		// writes which don't give a version are always the newest
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("next")))
+		got, err = s.Get(t.Context(), "foo")
+		require.NoError(t, err)
+		assert.Equal(t, store.Entry{Value: []byte("next"), Version: 14}, got)
+
+		// the delete is forgotten once nothing logged before it can still be on its way
+		require.NoError(t, s.Delete(t.Context(), "foo", store.WithVersion(16)))
+		time.Sleep(time.Minute)
+		s.Sweep()
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("late"), store.WithVersion(15)))
+		got, err = s.Get(t.Context(), "foo")
+		require.NoError(t, err)
+		assert.Equal(t, store.Entry{Value: []byte("late"), Version: 15}, got)
+	})
+}
+
+func TestConditionalWrites(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		testStorage := make(map[string]string)
+		s := store.NewInMemoryStore(store.WithStorage(testStorage))
+
+		// a version of zero means the key mustn't exist yet
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("bar"), store.IfVersion(0)))
+		require.ErrorIs(t, s.Put(t.Context(), "foo", []byte("baz"), store.IfVersion(0)), store.ErrVersionMismatch)
+
+		require.ErrorIs(t, s.Put(t.Context(), "foo", []byte("baz"), store.IfVersion(2)), store.ErrVersionMismatch)
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("baz"), store.IfVersion(1)))
+		assert.Equal(t, "baz", testStorage["foo"])
+
+		require.ErrorIs(t, s.Delete(t.Context(), "foo", store.IfVersion(1)), store.ErrVersionMismatch)
+		require.NoError(t, s.Delete(t.Context(), "foo", store.IfVersion(2)))
+		assert.NotContains(t, testStorage, "foo")
+		require.ErrorIs(t, s.Put(t.Context(), "foo", []byte("bar"), store.IfVersion(2)), store.ErrVersionMismatch)
+
+		// a key which has expired no longer exists, even before it has been removed
+		require.NoError(t, s.Put(t.Context(), "expiring", []byte("a"), store.ExpiresAt(time.Now().Add(time.Minute))))
+		time.Sleep(time.Minute)
+		require.NoError(t, s.Put(t.Context(), "expiring", []byte("b"), store.IfVersion(0)))
+	})
+}
+
+func TestRestore_Versions(t *testing.T) {
+	s := store.NewInMemoryStore()
+
+	// keys restored without a version, such as from an older snapshot, take the version the snapshot was taken at
+	s.Restore(store.Contents{
+		Data:     map[string]string{"foo": "bar", "baz": "bing"},
+		Versions: map[string]uint64{"foo": 3},
+		Version:  7,
+	})
+	require.NoError(t, s.Put(t.Context(), "new", []byte("key")))
+
+	snap := s.Snapshot()
+	assert.Equal(t, map[string]uint64{"foo": 3, "baz": 7, "new": 8}, snap.Versions)
+	assert.Equal(t, uint64(8), snap.Version)
+}
+
+func keysOf(items []store.Item) []string {
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	return keys
+}
+
+func TestList(t *testing.T) {
+	s := store.NewInMemoryStore(store.WithStorage(map[string]string{"b": "2", "a/x": "3"}))
+	require.NoError(t, s.Put(t.Context(), "a/z", []byte("4")))
+	require.NoError(t, s.Put(t.Context(), "a", []byte("1")))
+	require.NoError(t, s.Put(t.Context(), "a/y", []byte("5")))
+	require.NoError(t, s.Put(t.Context(), "c", []byte("6")))
+	require.NoError(t, s.Delete(t.Context(), "c"))
+
+	tests := []struct {
+		name string
+		opts []store.ListOption
+		want []string
+	}{
+		{name: "all", want: []string{"a", "a/x", "a/y", "a/z", "b"}},
+		{name: "prefix", opts: []store.ListOption{store.WithPrefix("a/")}, want: []string{"a/x", "a/y", "a/z"}},
+		{name: "no match", opts: []store.ListOption{store.WithPrefix("d")}, want: []string{}},
+		{name: "limit", opts: []store.ListOption{store.WithLimit(2)}, want: []string{"a", "a/x"}},
+		{name: "after", opts: []store.ListOption{store.StartAfter("a/x")}, want: []string{"a/y", "a/z", "b"}},
+		{name: "after missing key", opts: []store.ListOption{store.StartAfter("a/xx")}, want: []string{"a/y", "a/z", "b"}},
+		{
+			name: "after before prefix",
+			opts: []store.ListOption{store.WithPrefix("a/"), store.StartAfter("a")},
+			want: []string{"a/x", "a/y", "a/z"},
+		},
+		{
+			name: "page",
+			opts: []store.ListOption{store.WithPrefix("a/"), store.StartAfter("a/x"), store.WithLimit(1)},
+			want: []string{"a/y"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			items, err := s.List(t.Context(), tc.opts...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, keysOf(items))
+		})
+	}
+
+	items, err := s.List(t.Context(), store.WithPrefix("a/y"))
+	require.NoError(t, err)
+	assert.Equal(t, []store.Item{{Entry: store.Entry{Value: []byte("5"), Version: 3}, Key: "a/y"}}, items)
+}
+
+func TestList_Expiry(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := store.NewInMemoryStore()
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("bar"), store.ExpiresAt(time.Now().Add(time.Minute))))
+		require.NoError(t, s.Put(t.Context(), "baz", []byte("bing")))
+		require.NoError(t, s.Put(t.Context(), "fizz", []byte("buzz"), store.ExpiresAt(time.Now().Add(time.Minute))))
+
+		time.Sleep(time.Minute)
+		items, err := s.List(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"baz"}, keysOf(items))
+
+		// sweeping removes the expired keys from the index, so putting them again lists them once
+		assert.Equal(t, 2, s.Sweep())
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("again")))
+		items, err = s.List(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"baz", "foo"}, keysOf(items))
+	})
+}
+
+func TestList_Restore(t *testing.T) {
+	s := store.NewInMemoryStore()
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("bar")))
+
+	s.Restore(store.Contents{Data: map[string]string{"b": "2", "a": "1"}})
+	items, err := s.List(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, keysOf(items))
+
+	require.NoError(t, s.Delete(t.Context(), "a"))
+	items, err = s.List(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, keysOf(items))
+}
+
+func TestBatch(t *testing.T) {
+	s := store.NewInMemoryStore()
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("bar")))
+	require.NoError(t, s.Put(t.Context(), "baz", []byte("bing")))
+
+	// every key the batch writes takes the same version
+	require.NoError(t, s.Batch(t.Context(), []store.Op{
+		store.PutOp("foo", []byte("changed"), store.IfVersion(1)),
+		store.DeleteOp("baz", store.IfVersion(2)),
+		store.PutOp("new", []byte("key"), store.IfVersion(0)),
+	}))
+	assert.Equal(t, map[string]string{"foo": "changed", "new": "key"}, s.Snapshot().Data)
+	assert.Equal(t, map[string]uint64{"foo": 3, "new": 3}, s.Snapshot().Versions)
+
+	// a single precondition which doesn't hold turns the whole batch away
+	err := s.Batch(t.Context(), []store.Op{
+		store.PutOp("foo", []byte("again"), store.IfVersion(3)),
+		store.DeleteOp("new", store.IfVersion(1)),
+	})
+	require.ErrorIs(t, err, store.ErrVersionMismatch)
+	assert.ErrorContains(t, err, `"new"`)
+	assert.Equal(t, map[string]string{"foo": "changed", "new": "key"}, s.Snapshot().Data)
+	assert.Equal(t, uint64(3), s.Snapshot().Version)
+
+	// a replayed batch keeps its version
+	require.NoError(t, s.Batch(t.Context(), []store.Op{store.PutOp("foo", []byte("replayed"))}, store.WithVersion(7)))
+	got, err := s.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{Value: []byte("replayed"), Version: 7}, got)
+}
+
+func TestBatch_Expiry(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := store.NewInMemoryStore()
+
+		require.NoError(t, s.Batch(t.Context(), []store.Op{
+			store.PutOp("foo", []byte("bar"), store.ExpiresAt(time.Now().Add(time.Minute))),
+			store.PutOp("baz", []byte("bing")),
+			store.PutOp("gone", []byte("already"), store.ExpiresAt(time.Now().Add(-time.Second))),
+		}))
+		assert.Equal(t, map[string]string{"foo": "bar", "baz": "bing"}, s.Snapshot().Data)
+
+		time.Sleep(time.Minute)
+		_, err := s.Get(t.Context(), "foo")
+		assert.ErrorIs(t, err, store.ErrNotFound)
+	})
+}
+
+func TestGetMany(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := store.NewInMemoryStore()
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("bar")))
+		require.NoError(t, s.Put(t.Context(), "baz", []byte("bing"), store.ExpiresAt(time.Now().Add(time.Minute))))
+		require.NoError(t, s.Put(t.Context(), "fizz", []byte("buzz")))
+
+		entries, err := s.GetMany(t.Context(), []string{"foo", "baz", "missing"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]store.Entry{"foo": {Value: []byte("bar"), Version: 1}, "baz": {Value: []byte("bing"), Version: 2}}, entries)
+
+		// expired keys are missing
+		time.Sleep(time.Minute)
+		entries, err = s.GetMany(t.Context(), []string{"foo", "baz"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]store.Entry{"foo": {Value: []byte("bar"), Version: 1}}, entries)
+
+		entries, err = s.GetMany(t.Context(), nil)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
+
+func TestBinaryValues(t *testing.T) {
+	s := store.NewInMemoryStore()
+	value := []byte{0x00, 0xff, 0xfe, '\n', 0x80}
+	require.NoError(t, s.Put(t.Context(), "blob", value))
+
+	// the store keeps its own copy, so changing the slice put or the one got doesn't change the key
+	value[0] = 'x'
+	got, err := s.Get(t.Context(), "blob")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0xff, 0xfe, '\n', 0x80}, got.Value)
+
+	got.Value[1] = 'y'
+	got, err = s.Get(t.Context(), "blob")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0xff, 0xfe, '\n', 0x80}, got.Value)
+}
+
+func TestMetadata(t *testing.T) {
+	s := store.NewInMemoryStore()
+	metadata := map[string]string{"Owner": "alice"}
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("{}"),
+		store.WithContentType("application/json"), store.WithMetadata(metadata)))
+
+	want := store.Entry{
+		Value: []byte("{}"), Version: 1, ContentType: "application/json", Metadata: map[string]string{"Owner": "alice"},
+	}
+	got, err := s.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	items, err := s.List(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []store.Item{{Entry: want, Key: "foo"}}, items)
+	entries, err := s.GetMany(t.Context(), []string{"foo"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]store.Entry{"foo": want}, entries)
+
+	// the store keeps its own copy of the metadata
+	metadata["Owner"] = "mallory"
+	got.Metadata["Owner"] = "mallory"
+	got, err = s.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Owner": "alice"}, got.Metadata)
+
+	snap := s.Snapshot()
+	assert.Equal(t, map[string]string{"foo": "application/json"}, snap.ContentTypes)
+	assert.Equal(t, map[string]map[string]string{"foo": {"Owner": "alice"}}, snap.Metadata)
+
+	// a put without any leaves the key with none
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("bar")))
+	got, err = s.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{Value: []byte("bar"), Version: 2}, got)
+
+	s.Restore(snap)
+	got, err = s.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	require.NoError(t, s.Delete(t.Context(), "foo"))
+	assert.Empty(t, s.Snapshot().ContentTypes)
+	assert.Empty(t, s.Snapshot().Metadata)
+}
@@ -0,0 +1,302 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShards is the number of shards a ShardedStore has unless WithShards says otherwise.
+const DefaultShards = 32
+
+var _ Memory = (*ShardedStore)(nil)
+
+func NewShardedStore(opts ...ShardedOption) *ShardedStore {
+	store := &ShardedStore{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*InMemoryStore, DefaultShards),
+	}
+
+	for _, opt := range opts {
+		opt(store)
+	}
+	for i := range store.shards {
+		store.shards[i] = NewInMemoryStore()
+	}
+
+	return store
+}
+
+// ShardedStore is a Store held in memory which spreads its keys across shards by their hash, each an InMemoryStore
+// with its own lock, so that writes to keys in different shards don't wait on each other. It behaves just as a single
+// InMemoryStore would: a write, List or GetMany spanning several shards locks every one of them, in order, for the
+// whole of it, and versions are given out across all of the shards from one counter.
+type ShardedStore struct {
+	seed   maphash.Seed
+	shards []*InMemoryStore
+	// version is the highest version given to any write in any shard, the shards' own versions going unused
+	version atomic.Uint64
+}
+
+func (s *ShardedStore) Put(ctx context.Context, key string, value []byte, opts ...WriteOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	op := PutOp(key, value, opts...)
+	return s.write([]Op{op}, op.opts.version)
+}
+
+func (s *ShardedStore) Get(ctx context.Context, key string) (Entry, error) {
+	return s.shard(key).Get(ctx, key)
+}
+
+func (s *ShardedStore) GetMany(ctx context.Context, keys []string) (map[string]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	defer s.rlock(s.indices(keys))()
+	entries := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if entry, ok := s.shard(key).lookup(key, now); ok {
+			entries[key] = entry
+		}
+	}
+	return entries, nil
+}
+
+func (s *ShardedStore) Delete(ctx context.Context, key string, opts ...WriteOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	op := DeleteOp(key, opts...)
+	return s.write([]Op{op}, op.opts.version)
+}
+
+func (s *ShardedStore) Batch(ctx context.Context, ops []Op, opts ...WriteOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	o := newWriteOptions(opts...)
+	return s.write(ops, o.version)
+}
+
+// write applies ops as InMemoryStore.write does, holding the locks of every shard they touch throughout.
+func (s *ShardedStore) write(ops []Op, version uint64) error {
+	if len(ops) == 1 {
+		// the common case of a single key needs neither the indices nor their sorting
+		shard := s.shard(ops[0].Key)
+		shard.rw.Lock()
+		defer shard.rw.Unlock()
+	} else {
+		keys := make([]string, len(ops))
+		for i, op := range ops {
+			keys[i] = op.Key
+		}
+		defer s.lock(s.indices(keys))()
+	}
+
+	for _, op := range ops {
+		if err := s.shard(op.Key).check(op.Key, op.opts); err != nil {
+			return fmt.Errorf("%w for key %q", err, op.Key)
+		}
+	}
+	version = s.nextVersion(version)
+
+	now := time.Now()
+	for _, op := range ops {
+		s.shard(op.Key).apply(op, version, now)
+	}
+	return nil
+}
+
+// List merges the items each shard would return, each of which is already in order, keeping the first of them up to
+// the limit.
+func (s *ShardedStore) List(ctx context.Context, opts ...ListOption) ([]Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	o := newListOptions(opts...)
+	now := time.Now()
+
+	defer s.rlock(s.all())()
+	var items []Item
+	for _, shard := range s.shards {
+		items = append(items, shard.list(o, now)...)
+	}
+	slices.SortFunc(items, func(a, b Item) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	if o.limit > 0 && len(items) > o.limit {
+		items = items[:o.limit]
+	}
+	return items, nil
+}
+
+// nextVersion returns the version for a write given version, zero meaning the next one along. The caller must hold the
+// write locks of the shards being written, so that the versions of a key only ever go up.
+func (s *ShardedStore) nextVersion(version uint64) uint64 {
+	if version == 0 {
+		return s.version.Add(1)
+	}
+	for {
+		highest := s.version.Load()
+		if highest >= version || s.version.CompareAndSwap(highest, version) {
+			return version
+		}
+	}
+}
+
+// shard returns the shard holding key.
+func (s *ShardedStore) shard(key string) *InMemoryStore {
+	return s.shards[s.index(key)]
+}
+
+func (s *ShardedStore) index(key string) int {
+	return int(maphash.String(s.seed, key) % uint64(len(s.shards))) //nolint:gosec // less than the number of shards
+}
+
+// indices returns the indices of the shards holding keys, in ascending order without repeats.
+func (s *ShardedStore) indices(keys []string) []int {
+	indices := make([]int, len(keys))
+	for i, key := range keys {
+		indices[i] = s.index(key)
+	}
+	slices.Sort(indices)
+	return slices.Compact(indices)
+}
+
+// all returns the indices of every shard.
+func (s *ShardedStore) all() []int {
+	indices := make([]int, len(s.shards))
+	for i := range indices {
+		indices[i] = i
+	}
+	return indices
+}
+
+// lock takes the write locks of the shards at indices, returning a func which releases them. The indices must be in
+// ascending order, so that two callers locking overlapping shards can't each hold one the other is waiting for.
+func (s *ShardedStore) lock(indices []int) func() {
+	for _, i := range indices {
+		s.shards[i].rw.Lock()
+	}
+	return func() {
+		for _, i := range indices {
+			s.shards[i].rw.Unlock()
+		}
+	}
+}
+
+// rlock is lock for the read locks of the shards.
+func (s *ShardedStore) rlock(indices []int) func() {
+	for _, i := range indices {
+		s.shards[i].rw.RLock()
+	}
+	return func() {
+		for _, i := range indices {
+			s.shards[i].rw.RUnlock()
+		}
+	}
+}
+
+// Sweep removes every key which has expired, returning how many it removed. It sweeps one shard at a time, so that
+// it only ever holds up writes to a single shard.
+func (s *ShardedStore) Sweep() int {
+	removed := 0
+	for _, shard := range s.shards {
+		removed += shard.Sweep()
+	}
+	return removed
+}
+
+// Len returns the number of keys in the store, including those which have expired but haven't been removed yet.
+func (s *ShardedStore) Len() int {
+	defer s.rlock(s.all())()
+	n := 0
+	for _, shard := range s.shards {
+		n += len(shard.store)
+	}
+	return n
+}
+
+// Snapshot returns a copy of the store's contents, as they were at a single moment across every shard.
+func (s *ShardedStore) Snapshot() Contents {
+	defer s.rlock(s.all())()
+	c := Contents{
+		Data:         make(map[string]string),
+		Expiry:       make(map[string]time.Time),
+		Versions:     make(map[string]uint64),
+		Version:      s.version.Load(),
+		ContentTypes: make(map[string]string),
+		Metadata:     make(map[string]map[string]string),
+	}
+	for _, shard := range s.shards {
+		maps.Copy(c.Data, shard.store)
+		maps.Copy(c.Expiry, shard.expiry)
+		maps.Copy(c.Versions, shard.versions)
+		maps.Copy(c.ContentTypes, shard.contentTypes)
+		maps.Copy(c.Metadata, shard.metadata)
+	}
+	return c
+}
+
+// Restore replaces the store's contents with c, as InMemoryStore.Restore does, splitting it between the shards first
+// so that every shard is replaced at once.
+func (s *ShardedStore) Restore(c Contents) {
+	c = c.prepare(time.Now())
+
+	parts := make([]Contents, len(s.shards))
+	for i := range parts {
+		parts[i] = Contents{
+			Data:         make(map[string]string),
+			Expiry:       make(map[string]time.Time),
+			Versions:     make(map[string]uint64),
+			Version:      c.Version,
+			ContentTypes: make(map[string]string),
+			Metadata:     make(map[string]map[string]string),
+		}
+	}
+	for key, value := range c.Data {
+		part := parts[s.index(key)]
+		part.Data[key] = value
+		if expires, ok := c.Expiry[key]; ok {
+			part.Expiry[key] = expires
+		}
+		if version, ok := c.Versions[key]; ok {
+			part.Versions[key] = version
+		}
+		if contentType, ok := c.ContentTypes[key]; ok {
+			part.ContentTypes[key] = contentType
+		}
+		if metadata, ok := c.Metadata[key]; ok {
+			part.Metadata[key] = metadata
+		}
+	}
+
+	keys := make([][]string, len(parts))
+	for i, part := range parts {
+		keys[i] = slices.Sorted(maps.Keys(part.Data))
+	}
+
+	defer s.lock(s.all())()
+	for i, shard := range s.shards {
+		shard.replace(parts[i], keys[i])
+	}
+	s.version.Store(c.Version)
+}
+
+type ShardedOption = func(*ShardedStore)
+
+// WithShards sets how many shards the store has, which is at least one.
+func WithShards(n int) ShardedOption {
+	return func(store *ShardedStore) {
+		store.shards = make([]*InMemoryStore, max(n, 1))
+	}
+}
@@ -0,0 +1,274 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+func TestShardedStore(t *testing.T) {
+	s := store.NewShardedStore(store.WithShards(4))
+
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("bar")))
+	require.NoError(t, s.Put(t.Context(), "baz", []byte("bing")))
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("changed")))
+
+	// versions are given out across every shard in turn
+	got, err := s.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{Value: []byte("changed"), Version: 3}, got)
+	got, err = s.Get(t.Context(), "baz")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{Value: []byte("bing"), Version: 2}, got)
+
+	require.NoError(t, s.Delete(t.Context(), "baz"))
+	_, err = s.Get(t.Context(), "baz")
+	require.ErrorIs(t, err, store.ErrNotFound)
+	assert.Equal(t, 1, s.Len())
+
+	// a replayed write keeps its version and moves the counter on to it
+	require.NoError(t, s.Put(t.Context(), "replayed", []byte("x"), store.WithVersion(10)))
+	require.NoError(t, s.Put(t.Context(), "next", []byte("y")))
+	got, err = s.Get(t.Context(), "next")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(11), got.Version)
+
+	require.ErrorIs(t, s.Put(t.Context(), "foo", []byte("stale"), store.IfVersion(1)), store.ErrVersionMismatch)
+	require.ErrorIs(t, s.Delete(t.Context(), "next", store.IfVersion(0)), store.ErrVersionMismatch)
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("fresh"), store.IfVersion(3)))
+}
+
+func TestShardedStore_OutOfOrderWrites(t *testing.T) {
+	s := store.NewShardedStore(store.WithShards(4))
+
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("newer"), store.WithVersion(11)))
+	require.NoError(t, s.Delete(t.Context(), "bar", store.WithVersion(12)))
+	require.NoError(t, s.Batch(t.Context(), []store.Op{
+		store.PutOp("foo", []byte("older")),
+		store.PutOp("bar", []byte("older")),
+		store.PutOp("baz", []byte("new")),
+	}, store.WithVersion(10)))
+
+	got, err := s.GetMany(t.Context(), []string{"foo", "bar", "baz"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]store.Entry{
+		"foo": {Value: []byte("newer"), Version: 11},
+		"baz": {Value: []byte("new"), Version: 10},
+	}, got)
+}
+
+func TestShardedStore_Shards(t *testing.T) {
+	// fewer than one shard still leaves somewhere to put keys
+	s := store.NewShardedStore(store.WithShards(0))
+
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("bar")))
+	got, err := s.Get(t.Context(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bar"), got.Value)
+}
+
+func TestShardedStore_List(t *testing.T) {
+	s := store.NewShardedStore(store.WithShards(4))
+	for i := range 20 {
+		require.NoError(t, s.Put(t.Context(), fmt.Sprintf("key-%02d", i), []byte(fmt.Sprint(i))))
+	}
+	require.NoError(t, s.Put(t.Context(), "other", []byte("x")))
+
+	items, err := s.List(t.Context(), store.WithPrefix("key-"), store.StartAfter("key-05"), store.WithLimit(3))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key-06", "key-07", "key-08"}, keysOf(items))
+	assert.Equal(t, store.Entry{Value: []byte("6"), Version: 7}, items[0].Entry)
+
+	items, err = s.List(t.Context())
+	require.NoError(t, err)
+	require.Len(t, items, 21)
+	assert.Equal(t, "key-00", items[0].Key)
+	assert.Equal(t, "other", items[20].Key)
+
+	items, err = s.List(t.Context(), store.WithPrefix("missing"))
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestShardedStore_Batch(t *testing.T) {
+	s := store.NewShardedStore(store.WithShards(4))
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("bar")))
+	require.NoError(t, s.Put(t.Context(), "baz", []byte("bing")))
+
+	require.NoError(t, s.Batch(t.Context(), []store.Op{
+		store.PutOp("foo", []byte("changed"), store.IfVersion(1)),
+		store.DeleteOp("baz", store.IfVersion(2)),
+		store.PutOp("new", []byte("key"), store.IfVersion(0)),
+	}))
+	assert.Equal(t, map[string]string{"foo": "changed", "new": "key"}, s.Snapshot().Data)
+	assert.Equal(t, map[string]uint64{"foo": 3, "new": 3}, s.Snapshot().Versions)
+
+	// the batch is turned away as a whole, even though its keys are spread across shards
+	ops := make([]store.Op, 0, 11)
+	for i := range 10 {
+		ops = append(ops, store.PutOp(fmt.Sprint(i), []byte("x")))
+	}
+	ops = append(ops, store.DeleteOp("new", store.IfVersion(1)))
+	err := s.Batch(t.Context(), ops)
+	require.ErrorIs(t, err, store.ErrVersionMismatch)
+	assert.ErrorContains(t, err, `"new"`)
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, uint64(3), s.Snapshot().Version)
+}
+
+func TestShardedStore_GetMany(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := store.NewShardedStore(store.WithShards(4))
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("bar")))
+		require.NoError(t, s.Put(t.Context(), "baz", []byte("bing"), store.ExpiresAt(time.Now().Add(time.Minute))))
+
+		entries, err := s.GetMany(t.Context(), []string{"foo", "baz", "missing", "foo"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]store.Entry{"foo": {Value: []byte("bar"), Version: 1}, "baz": {Value: []byte("bing"), Version: 2}}, entries)
+
+		time.Sleep(time.Minute)
+		entries, err = s.GetMany(t.Context(), []string{"foo", "baz"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]store.Entry{"foo": {Value: []byte("bar"), Version: 1}}, entries)
+	})
+}
+
+func TestShardedStore_Expiry(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := store.NewShardedStore(store.WithShards(4))
+		for i := range 10 {
+			require.NoError(t, s.Put(t.Context(), fmt.Sprint(i), []byte("x"), store.ExpiresAt(time.Now().Add(time.Minute))))
+		}
+		require.NoError(t, s.Put(t.Context(), "forever", []byte("young")))
+
+		assert.Equal(t, 0, s.Sweep())
+		time.Sleep(time.Minute)
+
+		_, err := s.Get(t.Context(), "0")
+		require.ErrorIs(t, err, store.ErrNotFound)
+		items, err := s.List(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"forever"}, keysOf(items))
+
+		// the key Get found expired is already gone
+		assert.Equal(t, 9, s.Sweep())
+		assert.Equal(t, 1, s.Len())
+	})
+}
+
+func TestShardedStore_Restore(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := store.NewShardedStore(store.WithShards(4))
+		require.NoError(t, s.Put(t.Context(), "old", []byte("gone")))
+
+		now := time.Now()
+		s.Restore(store.Contents{
+			Data:     map[string]string{"expired": "a", "expiring": "b", "forever": "c", "unversioned": "d"},
+			Expiry:   map[string]time.Time{"expired": now.Add(-time.Second), "expiring": now.Add(time.Minute)},
+			Versions: map[string]uint64{"expiring": 4, "forever": 9},
+			Version:  7,
+		})
+
+		snap := s.Snapshot()
+		assert.Equal(t, map[string]string{"expiring": "b", "forever": "c", "unversioned": "d"}, snap.Data)
+		assert.Equal(t, map[string]time.Time{"expiring": now.Add(time.Minute)}, snap.Expiry)
+		assert.Equal(t, map[string]uint64{"expiring": 4, "forever": 9, "unversioned": 7}, snap.Versions)
+		assert.Equal(t, uint64(9), snap.Version)
+
+		items, err := s.List(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"expiring", "forever", "unversioned"}, keysOf(items))
+
+		require.NoError(t, s.Put(t.Context(), "new", []byte("e")))
+		got, err := s.Get(t.Context(), "new")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(10), got.Version)
+
+		// the snapshot is a copy, unaffected by later writes
+		assert.NotContains(t, snap.Data, "new")
+	})
+}
+
+func TestShardedStore_Metadata(t *testing.T) {
+	s := store.NewShardedStore(store.WithShards(4))
+	for i := range 10 {
+		require.NoError(t, s.Put(t.Context(), fmt.Sprint(i), []byte("x"),
+			store.WithContentType("text/plain"), store.WithMetadata(map[string]string{"Index": fmt.Sprint(i)})))
+	}
+
+	snap := s.Snapshot()
+	assert.Len(t, snap.ContentTypes, 10)
+	assert.Equal(t, map[string]string{"Index": "3"}, snap.Metadata["3"])
+
+	restored := store.NewShardedStore(store.WithShards(3))
+	restored.Restore(snap)
+	got, err := restored.Get(t.Context(), "7")
+	require.NoError(t, err)
+	assert.Equal(t, store.Entry{
+		Value: []byte("x"), Version: 8, ContentType: "text/plain", Metadata: map[string]string{"Index": "7"},
+	}, got)
+}
+
+func TestShardedStore_Cancelled(t *testing.T) {
+	s := store.NewShardedStore()
+	require.NoError(t, s.Put(t.Context(), "foo", []byte("bar")))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	assert.ErrorIs(t, s.Put(ctx, "baz", []byte("bing")), context.Canceled)
+	_, err := s.Get(ctx, "foo")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, s.Delete(ctx, "foo"), context.Canceled)
+	_, err = s.List(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, s.Batch(ctx, []store.Op{store.DeleteOp("foo")}), context.Canceled)
+	_, err = s.GetMany(ctx, []string{"foo"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, map[string]string{"foo": "bar"}, s.Snapshot().Data)
+}
+
+func TestShardedStore_Concurrency(t *testing.T) {
+	const (
+		numGoroutines = 8
+		numOperations = 200
+	)
+
+	s := store.NewShardedStore(store.WithShards(4))
+
+	var wg sync.WaitGroup
+	for i := range numGoroutines {
+		wg.Go(func() {
+			for j := range numOperations {
+				key := fmt.Sprint(j % 10)
+				switch j % 5 {
+				case 0:
+					assert.NoError(t, s.Put(t.Context(), key, []byte(fmt.Sprintf("%v-%v", i, j))))
+				case 1:
+					assert.NoError(t, s.Delete(t.Context(), key))
+				case 2:
+					// batches locking overlapping shards in any order mustn't deadlock
+					assert.NoError(t, s.Batch(t.Context(), []store.Op{store.PutOp(key, []byte("a")), store.PutOp(fmt.Sprint(9-j%10), []byte("b"))}))
+				case 3:
+					_, err := s.List(t.Context())
+					assert.NoError(t, err)
+				case 4:
+					_, err := s.GetMany(t.Context(), []string{key, "1", "2"})
+					assert.NoError(t, err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	// every write took its own version, whichever shard it went to
+	assert.Equal(t, uint64(numGoroutines*numOperations*3/5), s.Snapshot().Version)
+}
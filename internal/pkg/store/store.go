@@ -1,11 +1,183 @@
 package store
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
-var ErrNotFound = errors.New("key not found")
+var (
+	ErrNotFound = errors.New("key not found")
+	// ErrVersionMismatch is returned by a write made with IfVersion when the key isn't at the version expected.
+	ErrVersionMismatch = errors.New("version mismatch")
+)
 
+// Store holds the current value of each key, which may be any bytes at all. A value is copied on its way in and out, so
+// a caller is free to reuse the slice it put and to change the one it got. Operations give up with the context's error
+// if it is done before they start. List returns keys in ascending byte order, so that a listing can be paged through
+// with StartAfter. GetMany returns the entries of those keys which exist, all as they were at the same moment.
+//
+// Batch applies ops, in order, all or nothing: if the precondition of any op doesn't hold none of them are applied. The
+// keys it writes are all given the same version, which WithVersion sets for the whole batch.
 type Store interface {
-	Put(key, value string) error
-	Get(key string) (string, error)
-	Delete(key string) error
+	Put(ctx context.Context, key string, value []byte, opts ...WriteOption) error
+	Get(ctx context.Context, key string) (Entry, error)
+	GetMany(ctx context.Context, keys []string) (map[string]Entry, error)
+	Delete(ctx context.Context, key string, opts ...WriteOption) error
+	List(ctx context.Context, opts ...ListOption) ([]Item, error)
+	Batch(ctx context.Context, ops []Op, opts ...WriteOption) error
+}
+
+// Memory is a Store held in memory, such as an InMemoryStore or a ShardedStore, whose expired keys need sweeping out
+// and whose contents can be copied and replaced as a whole.
+type Memory interface {
+	Store
+	// Sweep removes every key which has expired, returning how many it removed.
+	Sweep() int
+	// Len returns the number of keys in the store, including those which have expired but haven't been removed yet.
+	Len() int
+	Snapshot() Contents
+	Restore(c Contents)
+}
+
+// Entry is the value held for a key along with its version. Every write to a key gives it a higher version than it had
+// before, so a client can tell whether the key has changed since it last read it. The value and metadata are the
+// caller's own copies.
+type Entry struct {
+	Value   []byte
+	Version uint64
+	// ContentType is the media type the value was put with, or empty if it wasn't given one.
+	ContentType string
+	// Metadata holds the metadata the value was put with by name, and is nil if it had none.
+	Metadata map[string]string
+}
+
+// Op is a put or delete of a single key made as part of a Batch.
+type Op struct {
+	Key   string
+	Value []byte
+	// Delete is set for an op which deletes the key rather than putting Value.
+	Delete bool
+
+	opts writeOptions
+}
+
+// PutOp returns an op which puts value for key, with opts such as ExpiresAt and IfVersion applied to it.
+func PutOp(key string, value []byte, opts ...WriteOption) Op {
+	return Op{Key: key, Value: value, opts: newWriteOptions(opts...)}
+}
+
+// DeleteOp returns an op which deletes key, with opts such as IfVersion applied to it.
+func DeleteOp(key string, opts ...WriteOption) Op {
+	return Op{Key: key, Delete: true, opts: newWriteOptions(opts...)}
+}
+
+// WriteOption modifies how Put, Delete or an Op changes a key.
+type WriteOption = func(*writeOptions)
+
+type writeOptions struct {
+	expires time.Time
+	// version is the version the write gives the key, zero meaning the next one along
+	version uint64
+	// ifVersion is the version the key must be at, zero meaning the key must not exist, if conditional is set
+	ifVersion   uint64
+	conditional bool
+	contentType string
+	metadata    map[string]string
+}
+
+func newWriteOptions(opts ...WriteOption) writeOptions {
+	var o writeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// ExpiresAt makes the key put expire at t, after which it is no longer found. A time which has already passed deletes
+// the key, and a zero time leaves it to never expire, which is also what happens without the option, even if the key
+// previously had an expiry. It has no effect on a Delete.
+func ExpiresAt(t time.Time) WriteOption {
+	return func(o *writeOptions) {
+		o.expires = t
+	}
+}
+
+// WithVersion makes the write give the key version v rather than the next version along, for replaying a write which
+// was given its version when it was logged. A batch takes it for all of its ops together, and ignores it on an op.
+func WithVersion(v uint64) WriteOption {
+	return func(o *writeOptions) {
+		o.version = v
+	}
+}
+
+// IfVersion makes the write fail with ErrVersionMismatch unless the key is at version v, or doesn't exist when v is
+// zero. The check and the write happen atomically.
+func IfVersion(v uint64) WriteOption {
+	return func(o *writeOptions) {
+		o.ifVersion = v
+		o.conditional = true
+	}
+}
+
+// WithContentType records the media type of the value put, which is returned along with it. A put without it leaves
+// the key without one, even if it previously had one. It has no effect on a Delete.
+func WithContentType(contentType string) WriteOption {
+	return func(o *writeOptions) {
+		o.contentType = contentType
+	}
+}
+
+// WithMetadata records metadata, such as who owns the value put, which is returned along with it. As with
+// WithContentType, a put without it leaves the key with none.
+func WithMetadata(metadata map[string]string) WriteOption {
+	return func(o *writeOptions) {
+		o.metadata = metadata
+	}
+}
+
+// Item is a key along with its entry, as returned by List.
+type Item struct {
+	Entry
+
+	Key string
+}
+
+// ListOption modifies which keys List returns.
+type ListOption = func(*listOptions)
+
+type listOptions struct {
+	prefix string
+	after  string
+	// limit is the most items returned, zero meaning no limit
+	limit int
+}
+
+func newListOptions(opts ...ListOption) listOptions {
+	var o listOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithPrefix makes List return only the keys which start with prefix.
+func WithPrefix(prefix string) ListOption {
+	return func(o *listOptions) {
+		o.prefix = prefix
+	}
+}
+
+// StartAfter makes List return only the keys which sort after key, such as the last key of the previous page.
+func StartAfter(key string) ListOption {
+	return func(o *listOptions) {
+		o.after = key
+	}
+}
+
+// WithLimit makes List return at most n items. Zero or less means no limit, which is also the default.
+func WithLimit(n int) ListOption {
+	return func(o *listOptions) {
+		o.limit = max(n, 0)
+	}
 }
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"log/slog"
+	"time"
+)
+
+// NewSweeper returns a Sweeper which sweeps expired keys from s every interval.
+func NewSweeper(s Memory, interval time.Duration) *Sweeper {
+	return &Sweeper{store: s, interval: interval}
+}
+
+// Sweeper periodically removes expired keys from a Memory store, so that keys which are never read again don't
+// hold on to memory after they expire.
+type Sweeper struct {
+	store    Memory
+	interval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Run sweeps the store every interval until Close is called.
+func (s *Sweeper) Run() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if removed := s.store.Sweep(); removed > 0 {
+					slog.Debug("swept expired keys", slog.Int("keys", removed))
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the sweeps started by Run.
+func (s *Sweeper) Close() error {
+	if s.stop == nil {
+		return nil
+	}
+
+	close(s.stop)
+	<-s.done
+	return nil
+}
@@ -0,0 +1,34 @@
+package store_test
+
+import (
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+func TestSweeper(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		testStorage := make(map[string]string)
+		s := store.NewInMemoryStore(store.WithStorage(testStorage))
+		require.NoError(t, s.Put(t.Context(), "foo", []byte("bar"), store.ExpiresAt(time.Now().Add(90*time.Second))))
+
+		sweeper := store.NewSweeper(s, time.Minute)
+		sweeper.Run()
+
+		time.Sleep(time.Minute)
+		synctest.Wait()
+		assert.Equal(t, 1, s.Len())
+
+		// the key is swept without anything looking it up
+		time.Sleep(time.Minute)
+		synctest.Wait()
+		assert.Equal(t, 0, s.Len())
+
+		require.NoError(t, sweeper.Close())
+	})
+}
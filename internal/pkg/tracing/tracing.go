@@ -0,0 +1,90 @@
+// Package tracing sets up OpenTelemetry tracing for the API server, exporting spans over OTLP/HTTP and propagating
+// W3C trace context. The rest of the code creates its spans through the global tracer provider, which drops them
+// unless Setup has been given a collector to export to.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/treyburn/lockbox/internal/pkg/config"
+)
+
+// Setup installs the global propagator, and the global tracer provider if cfg has an endpoint to export to. The
+// returned function flushes the spans which haven't been exported yet, and must be called before exiting.
+func Setup(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	// trace context is passed on even when we aren't recording spans of our own
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// like OTEL_EXPORTER_OTLP_ENDPOINT, the endpoint is the collector's base URL rather than where traces are sent
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.Endpoint, "/")+"/v1/traces"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create span exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe service: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for each request, continuing the trace of the caller if the request carries one.
+// Spans are named after the route's template rather than the path, and so it must be used on a mux router.
+func Middleware(next http.Handler) http.Handler {
+	route := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if template := routeTemplate(r); template != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(template))
+		}
+		next.ServeHTTP(w, r)
+	})
+
+	return otelhttp.NewHandler(route, "http.request", otelhttp.WithSpanNameFormatter(spanName))
+}
+
+// spanName names the span for r by its method and route.
+func spanName(_ string, r *http.Request) string {
+	if template := routeTemplate(r); template != "" {
+		return r.Method + " " + template
+	}
+	return r.Method
+}
+
+// routeTemplate returns the path template of the route r matched, or an empty string.
+func routeTemplate(r *http.Request) string {
+	current := mux.CurrentRoute(r)
+	if current == nil {
+		return ""
+	}
+	template, err := current.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return template
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+
+	"github.com/treyburn/lockbox/internal/pkg/config"
+)
+
+// TestMiddleware tests that requests are traced by route, continuing the trace of the caller
+func TestMiddleware(t *testing.T) {
+	flush, err := Setup(t.Context(), config.TracingConfig{ServiceName: "lockbox", SampleRatio: 1})
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, flush(context.Background()))
+	}()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	r := mux.NewRouter()
+	r.Use(Middleware)
+	r.HandleFunc("/v1/{key}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}).Methods(http.MethodGet)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest(http.MethodGet, "/v1/some-key", nil)
+	request.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), request)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET /v1/{key}", spans[0].Name())
+	assert.Equal(t, traceID, spans[0].SpanContext().TraceID().String())
+	assert.True(t, spans[0].Parent().IsRemote())
+	assert.Contains(t, spans[0].Attributes(), semconv.HTTPRoute("/v1/{key}"))
+	assert.Contains(t, spans[0].Attributes(), semconv.HTTPResponseStatusCode(http.StatusTeapot))
+}
+
+// TestSetup_Endpoint tests that an endpoint installs a tracer provider which exports to it
+func TestSetup_Endpoint(t *testing.T) {
+	exported := make(chan struct{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		select {
+		case exported <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	flush, err := Setup(t.Context(), config.TracingConfig{Endpoint: collector.URL, ServiceName: "lockbox", SampleRatio: 1})
+	require.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "some-span")
+	span.End()
+
+	require.NoError(t, flush(context.Background()))
+	select {
+	case <-exported:
+	default:
+		t.Fatal("span wasn't exported")
+	}
+}
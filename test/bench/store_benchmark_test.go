@@ -0,0 +1,101 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/treyburn/lockbox/internal/pkg/store"
+)
+
+// The goal of these benchmarks was to determine whether splitting the [store.InMemoryStore] into shards, each with its
+// own lock, as the [store.ShardedStore] does, relieves the contention of every Put waiting on every other read and
+// write. Each goroutine works through the same set of keys from a different starting point.
+//
+// These results were taken on a single vCPU, where no two goroutines ever hold a lock at the same time, so they only
+// show what sharding costs - hashing the key and the extra work of the write path - without any contention for it to
+// relieve. Run them with -cpu set to several cores on a machine which has them to see the difference it makes.
+//
+// Benchmark Results (Intel Xeon Processor, 1 vCPU, 32 shards)
+// ┌────────────────────────────┬──────────────┐
+// │ Benchmark                  │       ns/op  │
+// ├────────────────────────────┼──────────────┤
+// │ Mixed Workload             │              │
+// │   InMemory                 │      137.05  │
+// │   Sharded                  │      166.75  │
+// ├────────────────────────────┼──────────────┤
+// │ Write Heavy                │              │
+// │   InMemory                 │      239.80  │
+// │   Sharded                  │      329.05  │
+// ├────────────────────────────┼──────────────┤
+// │ Read Heavy                 │              │
+// │   InMemory                 │       95.51  │
+// │   Sharded                  │      121.30  │
+// └────────────────────────────┴──────────────┘
+
+const benchmarkKeys = 1024
+
+var value = []byte("value")
+
+// populated returns s with every one of the benchmark keys put, and the keys themselves.
+func populated(b *testing.B, s store.Store) (store.Store, []string) {
+	b.Helper()
+	keys := make([]string, benchmarkKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%04d", i)
+		if err := s.Put(context.Background(), keys[i], value); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s, keys
+}
+
+// benchmarkStore puts one key in every writeEvery operations and gets one the rest of the time, from every goroutine.
+func benchmarkStore(b *testing.B, s store.Store, writeEvery int) {
+	s, keys := populated(b, s)
+	ctx := context.Background()
+	var goroutines atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(goroutines.Add(1)) * (benchmarkKeys / 16)
+		for pb.Next() {
+			key := keys[i%benchmarkKeys]
+			switch i % writeEvery {
+			case 0:
+				_ = s.Put(ctx, key, value)
+			default:
+				_, _ = s.Get(ctx, key)
+			}
+			i++
+		}
+	})
+}
+
+// Mixed workload with contention: 80% reads, 20% writes
+func BenchmarkInMemory_MixedWorkload(b *testing.B) {
+	benchmarkStore(b, store.NewInMemoryStore(), 5)
+}
+
+func BenchmarkSharded_MixedWorkload(b *testing.B) {
+	benchmarkStore(b, store.NewShardedStore(), 5)
+}
+
+// Write-heavy workload: 50% reads, 50% writes
+func BenchmarkInMemory_WriteHeavy(b *testing.B) {
+	benchmarkStore(b, store.NewInMemoryStore(), 2)
+}
+
+func BenchmarkSharded_WriteHeavy(b *testing.B) {
+	benchmarkStore(b, store.NewShardedStore(), 2)
+}
+
+// Read-heavy workload: 95% reads, 5% writes
+func BenchmarkInMemory_ReadHeavy(b *testing.B) {
+	benchmarkStore(b, store.NewInMemoryStore(), 20)
+}
+
+func BenchmarkSharded_ReadHeavy(b *testing.B) {
+	benchmarkStore(b, store.NewShardedStore(), 20)
+}